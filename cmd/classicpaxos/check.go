@@ -0,0 +1,59 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+// runCheck model checks Classic Paxos exhaustively for a small number of
+// participants, and prints a counterexample if agreement can be violated.
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	var nProposers = flags.Int("proposers", 2, "number of proposers")
+	var nAcceptors = flags.Int("acceptors", 3, "number of acceptors")
+	var rounds = flags.Int("rounds", 1,
+		"number of rounds that each proposer may start")
+	var quorums quorumSystem
+	flags.Var(&quorums, "quorums",
+		"quorum system: majority, flexible:PHASE1,PHASE2 (quorum sizes),\n"+
+			"weighted:WEIGHT,WEIGHT,... (acceptor weights) or grid:ROWSxCOLUMNS;\n"+
+			"quorums that do not intersect are allowed")
+	var maxStates = flags.Int("max-states", 10000000,
+		"if positive, number of states after which to give up")
+	flags.Parse(args)
+
+	m := classicpaxos.ModelCheck{NProposers: *nProposers,
+		NAcceptors: *nAcceptors, MaxRounds: *rounds,
+		Quorums: quorums.QuorumSystem, MaxStates: *maxStates}
+
+	result, err := m.Run()
+	fmt.Printf("explored %d states\n", result.States)
+	if result.Counterexample != nil {
+		fmt.Println("counterexample:")
+		for i, step := range result.Counterexample {
+			fmt.Printf("%3d. %s\n", i+1, step)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("yay! no interleaving of messages and timeouts violates agreement")
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+	"github.com/b9r5/learn-paxos/raft"
+)
+
+// runCompare runs Classic Paxos and Raft on the same seeds, lossy channels
+// and fault schedules, and prints how many rounds and messages each took to
+// decide, and how long, side by side. The proposers of Classic Paxos are the
+// clients of Raft, and its acceptors are the servers of Raft.
+func runCompare(args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var nProposers = flags.Int("proposers", 3,
+		"number of proposers, or clients of Raft")
+	var nAcceptors = flags.Int("acceptors", 5,
+		"number of acceptors, or servers of Raft")
+	var proposerTimeout = flags.Duration("proposer-timeout",
+		100*time.Millisecond,
+		"time for proposer to wait for promise and accept messages, or for a\n"+
+			"client of Raft to wait for a reply")
+	var electionTimeout = flags.Duration("election-timeout",
+		150*time.Millisecond,
+		"least time for a server of Raft to wait for a leader before starting\n"+
+			"an election")
+	var heartbeat = flags.Duration("heartbeat-interval", 50*time.Millisecond,
+		"time between the heartbeats of a leader of Raft")
+	var channelTimeout = flags.Duration("channel-timeout", 10*time.Millisecond,
+		"time to wait for lossy channel buffer to fill before returning a message")
+	var buffer = flags.Int("buffer-size", 2,
+		"number of messages to buffer before returning one selected randomly")
+	var drop = flags.Float64("drop-probability", 0.1,
+		"probability of lossy channel dropping a message, in range [0, 1)")
+	var seed = flags.Int64("seed", 1,
+		"seed of the first run, which is simulated with a virtual clock")
+	var runs = flags.Int("runs", 1,
+		"number of runs of each protocol, with consecutive seeds")
+	var crashAcceptors acceptorFaults
+	flags.Var(&crashAcceptors, "crash-acceptor",
+		"crash acceptor, or server, ID at time CRASH, and optionally restart it\n"+
+			"at time RESTART, given as ID@CRASH or ID@CRASH-RESTART (may be\n"+
+			"repeated)")
+	var partitions partitions
+	flags.Var(&partitions, "partition",
+		"partition the network into groups of nodes (pID or aID) from time\n"+
+			"START until time HEAL, given as GROUP|GROUP@START or\n"+
+			"GROUP|GROUP@START-HEAL, e.g., p0,a0|a1,a2@0s-1s (may be repeated)")
+	var cutLinks linkCuts
+	flags.Var(&cutLinks, "cut-link",
+		"lose messages from node FROM to node TO from time START until time\n"+
+			"HEAL, given as FROM>TO@START or FROM>TO@START-HEAL (may be repeated)")
+	flags.Parse(args)
+
+	if *seed == 0 {
+		return fmt.Errorf("compare needs a non-zero seed, so that both " +
+			"protocols see the same choices")
+	}
+
+	var serverFaults []raft.ServerFault
+	for _, f := range crashAcceptors {
+		serverFaults = append(serverFaults, raft.ServerFault{
+			Server: f.Acceptor, CrashAt: f.CrashAt, RestartAt: f.RestartAt})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "seed\tprotocol\trounds\tmessages\tmean latency\t"+
+		"max latency\tresult")
+
+	failed := false
+	for s := *seed; s < *seed+int64(*runs); s++ {
+		paxos := classicpaxos.Config{
+			NProposers:      *nProposers,
+			NAcceptors:      *nAcceptors,
+			ProposerTimeout: *proposerTimeout,
+			ChannelTimeout:  *channelTimeout,
+			Buffer:          *buffer,
+			Drop:            *drop,
+			Seed:            s,
+			AcceptorFaults:  crashAcceptors,
+			Partitions:      partitions,
+			LinkCuts:        cutLinks,
+			Output:          io.Discard,
+		}
+		var m classicpaxos.Metrics
+		cluster, err := paxos.Start(context.Background())
+		if err == nil {
+			err = cluster.Wait()
+			m = cluster.Metrics()
+		}
+		failed = failed || err != nil
+		fmt.Fprintf(w, "%d\tClassic Paxos\t%d\t%d\t%s\t%s\t%s\n", s, m.Rounds,
+			m.Messages, m.MeanLatency, m.MaxLatency, result(err))
+
+		rc := raft.Config{
+			NServers:          *nAcceptors,
+			NClients:          *nProposers,
+			ElectionTimeout:   *electionTimeout,
+			HeartbeatInterval: *heartbeat,
+			ClientTimeout:     *proposerTimeout,
+			ChannelTimeout:    *channelTimeout,
+			Buffer:            *buffer,
+			Drop:              *drop,
+			Seed:              s,
+			Faults:            serverFaults,
+			Partitions:        raftPartitions(partitions),
+			LinkCuts:          raftLinkCuts(cutLinks),
+			Output:            io.Discard,
+		}
+		r, err := rc.Run()
+		failed = failed || err != nil
+		fmt.Fprintf(w, "%d\tRaft\t%d\t%d\t%s\t%s\t%s\n", s, r.Elections,
+			r.Messages, r.MeanLatency, r.MaxLatency, result(err))
+	}
+	w.Flush()
+	fmt.Println("rounds are the rounds that the proposers started in Classic " +
+		"Paxos, and the elections in Raft")
+
+	if failed {
+		return fmt.Errorf("some runs failed")
+	}
+	return nil
+}
+
+// result returns the string form of the outcome of a run that returned err.
+func result(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "agreed"
+}
+
+// raftNode returns the node of Raft that corresponds to the node n of Classic
+// Paxos: the server for an acceptor, and the client for a proposer.
+func raftNode(n classicpaxos.Node) classicpaxos.Node {
+	if n.Role == classicpaxos.RoleAcceptor {
+		return raft.ServerNode(n.ID)
+	}
+	return n
+}
+
+// raftPartitions returns the partitions p with their nodes replaced by those
+// of Raft.
+func raftPartitions(p []classicpaxos.Partition) []classicpaxos.Partition {
+	var partitions []classicpaxos.Partition
+	for _, partition := range p {
+		groups := make([][]classicpaxos.Node, len(partition.Groups))
+		for i, group := range partition.Groups {
+			for _, n := range group {
+				groups[i] = append(groups[i], raftNode(n))
+			}
+		}
+		partition.Groups = groups
+		partitions = append(partitions, partition)
+	}
+	return partitions
+}
+
+// raftLinkCuts returns the link cuts l with their nodes replaced by those of
+// Raft.
+func raftLinkCuts(l []classicpaxos.LinkCut) []classicpaxos.LinkCut {
+	var cuts []classicpaxos.LinkCut
+	for _, cut := range l {
+		cut.From, cut.To = raftNode(cut.From), raftNode(cut.To)
+		cuts = append(cuts, cut)
+	}
+	return cuts
+}
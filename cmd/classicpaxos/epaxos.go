@@ -0,0 +1,102 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/b9r5/learn-paxos/epaxos"
+)
+
+// replicaFaults is a flag.Value for a list of replica faults, each of the form
+// ID@CRASH-RESTART, e.g., 1@50ms-200ms.
+type replicaFaults []epaxos.ReplicaFault
+
+func (r *replicaFaults) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *replicaFaults) Set(s string) error {
+	id, times, err := parseFault(s)
+	if err != nil {
+		return err
+	}
+
+	f := epaxos.ReplicaFault{Replica: id}
+	if f.CrashAt, f.RestartAt, err = parseInterval(times); err != nil {
+		return err
+	}
+
+	*r = append(*r, f)
+	return nil
+}
+
+// runEPaxos runs EPaxos over the same lossy channels and network faults as
+// Classic Paxos, and checks that the replicas executed the commands on each
+// key in the same order.
+func runEPaxos(args []string) error {
+	flags := flag.NewFlagSet("epaxos", flag.ExitOnError)
+	var nReplicas = flags.Int("replicas", 5, "number of replicas")
+	var nCommands = flags.Int("commands", 3,
+		"number of commands that each replica leads")
+	var nKeys = flags.Int("keys", 2,
+		"number of keys that the commands write; commands on the same key\n"+
+			"interfere, and so may take the slow path")
+	var timeout = flags.Duration("timeout", 50*time.Millisecond,
+		"time replica waits for replies before retransmitting")
+	var channelTimeout = flags.Duration("channel-timeout", 10*time.Millisecond,
+		"time to wait for lossy channel buffer to fill before returning a message")
+	var buffer = flags.Int("buffer-size", 2,
+		"number of messages to buffer before returning one selected randomly")
+	var drop = flags.Float64("drop-probability", 0.1,
+		"probability of lossy channel dropping a message, in range [0, 1)")
+	var seed = flags.Int64("seed", 0,
+		"if non-zero, seed for a repeatable run simulated with a virtual clock")
+	var crashReplicas replicaFaults
+	flags.Var(&crashReplicas, "crash-replica",
+		"crash replica ID at time CRASH and restart it at time RESTART, given\n"+
+			"as ID@CRASH-RESTART (may be repeated)")
+	var partitions partitions
+	flags.Var(&partitions, "partition",
+		"partition the network into groups of replicas (rID) from time START\n"+
+			"until time HEAL, given as GROUP|GROUP@START or GROUP|GROUP@START-HEAL,\n"+
+			"e.g., r0,r1|r2,r3,r4@0s-1s (may be repeated)")
+	var cutLinks linkCuts
+	flags.Var(&cutLinks, "cut-link",
+		"lose messages from replica FROM to replica TO from time START until\n"+
+			"time HEAL, given as FROM>TO@START or FROM>TO@START-HEAL (may be\n"+
+			"repeated)")
+	flags.Parse(args)
+
+	c := epaxos.Config{
+		NReplicas:      *nReplicas,
+		NCommands:      *nCommands,
+		NKeys:          *nKeys,
+		Timeout:        *timeout,
+		ChannelTimeout: *channelTimeout,
+		Buffer:         *buffer,
+		Drop:           *drop,
+		Seed:           *seed,
+		Faults:         crashReplicas,
+		Partitions:     partitions,
+		LinkCuts:       cutLinks,
+	}
+
+	metrics, err := c.Run()
+	fmt.Println(metrics)
+	return err
+}
@@ -0,0 +1,393 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+// acceptorFaults is a flag.Value for a list of acceptor faults, each of the
+// form ID@CRASH or ID@CRASH-RESTART, e.g., 2@50ms-200ms.
+type acceptorFaults []classicpaxos.AcceptorFault
+
+func (a *acceptorFaults) String() string {
+	return fmt.Sprint(*a)
+}
+
+func (a *acceptorFaults) Set(s string) error {
+	id, times, err := parseFault(s)
+	if err != nil {
+		return err
+	}
+
+	f := classicpaxos.AcceptorFault{Acceptor: id}
+	if f.CrashAt, f.RestartAt, err = parseInterval(times); err != nil {
+		return err
+	}
+
+	*a = append(*a, f)
+	return nil
+}
+
+// proposerFaults is a flag.Value for a list of proposer faults, each of the
+// form ID@CRASH, e.g., 0@100ms.
+type proposerFaults []classicpaxos.ProposerFault
+
+func (p *proposerFaults) String() string {
+	return fmt.Sprint(*p)
+}
+
+func (p *proposerFaults) Set(s string) error {
+	id, crash, err := parseFault(s)
+	if err != nil {
+		return err
+	}
+
+	f := classicpaxos.ProposerFault{Proposer: id}
+	if f.CrashAt, err = time.ParseDuration(crash); err != nil {
+		return err
+	}
+
+	*p = append(*p, f)
+	return nil
+}
+
+// parseFault splits a fault of the form ID@TIMES into the participant
+// identifier and the times.
+func parseFault(s string) (int, string, error) {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("fault %q is not of the form ID@TIMES", s)
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, parts[1], nil
+}
+
+// maliciousAcceptors is a flag.Value for a list of malicious acceptors, each
+// of the form ID:BEHAVIOR, e.g., 3:equivocate.
+type maliciousAcceptors []classicpaxos.MaliciousAcceptor
+
+func (m *maliciousAcceptors) String() string {
+	return fmt.Sprint(*m)
+}
+
+func (m *maliciousAcceptors) Set(s string) error {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malicious acceptor %q is not of the form ID:BEHAVIOR",
+			s)
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return err
+	}
+
+	behavior, err := classicpaxos.ParseMisbehavior(parts[1])
+	if err != nil {
+		return err
+	}
+
+	*m = append(*m, classicpaxos.MaliciousAcceptor{Acceptor: id,
+		Behavior: behavior})
+	return nil
+}
+
+// acceptorList is a flag.Value for a comma-separated list of acceptors, e.g.,
+// 0,1,2. An empty list is represented by nil.
+type acceptorList []int
+
+func (a *acceptorList) String() string {
+	return fmt.Sprint(*a)
+}
+
+func (a *acceptorList) Set(s string) error {
+	acceptors, err := parseInts(s, ",")
+	if err != nil {
+		return err
+	}
+	*a = acceptors
+	return nil
+}
+
+// reconfigurations is a flag.Value for a list of reconfigurations, each of the
+// form ACCEPTORS@TIME, where ACCEPTORS is a comma-separated list of acceptors,
+// e.g., 2,3,4@50ms.
+type reconfigurations []classicpaxos.Reconfiguration
+
+func (r *reconfigurations) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *reconfigurations) Set(s string) error {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("reconfiguration %q is not of the form ACCEPTORS@TIME",
+			s)
+	}
+
+	var reconfiguration classicpaxos.Reconfiguration
+	var err error
+	if reconfiguration.Acceptors, err = parseInts(parts[0], ","); err != nil {
+		return err
+	}
+	if reconfiguration.At, err = time.ParseDuration(parts[1]); err != nil {
+		return err
+	}
+
+	*r = append(*r, reconfiguration)
+	return nil
+}
+
+// partitions is a flag.Value for a list of network partitions, each of the
+// form GROUP|GROUP|...@START or GROUP|GROUP|...@START-HEAL, where each GROUP is
+// a comma-separated list of nodes, e.g., p0,a0|a1,a2@0s-1s.
+type partitions []classicpaxos.Partition
+
+func (p *partitions) String() string {
+	return fmt.Sprint(*p)
+}
+
+func (p *partitions) Set(s string) error {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("partition %q is not of the form GROUPS@TIMES", s)
+	}
+
+	var partition classicpaxos.Partition
+
+	for _, g := range strings.Split(parts[0], "|") {
+		var group []classicpaxos.Node
+		for _, n := range strings.Split(g, ",") {
+			node, err := classicpaxos.ParseNode(n)
+			if err != nil {
+				return err
+			}
+			group = append(group, node)
+		}
+		partition.Groups = append(partition.Groups, group)
+	}
+
+	var err error
+	if partition.Start, partition.Heal, err = parseInterval(parts[1]); err != nil {
+		return err
+	}
+
+	*p = append(*p, partition)
+	return nil
+}
+
+// linkCuts is a flag.Value for a list of link cuts, each of the form
+// FROM>TO@START or FROM>TO@START-HEAL, e.g., a1>p0@0s-1s.
+type linkCuts []classicpaxos.LinkCut
+
+func (l *linkCuts) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *linkCuts) Set(s string) error {
+	parts := strings.SplitN(s, "@", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("link cut %q is not of the form FROM>TO@TIMES", s)
+	}
+
+	nodes := strings.SplitN(parts[0], ">", 2)
+	if len(nodes) != 2 {
+		return fmt.Errorf("link cut %q is not of the form FROM>TO@TIMES", s)
+	}
+
+	var cut classicpaxos.LinkCut
+	var err error
+	if cut.From, err = classicpaxos.ParseNode(nodes[0]); err != nil {
+		return err
+	}
+	if cut.To, err = classicpaxos.ParseNode(nodes[1]); err != nil {
+		return err
+	}
+	if cut.Start, cut.Heal, err = parseInterval(parts[1]); err != nil {
+		return err
+	}
+
+	*l = append(*l, cut)
+	return nil
+}
+
+// parseInterval returns the start and end of an interval of the form START or
+// START-END. If there is no END, the end is zero.
+func parseInterval(s string) (time.Duration, time.Duration, error) {
+	times := strings.SplitN(s, "-", 2)
+
+	start, err := time.ParseDuration(times[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var end time.Duration
+	if len(times) == 2 {
+		if end, err = time.ParseDuration(times[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return start, end, nil
+}
+
+// quorumSystem is a flag.Value for a quorum system of the form majority,
+// flexible:PHASE1,PHASE2, weighted:WEIGHT,WEIGHT,... or grid:ROWSxCOLUMNS,
+// e.g., flexible:4,2. A majority quorum system is represented by nil.
+type quorumSystem struct {
+	classicpaxos.QuorumSystem
+}
+
+func (q *quorumSystem) String() string {
+	if q.QuorumSystem == nil {
+		return "majority"
+	}
+	return fmt.Sprint(q.QuorumSystem)
+}
+
+func (q *quorumSystem) Set(s string) error {
+	if s == "majority" {
+		q.QuorumSystem = nil
+		return nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("quorum system %q is not of the form KIND:PARAMETERS", s)
+	}
+
+	var sizes []int
+	var err error
+
+	switch parts[0] {
+	case "flexible":
+		if sizes, err = parseInts(parts[1], ","); err != nil {
+			return err
+		}
+		if len(sizes) != 2 {
+			return fmt.Errorf("flexible quorum sizes %q are not of the form "+
+				"PHASE1,PHASE2", parts[1])
+		}
+		q.QuorumSystem = classicpaxos.Flexible{Phase1: sizes[0], Phase2: sizes[1]}
+	case "weighted":
+		if sizes, err = parseInts(parts[1], ","); err != nil {
+			return err
+		}
+		total := 0
+		for _, w := range sizes {
+			if w < 0 {
+				return fmt.Errorf("weight %d in %q is negative", w, parts[1])
+			}
+			total += w
+		}
+		if total <= 0 {
+			return fmt.Errorf("weights %q total %d, want more than 0", parts[1],
+				total)
+		}
+		q.QuorumSystem = classicpaxos.Weighted{Weights: sizes}
+	case "grid":
+		if sizes, err = parseInts(parts[1], "x"); err != nil {
+			return err
+		}
+		if len(sizes) != 2 {
+			return fmt.Errorf("grid dimensions %q are not of the form "+
+				"ROWSxCOLUMNS", parts[1])
+		}
+		q.QuorumSystem = classicpaxos.Grid{Rows: sizes[0], Columns: sizes[1]}
+	default:
+		return fmt.Errorf("unknown kind of quorum system %q", parts[0])
+	}
+
+	return nil
+}
+
+// retryStrategy is a flag.Value for a retry strategy of the form
+// fixed:DELAY, exponential:BASE,MAX or leader, e.g., exponential:10ms,1s. A
+// fixed strategy without delay is represented by nil.
+type retryStrategy struct {
+	classicpaxos.RetryStrategy
+}
+
+func (r *retryStrategy) String() string {
+	if r.RetryStrategy == nil {
+		return "fixed:0s"
+	}
+	return fmt.Sprint(r.RetryStrategy)
+}
+
+func (r *retryStrategy) Set(s string) error {
+	if s == "leader" {
+		r.RetryStrategy = classicpaxos.DistinguishedProposer{}
+		return nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("retry strategy %q is not of the form KIND:PARAMETERS",
+			s)
+	}
+
+	switch parts[0] {
+	case "fixed":
+		delay, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return err
+		}
+		r.RetryStrategy = classicpaxos.FixedRetry{Delay: delay}
+	case "exponential":
+		limits := strings.Split(parts[1], ",")
+		if len(limits) != 2 {
+			return fmt.Errorf("exponential backoff %q is not of the form "+
+				"BASE,MAX", parts[1])
+		}
+		var e classicpaxos.ExponentialRetry
+		var err error
+		if e.Base, err = time.ParseDuration(limits[0]); err != nil {
+			return err
+		}
+		if e.Max, err = time.ParseDuration(limits[1]); err != nil {
+			return err
+		}
+		r.RetryStrategy = e
+	default:
+		return fmt.Errorf("unknown kind of retry strategy %q", parts[0])
+	}
+
+	return nil
+}
+
+// parseInts returns the integers in s, which are separated by sep.
+func parseInts(s, sep string) ([]int, error) {
+	var ints []int
+	for _, field := range strings.Split(s, sep) {
+		i, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
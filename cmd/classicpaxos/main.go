@@ -15,40 +15,198 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/b9r5/learn-paxos/internal/classicpaxos"
 	"os"
+	"strings"
 	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
 )
 
 // main starts a number of proposers and acceptors, waits until all proposers
 // have finished the proposer algorithm, and checks that the proposers agreed
-// on the same value.
+// on the same value. Alternatively, main runs Multi-Paxos to decide a log of
+// commands, or, given the subcommand acceptor or propose, runs one participant
+// that talks to the others over TCP, or, given the subcommand check, model
+// checks Classic Paxos, or, given the subcommand epaxos, runs EPaxos.
 func main() {
+	subcommands := map[string]func([]string) error{
+		"acceptor": runAcceptor,
+		"propose":  runPropose,
+		"check":    runCheck,
+		"epaxos":   runEPaxos,
+		"compare":  runCompare,
+	}
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		if err := subcommands[os.Args[1]](os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var nProposers = flag.Int("proposers", 10, "number of proposers")
 	var nAcceptors = flag.Int("acceptors", 5, "number of acceptors")
+	var nAuxiliary = flag.Int("auxiliary-acceptors", 0,
+		"number of the acceptors that are auxiliary acceptors of Cheap Paxos,\n"+
+			"which proposers engage only once a main acceptor fails to answer")
+	var nLearners = flag.Int("learners", 0,
+		"number of learners, which learn the chosen value from the acceptors")
+	var initialAcceptors acceptorList
+	flag.Var(&initialAcceptors, "initial-acceptors",
+		"comma-separated acceptors of the first configuration, if the run\n"+
+			"reconfigures (every acceptor if empty)")
+	var reconfigure reconfigurations
+	flag.Var(&reconfigure, "reconfigure",
+		"install a configuration of the comma-separated acceptors ACCEPTORS at\n"+
+			"time TIME, given as ACCEPTORS@TIME, e.g., 2,3,4@50ms (may be repeated)")
+	var quorums quorumSystem
+	flag.Var(&quorums, "quorums",
+		"quorum system: majority, flexible:PHASE1,PHASE2 (quorum sizes),\n"+
+			"weighted:WEIGHT,WEIGHT,... (acceptor weights) or grid:ROWSxCOLUMNS")
 	var proposerTimeout = flag.Duration("proposer-timeout",
 		100*time.Millisecond,
 		"time for proposer to wait for promise and accept messages")
+	var retry retryStrategy
+	flag.Var(&retry, "retry",
+		"how proposers retry after a failed round: fixed:DELAY, exponential:\n"+
+			"BASE,MAX (random delay up to BASE, doubling to MAX with each\n"+
+			"failure), or leader (only a distinguished proposer runs rounds)")
+	var pairEpochs = flag.Bool("pair-epochs", false,
+		"whether proposers use (round, proposer ID) epochs, which do not depend\n"+
+			"on the number of proposers")
+	var noRejects = flag.Bool("no-rejects", false,
+		"whether acceptors ignore, rather than reject, messages for epochs less\n"+
+			"than their promised epochs")
+	var fast = flag.Bool("fast", false,
+		"whether to run Fast Paxos, in which proposer 0 coordinates and the\n"+
+			"other proposers send their values directly to the acceptors")
+	var fastQuorum = flag.Int("fast-quorum", 0,
+		"size of a fast quorum in Fast Paxos (the least safe size if 0)")
+	var byzantine = flag.Bool("byzantine", false,
+		"whether to run Byzantine Paxos, in which participants sign their\n"+
+			"messages and quorums tolerate malicious acceptors")
+	var malicious maliciousAcceptors
+	flag.Var(&malicious, "malicious-acceptor",
+		"make acceptor ID misbehave as BEHAVIOR says: equivocate, lie or forge,\n"+
+			"given as ID:BEHAVIOR (may be repeated)")
 	var channelTimeout = flag.Duration("channel-timeout", 10*time.Millisecond,
 		"time to wait for lossy channel buffer to fill before returning a message")
 	var buffer = flag.Int("buffer-size", 2,
 		"number of messages to buffer before returning one selected randomly")
 	var drop = flag.Float64("drop-probability", 0.1,
 		"probability of lossy channel dropping a message, in range [0, 1)")
+	var seed = flag.Int64("seed", 0,
+		"if non-zero, seed for a repeatable run simulated with a virtual clock")
+	var walDir = flag.String("wal-dir", "",
+		"directory for acceptor logs, from which acceptors recover in later runs")
+	var crashAcceptors acceptorFaults
+	flag.Var(&crashAcceptors, "crash-acceptor",
+		"crash acceptor ID at time CRASH, and optionally restart it at time\n"+
+			"RESTART, given as ID@CRASH or ID@CRASH-RESTART (may be repeated)")
+	var crashProposers proposerFaults
+	flag.Var(&crashProposers, "crash-proposer",
+		"crash proposer ID in phase 2 after time CRASH, given as ID@CRASH\n"+
+			"(may be repeated)")
+	var loseState = flag.Bool("lose-state", false,
+		"whether restarted acceptors lose their state")
+	var partitions partitions
+	flag.Var(&partitions, "partition",
+		"partition the network into groups of nodes (pID, aID or lID) from time\n"+
+			"START until time HEAL, given as GROUP|GROUP@START or\n"+
+			"GROUP|GROUP@START-HEAL, e.g., p0,a0|a1,a2@0s-1s (may be repeated)")
+	var cutLinks linkCuts
+	flag.Var(&cutLinks, "cut-link",
+		"lose messages from node FROM to node TO from time START until time\n"+
+			"HEAL, given as FROM>TO@START or FROM>TO@START-HEAL (may be repeated)")
+
+	var trace = flag.String("trace", "",
+		"if not empty, file to which to write an event per protocol step, as\n"+
+			"JSON Lines")
+
+	var logLength = flag.Int("log", 0,
+		"if positive, run Multi-Paxos to decide a log of this many commands")
+
+	flag.Parse()
+
+	for i := range crashAcceptors {
+		crashAcceptors[i].LoseState = *loseState
+	}
 
 	c := classicpaxos.Config{
-		NProposers:      *nProposers,
-		NAcceptors:      *nAcceptors,
-		ProposerTimeout: *proposerTimeout,
-		ChannelTimeout:  *channelTimeout,
-		Buffer:          *buffer,
-		Drop:            *drop,
+		NProposers:         *nProposers,
+		NAcceptors:         *nAcceptors,
+		NAuxiliary:         *nAuxiliary,
+		NLearners:          *nLearners,
+		InitialAcceptors:   initialAcceptors,
+		Reconfigurations:   reconfigure,
+		Quorums:            quorums.QuorumSystem,
+		ProposerTimeout:    *proposerTimeout,
+		Retry:              retry.RetryStrategy,
+		NoRejects:          *noRejects,
+		Fast:               *fast,
+		FastQuorum:         *fastQuorum,
+		Byzantine:          *byzantine,
+		MaliciousAcceptors: malicious,
+		ChannelTimeout:     *channelTimeout,
+		Buffer:             *buffer,
+		Drop:               *drop,
+		Seed:               *seed,
+		WALDir:             *walDir,
+		AcceptorFaults:     crashAcceptors,
+		ProposerFaults:     crashProposers,
+		Partitions:         partitions,
+		LinkCuts:           cutLinks,
+	}
+	if *pairEpochs {
+		c.Epochs = classicpaxos.PairEpochs
+	}
+
+	var traceFile *os.File
+	var traceWriter *bufio.Writer
+	if *trace != "" {
+		var err error
+		if traceFile, err = os.Create(*trace); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		traceWriter = bufio.NewWriter(traceFile)
+		c.Trace = classicpaxos.NewJSONSink(traceWriter)
+	}
+
+	var err error
+	if *logLength > 0 {
+		err = c.RunLog(func(l *classicpaxos.Log) {
+			for i := 0; i < *logLength; i++ {
+				l.Append(fmt.Sprintf("c%d", i))
+			}
+			for slot := 0; slot < *logLength; slot++ {
+				fmt.Printf("slot %d: %s\n", slot, l.Read(slot))
+			}
+		})
+	} else {
+		var cluster *classicpaxos.Cluster
+		if cluster, err = c.Start(context.Background()); err == nil {
+			err = cluster.Wait()
+			fmt.Println(cluster.Metrics())
+		}
+	}
+
+	if traceFile != nil {
+		if flushErr := traceWriter.Flush(); err == nil && flushErr != nil {
+			err = fmt.Errorf("trace: %v\n", flushErr)
+		}
+		if closeErr := traceFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("trace: %v\n", closeErr)
+		}
 	}
 
-	if err := c.Run(); err != nil {
-		fmt.Print(err)
+	if err != nil {
+		// the errors of a run that disagrees end in a newline, others do not
+		fmt.Println(strings.TrimSuffix(err.Error(), "\n"))
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,162 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+// runAcceptor runs one acceptor that listens for messages over TCP, until the
+// process is interrupted.
+func runAcceptor(args []string) error {
+	flags := flag.NewFlagSet("acceptor", flag.ExitOnError)
+	var listen = flags.String("listen", "127.0.0.1:7000",
+		"address at which the acceptor listens")
+	var id = flags.Int("id", 0, "acceptor ID, unique among the acceptors")
+	var walDir = flags.String("wal-dir", "",
+		"directory for the acceptor's log, from which it recovers when restarted")
+	flags.Parse(args)
+
+	var storage classicpaxos.Storage = classicpaxos.NewMemoryStorage()
+	if *walDir != "" {
+		path := filepath.Join(*walDir, fmt.Sprintf("acceptor-%d.log", *id))
+		var err error
+		if storage, err = classicpaxos.OpenFileStorage(path); err != nil {
+			return err
+		}
+	}
+	defer storage.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	transport, err := classicpaxos.ListenTCP(ctx, *listen)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("acceptor %d listening at %s\n", *id, transport.Addr())
+
+	a := classicpaxos.NewAcceptor(ctx, *id, transport, 0, storage, os.Stdout)
+	transport.Connect(classicpaxos.AcceptorNode(*id), a.Deliver)
+
+	a.Wait()
+	transport.Wait()
+	if err := transport.Err(); err != nil {
+		fmt.Printf("transport: %v\n", err)
+	}
+	return nil
+}
+
+// runPropose runs one proposer that sends its messages over TCP to acceptors
+// in other processes, and prints the value that it learns was agreed.
+func runPropose(args []string) error {
+	flags := flag.NewFlagSet("propose", flag.ExitOnError)
+	var acceptors = flags.String("acceptors", "",
+		"comma-separated addresses of the acceptors, in order of acceptor ID")
+	var id = flags.Int("id", 0, "proposer ID, unique among the proposers")
+	var nProposers = flags.Int("proposers", 1,
+		"number of proposers (if 0, proposers use (round, proposer ID) epochs,\n"+
+			"so that any number may join)")
+	var value = flags.String("value", "",
+		"value to propose, which may be empty (v followed by the proposer ID\n"+
+			"if not given)")
+	var listen = flags.String("listen", "127.0.0.1:0",
+		"address at which the proposer listens for replies")
+	var quorums quorumSystem
+	flags.Var(&quorums, "quorums",
+		"quorum system: majority, flexible:PHASE1,PHASE2 (quorum sizes),\n"+
+			"weighted:WEIGHT,WEIGHT,... (acceptor weights) or grid:ROWSxCOLUMNS")
+	var proposerTimeout = flags.Duration("proposer-timeout",
+		100*time.Millisecond,
+		"time for proposer to wait for promise and accept messages")
+	var timeout = flags.Duration("timeout", 0,
+		"if positive, time after which the proposer gives up")
+	flags.Parse(args)
+
+	if *acceptors == "" {
+		return fmt.Errorf("no acceptor addresses given with -acceptors")
+	}
+	addrs := strings.Split(*acceptors, ",")
+	if *id < 0 || *nProposers > 0 && *id >= *nProposers {
+		return fmt.Errorf("proposer ID %d is not in [0, %d)", *id, *nProposers)
+	}
+	valueGiven := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "value" {
+			valueGiven = true
+		}
+	})
+	if !valueGiven {
+		*value = fmt.Sprintf("v%d", *id)
+	}
+
+	q := quorums.QuorumSystem
+	if q == nil {
+		q = classicpaxos.Majority{NAcceptors: len(addrs)}
+	}
+	if err := classicpaxos.CheckQuorums(q, len(addrs)); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// the transport lives only as long as the proposer is proposing
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	transport, err := classicpaxos.ListenTCP(ctx, *listen)
+	if err != nil {
+		return err
+	}
+	for i, addr := range addrs {
+		transport.AddPeer(classicpaxos.AcceptorNode(i), addr)
+	}
+
+	p := classicpaxos.NewProposer(*id, *nProposers, len(addrs), q,
+		*proposerTimeout, transport, os.Stdout)
+	transport.Connect(classicpaxos.ProposerNode(*id), p.Deliver)
+
+	agreed, err := p.Propose(ctx, classicpaxos.NewValue([]byte(*value)))
+
+	cancel()
+	transport.Wait()
+	if err := transport.Err(); err != nil {
+		fmt.Printf("transport: %v\n", err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("proposer %d: %v", *id, err)
+	}
+	fmt.Printf("agreed value: %s\n", agreed)
+	return nil
+}
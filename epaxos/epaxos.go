@@ -0,0 +1,349 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package epaxos implements EPaxos, which runs over the same simulated network
+// as Classic Paxos, so that leader-based and leaderless consensus can be
+// compared under the same lossy channels and faults:
+//
+//	c := epaxos.Config{NReplicas: 5, NCommands: 10, NKeys: 3,
+//	    Timeout: 50 * time.Millisecond, Buffer: 2, Drop: 0.1, Seed: 1}
+//	metrics, err := c.Run()
+package epaxos
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+type (
+	// Partition schedules a partition of the network into groups of nodes.
+	Partition = classicpaxos.Partition
+
+	// LinkCut schedules the loss of every message sent from one node to
+	// another.
+	LinkCut = classicpaxos.LinkCut
+
+	// Node identifies a replica.
+	Node = classicpaxos.Node
+)
+
+// ReplicaNode returns the node for the replica numbered id.
+func ReplicaNode(id int) Node {
+	return classicpaxos.ReplicaNode(id)
+}
+
+// EPaxos [1] is leaderless: each replica leads the commands that its clients
+// send it, and commits each one in an instance of its own, so no replica's
+// failure or slowness holds up the commands of the others. Two commands
+// interfere if they write the same key. Rather than agree on a slot for each
+// command, the replicas agree on its attributes: a sequence number, and the
+// dependencies, which are the interfering instances that some replica knew of.
+//
+// The command leader pre-accepts the command with the attributes that its own
+// log implies, and sends them to the other replicas, each of which adds the
+// interfering instances in its log. If a fast quorum of replicas replies
+// without changing the attributes, the leader commits the command after one
+// round trip: the fast path. Otherwise it takes the union of the replies of a
+// majority, and has a majority accept it before committing: the slow path.
+// Since any two of these quorums intersect, of two interfering commands, at
+// least one depends on the other.
+//
+// Each replica executes the committed commands in the order of the dependency
+// graph: the strongly connected components of the graph in reverse topological
+// order, and within a component, in order of sequence number. Every replica
+// thus executes every two interfering commands in the same order.
+//
+// A command leader retransmits until it hears from every replica, but there is
+// no explicit prepare by which another replica could finish its instances, so
+// every replica that crashes must restart.
+//
+// [1] Iulian Moraru, David G. Andersen, and Michael Kaminsky. 2013. There is
+// more consensus in egalitarian parliaments. In Proceedings of the 24th ACM
+// Symposium on Operating Systems Principles (SOSP '13), 358-372.
+
+// Config represents configuration for a run of EPaxos, including the number of
+// replicas, the commands that each proposes, the lossyChannel parameters, the
+// seed for pseudo-random choices, which replicas crash, and how the network
+// fails.
+type Config struct {
+	// number of replicas
+	NReplicas int
+
+	// number of commands that each replica leads
+	NCommands int
+
+	// number of keys that the commands write; commands that write the same key
+	// interfere, so the fewer the keys, the more commands take the slow path
+	NKeys int
+
+	// how long a replica waits for replies before retransmitting
+	Timeout time.Duration
+
+	// how long lossyChannel waits for buffer to fill before returning message
+	ChannelTimeout time.Duration
+
+	// how many messages lossyChannel buffers
+	Buffer int
+
+	// probability that lossyChannel drops a message
+	Drop float64
+
+	// seed for pseudo-random choices; if non-zero, the run is simulated against
+	// a virtual clock, and runs with the same configuration and seed are
+	// identical; if zero, the run happens in real time and is not repeatable
+	Seed int64
+
+	// crashes and restarts of replicas
+	Faults []ReplicaFault
+
+	// partitions of the network
+	Partitions []Partition
+
+	// links on which the network loses messages in one direction only
+	LinkCuts []LinkCut
+
+	// where replicas print their progress (os.Stdout if nil); unless Seed is
+	// non-zero, it must be safe for concurrent use
+	Output io.Writer
+
+	// if not nil, receives an event for each step of the run
+	Trace classicpaxos.EventSink
+}
+
+// run is a run of EPaxos: a copy of the configuration that started it, and the
+// state that exists only while it lasts, so that a Config may run any number
+// of times, even at once.
+type run struct {
+	Config
+
+	env     *classicpaxos.Env // scheduler, network and tracer for the run
+	metrics *recorder         // measures the run's commits
+}
+
+// ReplicaFault schedules a crash of an EPaxos replica and its restart. A
+// crashed replica loses every message sent to it until it restarts, and then
+// resumes with the state it had when it crashed.
+type ReplicaFault struct {
+	// replica that crashes
+	Replica int
+
+	// time since the start of the run at which the replica crashes
+	CrashAt time.Duration
+
+	// time since the start of the run at which the replica restarts
+	RestartAt time.Duration
+}
+
+// String returns the string form of a replica fault.
+func (f ReplicaFault) String() string {
+	return fmt.Sprintf("replica %d crashes at %s and restarts at %s", f.Replica,
+		f.CrashAt, f.RestartAt)
+}
+
+// Metrics measures how the commands of a run of EPaxos were committed.
+type Metrics struct {
+	// number of commands committed on the fast path and on the slow path
+	FastPaths, SlowPaths int
+
+	// number of messages that the replicas sent over the network, in total,
+	// whether or not they arrived
+	Messages int
+
+	// mean and greatest time from a command leader receiving a command until
+	// it committed the command, over the commands committed; in a simulated
+	// run, the time is virtual
+	MeanLatency, MaxLatency time.Duration
+}
+
+// String returns the string form of EPaxos metrics.
+func (m Metrics) String() string {
+	return fmt.Sprintf("%d command/s committed on the fast path and %d on the "+
+		"slow path, after %d message/s, with mean latency %s and max latency %s",
+		m.FastPaths, m.SlowPaths, m.Messages, m.MeanLatency, m.MaxLatency)
+}
+
+// recorder accumulates the metrics of a run of EPaxos from replicas that
+// may commit concurrently.
+type recorder struct {
+	mu      sync.Mutex
+	metrics Metrics
+	total   time.Duration // sum of the latencies of the commands committed
+}
+
+// committed records that a command was committed, on the fast path if fast is
+// true, latency after its leader received it.
+func (r *recorder) committed(fast bool, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if fast {
+		r.metrics.FastPaths++
+	} else {
+		r.metrics.SlowPaths++
+	}
+	n := r.metrics.FastPaths + r.metrics.SlowPaths
+	r.total += latency
+	r.metrics.MeanLatency = r.total / time.Duration(n)
+	if latency > r.metrics.MaxLatency {
+		r.metrics.MaxLatency = latency
+	}
+}
+
+// get returns the metrics recorded so far.
+func (r *recorder) get() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metrics
+}
+
+// Run runs EPaxos for the scenario given by the configuration c: each replica
+// leads c.NCommands commands, each of which writes one of c.NKeys keys chosen
+// pseudo-randomly. Run returns once every replica has executed every command,
+// with the metrics of the run. It returns a non-nil error if two replicas
+// executed the commands that write some key in different orders.
+func (c *Config) Run() (Metrics, error) {
+	r, err := c.newRun()
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	runErr := r.env.Run(func() {
+		// 1. create replicas
+		n := r.NReplicas * r.NReplicas * r.NCommands
+		executions := r.env.NewChannel(n)
+		inputs := make([]classicpaxos.Channel, r.NReplicas)
+		for i := 0; i < r.NReplicas; i++ {
+			inputs[i] = r.env.Listen(ReplicaNode(i))
+			newReplica(r.env, i, r.NReplicas, inputs[i], r.Timeout, executions,
+				r.metrics, r.output())
+		}
+
+		// 2. give each replica its commands, and then schedule the crashes
+		for i := 0; i < r.NReplicas; i++ {
+			for j := 0; j < r.NCommands; j++ {
+				inputs[i].Send(command{value: fmt.Sprintf("k%d=v%d.%d",
+					r.env.Rand().Intn(r.NKeys), i, j)})
+			}
+		}
+		for _, f := range r.Faults {
+			r.env.ScheduleCrash(inputs[f.Replica], f.CrashAt, f.RestartAt)
+		}
+
+		// 3. check whether the replicas executed the commands in the same order
+		err = r.checkOrders(executions)
+	})
+	if runErr != nil {
+		err = runErr
+	}
+	if err == nil {
+		err = r.env.TraceErr()
+	}
+
+	metrics := r.metrics.get()
+	metrics.Messages = r.env.Messages()
+	return metrics, err
+}
+
+// newRun checks c, and returns a run of c with its own environment.
+func (c *Config) newRun() (*run, error) {
+	if c.NReplicas < 1 || c.NCommands < 0 || c.NKeys < 1 {
+		return nil, fmt.Errorf("EPaxos needs at least one replica and one key")
+	}
+	if c.Timeout <= 0 {
+		return nil, fmt.Errorf("EPaxos replicas need a positive timeout")
+	}
+	for _, f := range c.Faults {
+		if f.Replica < 0 || f.Replica >= c.NReplicas {
+			return nil, fmt.Errorf("fault for nonexistent replica %d", f.Replica)
+		}
+		if f.RestartAt <= f.CrashAt {
+			return nil, fmt.Errorf("%s, but EPaxos replicas must restart after "+
+				"they crash", f)
+		}
+	}
+
+	env, err := classicpaxos.NewEnv(classicpaxos.EnvConfig{Seed: c.Seed,
+		ChannelTimeout: c.ChannelTimeout, Buffer: c.Buffer, Drop: c.Drop,
+		Partitions: c.Partitions, LinkCuts: c.LinkCuts, Exists: c.exists,
+		Output: c.output(), Trace: c.Trace})
+	if err != nil {
+		return nil, err
+	}
+	return &run{Config: *c, env: env, metrics: &recorder{}}, nil
+}
+
+// exists returns true if and only if the node n is a replica in a run.
+func (c *Config) exists(n Node) bool {
+	return n.Role == classicpaxos.RoleReplica && n.ID >= 0 && n.ID < c.NReplicas
+}
+
+// output returns the writer to which replicas print their progress.
+func (c *Config) output() io.Writer {
+	if c.Output == nil {
+		return os.Stdout
+	}
+	return c.Output
+}
+
+// checkOrders waits until every replica has placed every command on the
+// executions channel, and returns a non-nil error if two replicas executed the
+// commands that write some key in different orders, or if the executions
+// channel is closed first.
+func (c *Config) checkOrders(executions classicpaxos.Channel) error {
+	total := c.NReplicas * c.NCommands
+	orders := make([]map[string][]string, c.NReplicas) // keys are keys
+	for i := range orders {
+		orders[i] = make(map[string][]string)
+	}
+
+	for i := 0; i < c.NReplicas*total; i++ {
+		m := executions.Receive()
+		if m == nil {
+			return fmt.Errorf("the run stopped after %d of %d commands were "+
+				"executed", i, c.NReplicas*total)
+		}
+		e := m.(execution)
+		key := commandKey(e.value)
+		orders[e.replicaID][key] = append(orders[e.replicaID][key], e.value)
+	}
+
+	keys := make([]string, 0, len(orders[0]))
+	for key := range orders[0] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i := 1; i < c.NReplicas; i++ {
+		for _, key := range keys {
+			if fmt.Sprint(orders[i][key]) != fmt.Sprint(orders[0][key]) {
+				fmt.Fprintf(c.output(), "uh oh! replicas 0 and %d executed the "+
+					"commands on key %s in different orders (%v versus %v)\n", i,
+					key, orders[0][key], orders[i][key])
+				return fmt.Errorf("replicas 0 and %d executed the commands on "+
+					"key %s in different orders", i, key)
+			}
+		}
+	}
+
+	fmt.Fprintf(c.output(), "yay! %d replicas executed %d commands each, and "+
+		"executed the commands on each key in the same order\n", c.NReplicas,
+		total)
+	return nil
+}
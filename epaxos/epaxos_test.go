@@ -0,0 +1,135 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epaxos_test
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/b9r5/learn-paxos/epaxos"
+)
+
+func TestThatReplicasAgreeAcrossAPartition(t *testing.T) {
+	c := epaxos.Config{NReplicas: 5, NCommands: 3, NKeys: 2,
+		Timeout: 50 * time.Millisecond, Buffer: 2, Drop: 0.1,
+		Partitions: []epaxos.Partition{{
+			Groups: [][]epaxos.Node{{epaxos.ReplicaNode(0),
+				epaxos.ReplicaNode(1)}},
+			Heal: 300 * time.Millisecond}},
+		Seed: 1, Output: io.Discard}
+
+	metrics, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := metrics.FastPaths + metrics.SlowPaths; n != 15 {
+		t.Errorf("got %d commands committed, want 15", n)
+	}
+}
+
+func TestThatEPaxosReplicasAgreeDespiteLossAndCrashes(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		c := epaxos.Config{NReplicas: 5, NCommands: 4, NKeys: 2,
+			Timeout:        50 * time.Millisecond,
+			ChannelTimeout: 10 * time.Millisecond, Buffer: 3, Drop: 0.2,
+			Faults: []epaxos.ReplicaFault{{Replica: 1,
+				CrashAt: 20 * time.Millisecond, RestartAt: 200 * time.Millisecond}},
+			Seed: seed, Output: io.Discard}
+		if _, err := c.Run(); err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+	}
+}
+
+func TestThatAConfigMayRunTwiceAtOnce(t *testing.T) {
+	ms := time.Millisecond
+	c := epaxos.Config{NReplicas: 3, NCommands: 3, NKeys: 2, Timeout: 50 * ms,
+		ChannelTimeout: 10 * ms, Buffer: 2, Drop: 0.1, Seed: 7,
+		Output: io.Discard}
+
+	want, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// runs of the same seed take the same steps, even if they overlap
+	var wg sync.WaitGroup
+	got := make([]epaxos.Metrics, 2)
+	errs := make([]error, 2)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = c.Run()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range got {
+		if errs[i] != nil || got[i].String() != want.String() {
+			t.Errorf("run %d got %s (%v), want %s", i, got[i], errs[i], want)
+		}
+	}
+}
+
+func TestThatOnlyInterferingCommandsTakeTheSlowPath(t *testing.T) {
+	for _, test := range []struct {
+		nKeys      int
+		fast, slow bool // whether some command takes each path
+	}{
+		{1, false, true},
+		{1000000, true, false},
+	} {
+		c := epaxos.Config{NReplicas: 3, NCommands: 3, NKeys: test.nKeys,
+			Timeout: 50 * time.Millisecond, Buffer: 2, Seed: 1,
+			Output: io.Discard}
+		metrics, err := c.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics.FastPaths+metrics.SlowPaths != 9 ||
+			(metrics.FastPaths > 0) != test.fast ||
+			(metrics.SlowPaths > 0) != test.slow {
+
+			t.Errorf("%d key/s: got %s", test.nKeys, metrics)
+		}
+	}
+}
+
+func TestThatEPaxosRefusesFaultsItCannotRecoverFrom(t *testing.T) {
+	for _, test := range []struct {
+		c   epaxos.Config
+		err string
+	}{
+		{epaxos.Config{Faults: []epaxos.ReplicaFault{{Replica: 0}}},
+			"must restart"},
+		{epaxos.Config{Faults: []epaxos.ReplicaFault{{Replica: 3,
+			RestartAt: time.Second}}}, "nonexistent replica"},
+		{epaxos.Config{Partitions: []epaxos.Partition{{
+			Groups: [][]epaxos.Node{{epaxos.ReplicaNode(3)}}}}},
+			"nonexistent node"},
+	} {
+		test.c.NReplicas, test.c.NKeys, test.c.Timeout = 3, 1, time.Second
+		test.c.Seed, test.c.Output = 1, io.Discard
+		if _, err := test.c.Run(); err == nil ||
+			!strings.Contains(err.Error(), test.err) {
+
+			t.Errorf("got %v, want an error containing %q", err, test.err)
+		}
+	}
+}
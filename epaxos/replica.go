@@ -0,0 +1,731 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epaxos
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+// instance identifies an instance of EPaxos: the slot of a command among those
+// that a replica leads.
+type instance struct {
+	replica, slot int
+}
+
+// String returns the string form of an instance.
+func (i instance) String() string {
+	return fmt.Sprintf("r%d.%d", i.replica, i.slot)
+}
+
+// before returns true if and only if i comes before j in the order of
+// replicas, and then of slots.
+func (i instance) before(j instance) bool {
+	if i.replica != j.replica {
+		return i.replica < j.replica
+	}
+	return i.slot < j.slot
+}
+
+// attributes are the sequence number and the dependencies of a command, which
+// determine the order in which the replicas execute it.
+type attributes struct {
+	seq  int
+	deps []instance // in increasing order
+}
+
+// String returns the string form of attributes.
+func (a attributes) String() string {
+	return fmt.Sprintf("%d, %v", a.seq, a.deps)
+}
+
+// equal returns true if and only if a and b are the same attributes.
+func (a attributes) equal(b attributes) bool {
+	if a.seq != b.seq || len(a.deps) != len(b.deps) {
+		return false
+	}
+	for i := range a.deps {
+		if a.deps[i] != b.deps[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// union returns the attributes with the greater sequence number of a and b,
+// and the dependencies of both.
+func (a attributes) union(b attributes) attributes {
+	u := attributes{seq: a.seq}
+	if b.seq > u.seq {
+		u.seq = b.seq
+	}
+
+	deps := make(map[instance]bool)
+	for _, d := range a.deps {
+		deps[d] = true
+	}
+	for _, d := range b.deps {
+		deps[d] = true
+	}
+	for d := range deps {
+		u.deps = append(u.deps, d)
+	}
+	sort.Slice(u.deps, func(i, j int) bool {
+		return u.deps[i].before(u.deps[j])
+	})
+	return u
+}
+
+// commandKey returns the key that the command value, of the form KEY=VALUE,
+// writes.
+func commandKey(value string) string {
+	return strings.SplitN(value, "=", 2)[0]
+}
+
+// command is the message by which the run gives a replica a command to lead.
+type command struct {
+	value string
+}
+
+// String returns the string form of a command message.
+func (c command) String() string {
+	return fmt.Sprintf("command(%s)", c.value)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (c command) Describe(e *classicpaxos.Event) {
+	e.Type, e.Value = "command", c.value
+}
+
+// Sender implements classicpaxos.DescribedMessage. A command comes from no
+// node.
+func (c command) Sender() (Node, bool) {
+	return Node{}, false
+}
+
+// preAccept is the message by which a command leader proposes a command and
+// its attributes for one of its instances.
+type preAccept struct {
+	inst  instance
+	value string
+	attrs attributes
+}
+
+// String returns the string form of a preAccept message.
+func (p preAccept) String() string {
+	return fmt.Sprintf("pre-accept(%s, %s, %s) from replica %d", p.inst,
+		p.value, p.attrs, p.inst.replica)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (p preAccept) Describe(e *classicpaxos.Event) {
+	e.Type, e.Value, e.Slot = "pre-accept", p.value, &p.inst.slot
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (p preAccept) Sender() (Node, bool) {
+	return ReplicaNode(p.inst.replica), true
+}
+
+// preAcceptOK is the reply to a preAccept message, with the attributes that
+// the replica pre-accepted.
+type preAcceptOK struct {
+	inst      instance
+	attrs     attributes
+	replicaID int
+}
+
+// String returns the string form of a preAcceptOK message.
+func (p preAcceptOK) String() string {
+	return fmt.Sprintf("pre-accept-ok(%s, %s) from replica %d", p.inst,
+		p.attrs, p.replicaID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (p preAcceptOK) Describe(e *classicpaxos.Event) {
+	e.Type, e.Slot = "pre-accept-ok", &p.inst.slot
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (p preAcceptOK) Sender() (Node, bool) {
+	return ReplicaNode(p.replicaID), true
+}
+
+// accept is the message by which a command leader on the slow path asks
+// the replicas to accept a command with the union of their attributes.
+type accept struct {
+	inst  instance
+	value string
+	attrs attributes
+}
+
+// String returns the string form of an accept message.
+func (a accept) String() string {
+	return fmt.Sprintf("accept(%s, %s, %s) from replica %d", a.inst, a.value,
+		a.attrs, a.inst.replica)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (a accept) Describe(e *classicpaxos.Event) {
+	e.Type, e.Value, e.Slot = "accept", a.value, &a.inst.slot
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (a accept) Sender() (Node, bool) {
+	return ReplicaNode(a.inst.replica), true
+}
+
+// acceptOK is the reply to an accept message.
+type acceptOK struct {
+	inst      instance
+	replicaID int
+}
+
+// String returns the string form of an acceptOK message.
+func (a acceptOK) String() string {
+	return fmt.Sprintf("accept-ok(%s) from replica %d", a.inst, a.replicaID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (a acceptOK) Describe(e *classicpaxos.Event) {
+	e.Type, e.Slot = "accept-ok", &a.inst.slot
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (a acceptOK) Sender() (Node, bool) {
+	return ReplicaNode(a.replicaID), true
+}
+
+// commit is the message by which a command leader tells the replicas that a
+// command is committed with the given attributes.
+type commit struct {
+	inst  instance
+	value string
+	attrs attributes
+}
+
+// String returns the string form of a commit message.
+func (c commit) String() string {
+	return fmt.Sprintf("commit(%s, %s, %s) from replica %d", c.inst, c.value,
+		c.attrs, c.inst.replica)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (c commit) Describe(e *classicpaxos.Event) {
+	e.Type, e.Value, e.Slot = "commit", c.value, &c.inst.slot
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (c commit) Sender() (Node, bool) {
+	return ReplicaNode(c.inst.replica), true
+}
+
+// commitOK is the reply to a commit message, which lets the command leader
+// stop retransmitting it.
+type commitOK struct {
+	inst      instance
+	replicaID int
+}
+
+// String returns the string form of a commitOK message.
+func (c commitOK) String() string {
+	return fmt.Sprintf("commit-ok(%s) from replica %d", c.inst, c.replicaID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (c commitOK) Describe(e *classicpaxos.Event) {
+	e.Type, e.Slot = "commit-ok", &c.inst.slot
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (c commitOK) Sender() (Node, bool) {
+	return ReplicaNode(c.replicaID), true
+}
+
+// execution is the message by which a replica tells the run that it executed
+// a command.
+type execution struct {
+	replicaID int
+	value     string
+}
+
+// Statuses of an instance at a replica, in the order in which they progress.
+const (
+	statusPreAccepted = iota // replica has pre-accepted the command
+	statusAccepted           // replica has accepted the command
+	statusCommitted          // command is committed
+	statusExecuted           // replica has executed the command
+)
+
+// logEntry is what a replica knows of an instance.
+type logEntry struct {
+	value  string
+	attrs  attributes
+	status int // one of the statuses
+}
+
+// leaderState is what a command leader knows of the progress of one of its
+// instances.
+type leaderState struct {
+	status   int           // statusPreAccepted, statusAccepted or statusCommitted
+	original attributes    // attributes that the leader pre-accepted
+	attrs    attributes    // union of the attributes in the replies
+	changed  bool          // whether any reply changed the attributes
+	replies  map[int]bool  // replicas that replied in the current status
+	start    time.Duration // time at which the leader received the command
+}
+
+// replica represents a replica in EPaxos, which leads the commands that it
+// receives, and takes part in the instances of the other replicas. It keeps
+// its state in memory.
+type replica struct {
+	id         int                  // replica identifier
+	nReplicas  int                  // number of replicas
+	input      classicpaxos.Channel // input channel
+	timeout    time.Duration        // time to wait for replies to retransmit
+	executions classicpaxos.Channel // replica places executed commands here
+	metrics    *recorder            // replica records its commits
+	out        io.Writer            // replica prints its progress to out
+
+	// environment whose network carries the replica's messages, whose clock
+	// measures the timeout, and whose tracer traces its progress
+	env *classicpaxos.Env
+
+	log      map[instance]*logEntry    // every instance the replica knows of
+	leading  map[instance]*leaderState // the replica's unfinished instances
+	nextSlot int                       // slot for the next command it leads
+}
+
+// newReplica creates a replica with the given parameters and starts its
+// goroutine in the environment env.
+func newReplica(env *classicpaxos.Env, id, nReplicas int,
+	input classicpaxos.Channel,
+	timeout time.Duration,
+	executions classicpaxos.Channel,
+	metrics *recorder,
+	out io.Writer) *replica {
+
+	r := &replica{
+		id:         id,
+		nReplicas:  nReplicas,
+		input:      input,
+		timeout:    timeout,
+		executions: executions,
+		metrics:    metrics,
+		out:        out,
+		env:        env,
+		log:        make(map[instance]*logEntry),
+		leading:    make(map[instance]*leaderState),
+	}
+	env.Spawn(r.run)
+	return r
+}
+
+// run runs the replica until its input channel is closed. Once every timeout,
+// it retransmits the messages of its unfinished instances that have not been
+// answered.
+func (r *replica) run() {
+	last := r.env.Now() // time of the last retransmission
+	for {
+		if r.env.Now()-last >= r.timeout {
+			r.retransmit()
+			last = r.env.Now()
+		}
+
+		m, ok := r.input.ReceiveTimeout(r.timeout - (r.env.Now() - last))
+		if !ok {
+			continue
+		}
+		if m == nil {
+			return
+		}
+
+		fmt.Fprintf(r.out, "replica %d received message %s\n", r.id, m)
+		r.env.TraceArrival(classicpaxos.EventReceived, ReplicaNode(r.id), m, "")
+
+		switch msg := m.(type) {
+		case command:
+			r.propose(msg.value)
+		case preAccept:
+			r.handlePreAccept(msg)
+		case preAcceptOK:
+			r.handlePreAcceptOK(msg)
+		case accept:
+			r.handleAccept(msg)
+		case acceptOK:
+			r.handleReply(msg.inst, statusAccepted, msg.replicaID)
+		case commit:
+			r.handleCommit(msg)
+		case commitOK:
+			r.handleReply(msg.inst, statusCommitted, msg.replicaID)
+		case classicpaxos.Crash:
+			if !r.awaitRestart() {
+				return
+			}
+			last = r.env.Now()
+		}
+
+		r.execute()
+	}
+}
+
+// awaitRestart loses every message sent to the crashed replica until it
+// receives a restart message, and returns true; or returns false if its input
+// channel is closed first.
+func (r *replica) awaitRestart() bool {
+	for {
+		m := r.input.Receive()
+		if m == nil {
+			return false
+		}
+
+		if msg, ok := m.(classicpaxos.Restart); ok {
+			fmt.Fprintf(r.out, "replica %d received message %s\n", r.id, msg)
+			r.env.TraceArrival(classicpaxos.EventReceived, ReplicaNode(r.id),
+				msg, "")
+			return true
+		}
+
+		fmt.Fprintf(r.out, "replica %d is down, lost message %s\n", r.id, m)
+		r.env.TraceArrival(classicpaxos.EventDropped, ReplicaNode(r.id), m,
+			"replica down")
+	}
+}
+
+// fastQuorum returns the number of replicas, including the command leader,
+// that must pre-accept a command with unchanged attributes for the fast path.
+func (r *replica) fastQuorum() int {
+	f := (r.nReplicas - 1) / 2
+	if q := f + (f+1)/2; q > f {
+		return q
+	}
+	return f + 1
+}
+
+// slowQuorum returns the number of replicas, including the command leader,
+// that must reply for the slow path: a majority.
+func (r *replica) slowQuorum() int {
+	return r.nReplicas/2 + 1
+}
+
+// interfering returns the attributes that the replica's log implies for the
+// command value in the instance inst: a sequence number greater than that of
+// every interfering instance, and, as dependencies, the greatest interfering
+// instance of each replica.
+func (r *replica) interfering(inst instance, value string) attributes {
+	a := attributes{seq: 1}
+	greatest := make(map[int]int) // keys are replicas, values are slots
+	for i, e := range r.log {
+		if i == inst || commandKey(e.value) != commandKey(value) {
+			continue
+		}
+		if e.attrs.seq >= a.seq {
+			a.seq = e.attrs.seq + 1
+		}
+		if slot, ok := greatest[i.replica]; !ok || i.slot > slot {
+			greatest[i.replica] = i.slot
+		}
+	}
+	for replica, slot := range greatest {
+		a.deps = append(a.deps, instance{replica: replica, slot: slot})
+	}
+	return a.union(attributes{})
+}
+
+// propose starts the replica leading the command value in its next instance.
+func (r *replica) propose(value string) {
+	inst := instance{replica: r.id, slot: r.nextSlot}
+	r.nextSlot++
+
+	attrs := r.interfering(inst, value)
+	r.log[inst] = &logEntry{value: value, attrs: attrs}
+	r.leading[inst] = &leaderState{original: attrs, attrs: attrs,
+		replies: make(map[int]bool), start: r.env.Now()}
+
+	r.toOthers(preAccept{inst: inst, value: value, attrs: attrs}, nil)
+	r.advance(inst)
+}
+
+// handlePreAccept pre-accepts the command of msg, with its attributes updated
+// by the replica's log, unless the replica already knows the instance, and
+// replies with the attributes that it holds for the instance.
+func (r *replica) handlePreAccept(msg preAccept) {
+	e, ok := r.log[msg.inst]
+	if !ok {
+		attrs := msg.attrs.union(r.interfering(msg.inst, msg.value))
+		e = &logEntry{value: msg.value, attrs: attrs}
+		r.log[msg.inst] = e
+	}
+	r.send(msg.inst.replica, preAcceptOK{inst: msg.inst, attrs: e.attrs,
+		replicaID: r.id})
+}
+
+// handlePreAcceptOK counts the reply msg for an instance that the replica
+// leads, and merges its attributes.
+func (r *replica) handlePreAcceptOK(msg preAcceptOK) {
+	l, ok := r.leading[msg.inst]
+	if !ok || l.status != statusPreAccepted {
+		return
+	}
+	if !msg.attrs.equal(l.original) {
+		l.changed = true
+	}
+	l.attrs = l.attrs.union(msg.attrs)
+	r.handleReply(msg.inst, statusPreAccepted, msg.replicaID)
+}
+
+// handleAccept accepts the command and attributes of msg, unless they are
+// already committed, and replies.
+func (r *replica) handleAccept(msg accept) {
+	if e, ok := r.log[msg.inst]; !ok || e.status < statusCommitted {
+		r.log[msg.inst] = &logEntry{value: msg.value, attrs: msg.attrs,
+			status: statusAccepted}
+	}
+	r.send(msg.inst.replica, acceptOK{inst: msg.inst, replicaID: r.id})
+}
+
+// handleCommit records that the command of msg is committed, and replies.
+func (r *replica) handleCommit(msg commit) {
+	if e, ok := r.log[msg.inst]; !ok || e.status < statusCommitted {
+		r.log[msg.inst] = &logEntry{value: msg.value, attrs: msg.attrs,
+			status: statusCommitted}
+	}
+	r.send(msg.inst.replica, commitOK{inst: msg.inst, replicaID: r.id})
+}
+
+// handleReply counts a reply from the replica numbered from for the instance
+// inst, which the replica leads, if the instance is still in the status that
+// the reply answers.
+func (r *replica) handleReply(inst instance, status, from int) {
+	l, ok := r.leading[inst]
+	if !ok || l.status != status {
+		return
+	}
+	l.replies[from] = true
+	r.advance(inst)
+}
+
+// advance moves the instance inst, which the replica leads, on to its next
+// status once enough replicas have replied: on the fast path once a fast
+// quorum has pre-accepted unchanged attributes; on the slow path once a
+// majority has replied with changed ones, and again once a majority has
+// accepted; and to done once every replica knows it is committed.
+func (r *replica) advance(inst instance) {
+	l := r.leading[inst]
+	n := len(l.replies) + 1 // the leader replies to itself
+
+	switch l.status {
+	case statusPreAccepted:
+		if !l.changed && n >= r.fastQuorum() {
+			r.commit(inst, true)
+		} else if l.changed && n >= r.slowQuorum() {
+			r.accept(inst)
+		}
+	case statusAccepted:
+		if n >= r.slowQuorum() {
+			r.commit(inst, false)
+		}
+	case statusCommitted:
+		if n == r.nReplicas {
+			delete(r.leading, inst)
+		}
+	}
+}
+
+// accept starts the slow path for the instance inst, which the replica leads,
+// with the union of the attributes in the replies.
+func (r *replica) accept(inst instance) {
+	l, e := r.leading[inst], r.log[inst]
+	l.status, l.replies = statusAccepted, make(map[int]bool)
+	e.attrs, e.status = l.attrs, statusAccepted
+
+	r.toOthers(accept{inst: inst, value: e.value, attrs: e.attrs}, nil)
+	r.advance(inst)
+}
+
+// commit commits the instance inst, which the replica leads, on the fast path
+// if fast is true, and tells the other replicas.
+func (r *replica) commit(inst instance, fast bool) {
+	l, e := r.leading[inst], r.log[inst]
+	l.status, l.replies = statusCommitted, make(map[int]bool)
+	e.attrs, e.status = l.attrs, statusCommitted
+
+	path := "slow"
+	if fast {
+		path = "fast"
+	}
+	fmt.Fprintf(r.out, "replica %d committed %s in instance %s on the %s path\n",
+		r.id, e.value, inst, path)
+	r.env.TraceStep(classicpaxos.EventDecided, ReplicaNode(r.id), e.value)
+	r.metrics.committed(fast, r.env.Now()-l.start)
+
+	r.toOthers(commit{inst: inst, value: e.value, attrs: e.attrs}, nil)
+	r.advance(inst)
+}
+
+// retransmit resends the message of each unfinished instance that the replica
+// leads to the replicas that have yet to reply. A pre-accepted instance that a
+// majority has answered moves on to the slow path instead.
+func (r *replica) retransmit() {
+	insts := make([]instance, 0, len(r.leading))
+	for inst := range r.leading {
+		insts = append(insts, inst)
+	}
+	if len(insts) == 0 {
+		return
+	}
+	sort.Slice(insts, func(i, j int) bool { return insts[i].before(insts[j]) })
+
+	r.env.TraceStep(classicpaxos.EventTimeout, ReplicaNode(r.id), "")
+	for _, inst := range insts {
+		l, e := r.leading[inst], r.log[inst]
+		switch l.status {
+		case statusPreAccepted:
+			if len(l.replies)+1 >= r.slowQuorum() {
+				r.accept(inst)
+			} else {
+				r.toOthers(preAccept{inst: inst, value: e.value,
+					attrs: l.original}, l.replies)
+			}
+		case statusAccepted:
+			r.toOthers(accept{inst: inst, value: e.value, attrs: e.attrs},
+				l.replies)
+		case statusCommitted:
+			r.toOthers(commit{inst: inst, value: e.value, attrs: e.attrs},
+				l.replies)
+		}
+	}
+}
+
+// execute executes every committed command whose dependencies are, in turn,
+// all committed.
+func (r *replica) execute() {
+	var insts []instance
+	for inst, e := range r.log {
+		if e.status == statusCommitted {
+			insts = append(insts, inst)
+		}
+	}
+	sort.Slice(insts, func(i, j int) bool { return insts[i].before(insts[j]) })
+
+	for _, inst := range insts {
+		if r.log[inst].status != statusCommitted {
+			continue // executed along with an earlier instance
+		}
+
+		t := &tarjan{index: make(map[instance]int),
+			low: make(map[instance]int), onStack: make(map[instance]bool)}
+		if !r.connect(inst, t) {
+			continue // some dependency is not yet committed
+		}
+		for _, component := range t.components {
+			sort.Slice(component, func(i, j int) bool {
+				a, b := r.log[component[i]], r.log[component[j]]
+				if a.attrs.seq != b.attrs.seq {
+					return a.attrs.seq < b.attrs.seq
+				}
+				return component[i].before(component[j])
+			})
+			for _, i := range component {
+				r.executeInstance(i)
+			}
+		}
+	}
+}
+
+// executeInstance executes the command of the instance inst.
+func (r *replica) executeInstance(inst instance) {
+	e := r.log[inst]
+	e.status = statusExecuted
+	fmt.Fprintf(r.out, "replica %d executed %s in instance %s\n", r.id, e.value,
+		inst)
+	r.executions.Send(execution{replicaID: r.id, value: e.value})
+}
+
+// tarjan holds the progress of Tarjan's algorithm for finding the strongly
+// connected components of the dependency graph.
+type tarjan struct {
+	next       int               // index of the next instance visited
+	index      map[instance]int  // order in which the instances were visited
+	low        map[instance]int  // least index reachable from each instance
+	stack      []instance        // instances of the components not yet found
+	onStack    map[instance]bool // keys are the instances on stack
+	components [][]instance      // components found, dependencies first
+}
+
+// connect visits the committed instance v and the unexecuted instances that it
+// depends on, and adds to t.components each component of which it finds every
+// instance. It returns false if v depends on an instance that is not
+// committed.
+func (r *replica) connect(v instance, t *tarjan) bool {
+	t.index[v], t.low[v] = t.next, t.next
+	t.next++
+	t.stack = append(t.stack, v)
+	t.onStack[v] = true
+
+	for _, w := range r.log[v].attrs.deps {
+		e, ok := r.log[w]
+		if !ok || e.status < statusCommitted {
+			return false
+		}
+		if e.status == statusExecuted {
+			continue
+		}
+		if _, visited := t.index[w]; !visited {
+			if !r.connect(w, t) {
+				return false
+			}
+			if t.low[w] < t.low[v] {
+				t.low[v] = t.low[w]
+			}
+		} else if t.onStack[w] && t.index[w] < t.low[v] {
+			t.low[v] = t.index[w]
+		}
+	}
+
+	if t.low[v] == t.index[v] {
+		var component []instance
+		for {
+			w := t.stack[len(t.stack)-1]
+			t.stack = t.stack[:len(t.stack)-1]
+			t.onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		t.components = append(t.components, component)
+	}
+	return true
+}
+
+// send sends m to the replica numbered id.
+func (r *replica) send(id int, m classicpaxos.Message) {
+	r.env.Send(ReplicaNode(r.id), ReplicaNode(id), m)
+}
+
+// toOthers sends m to every other replica, but those that are keys of skip.
+func (r *replica) toOthers(m classicpaxos.Message, skip map[int]bool) {
+	for id := 0; id < r.nReplicas; id++ {
+		if id != r.id && !skip[id] {
+			r.send(id, m)
+		}
+	}
+}
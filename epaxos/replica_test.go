@@ -0,0 +1,67 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epaxos
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+func TestThatAttributesUnionTakesTheGreaterSeqAndEveryDependency(t *testing.T) {
+	a := attributes{seq: 2, deps: []instance{{0, 1}, {2, 0}}}
+	b := attributes{seq: 3, deps: []instance{{1, 4}, {2, 0}}}
+	want := attributes{seq: 3, deps: []instance{{0, 1}, {1, 4}, {2, 0}}}
+	if got := a.union(b); !got.equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestThatReplicasExecuteCommittedCommandsInDependencyOrder(t *testing.T) {
+	env, err := classicpaxos.NewEnv(classicpaxos.EnvConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executions := env.NewChannel(10)
+	r := &replica{id: 0, nReplicas: 3, executions: executions, out: io.Discard,
+		log: map[instance]*logEntry{
+			// r0.0 and r1.0 depend on each other, and r1.0 has the least seq
+			{0, 0}: {value: "k=a", status: statusCommitted,
+				attrs: attributes{seq: 2, deps: []instance{{1, 0}}}},
+			{1, 0}: {value: "k=b", status: statusCommitted,
+				attrs: attributes{seq: 1, deps: []instance{{0, 0}}}},
+			{2, 0}: {value: "k=c", status: statusCommitted,
+				attrs: attributes{seq: 3, deps: []instance{{0, 0}}}},
+
+			// r2.1 depends on r1.1, which is not yet committed
+			{1, 1}: {value: "k=d", status: statusAccepted,
+				attrs: attributes{seq: 4, deps: []instance{{2, 0}}}},
+			{2, 1}: {value: "k=e", status: statusCommitted,
+				attrs: attributes{seq: 5, deps: []instance{{1, 1}}}},
+		}}
+
+	r.execute()
+	executions.Close()
+
+	var got []string
+	for m := executions.Receive(); m != nil; m = executions.Receive() {
+		got = append(got, m.(execution).value)
+	}
+	if want := "[k=b k=a k=c]"; fmt.Sprint(got) != want {
+		t.Errorf("executed %v, want %s", got, want)
+	}
+}
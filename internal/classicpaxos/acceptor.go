@@ -14,49 +14,163 @@
 
 package classicpaxos
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"io"
+)
 
-// acceptor represents the acceptor role in Classic Paxos.
-type acceptor struct {
-	input <-chan message // input channel
-	id    int            // acceptor identifier
+// Acceptor represents the acceptor role in Classic Paxos.
+type Acceptor struct {
+	acceptorCore           // acceptor identifier and number of learners
+	input        channel   // input channel
+	transport    Transport // for replying to proposers and notifying learners
+	storage      Storage   // where acceptor saves its state before replying
+	trace        *tracer   // acceptor traces the messages it receives
+	out          io.Writer // acceptor prints its progress to out
+
+	done chan struct{} // closed when the acceptor's goroutine returns
 }
 
-// newAcceptor creates an acceptor with the given id and input channel, and
-// starts its goroutine.
-func newAcceptor(id int, input <-chan message) *acceptor {
-	a := &acceptor{input: input, id: id}
-	go a.run()
+// NewAcceptor returns an acceptor numbered id, which recovers its state from
+// storage, replies to proposers over transport, and notifies the learners
+// numbered 0 to nLearners-1 of each proposal it accepts. The acceptor runs in
+// its own goroutine until ctx is done, and prints its progress to out unless
+// out is nil.
+func NewAcceptor(ctx context.Context, id int, transport Transport,
+	nLearners int, storage Storage, out io.Writer) *Acceptor {
+
+	s := newRealScheduler()
+	s.stopWhenDone(ctx)
+	return newAcceptor(s, id, s.newChannel(inboxSize), transport, nLearners,
+		false, storage, nil, orDiscard(out))
+}
+
+// newAcceptor creates an acceptor with the given id, input channel, transport,
+// number of learners, storage and tracer, and starts its goroutine using the
+// scheduler s. Unless noRejects, the acceptor rejects prepare and propose
+// messages for epochs less than its promised epoch.
+func newAcceptor(s scheduler, id int, input channel, transport Transport,
+	nLearners int, noRejects bool, storage Storage, trace *tracer,
+	out io.Writer) *Acceptor {
+
+	core := acceptorCore{id: id, nLearners: nLearners, noRejects: noRejects}
+	a := &Acceptor{acceptorCore: core, input: input, transport: transport,
+		storage: storage, trace: trace, out: out, done: make(chan struct{})}
+	s.spawn(func() {
+		defer close(a.done)
+		a.run()
+	})
 	return a
 }
 
-// run is a translation of the acceptor algorithm for Classic Paxos.
-func (a *acceptor) run() {
-	var promisedEpoch Epoch
-	var acceptedEpoch Epoch
-	acceptedValue := ""
+// Wait waits until the acceptor's goroutine returns, which happens once its
+// context is done, or if it fails to recover its state.
+func (a *Acceptor) Wait() {
+	<-a.done
+}
+
+// Deliver delivers m, which was sent to the acceptor, or loses m if the
+// acceptor has yet to receive inboxSize messages delivered earlier.
+func (a *Acceptor) Deliver(m Message) {
+	a.input.trySend(m)
+}
+
+// run runs the acceptor. Each time the acceptor crashes, run waits for it to
+// restart.
+func (a *Acceptor) run() {
+	for a.serve() {
+		if !a.awaitRestart() {
+			return
+		}
+	}
+}
+
+// serve runs the acceptor algorithm for Classic Paxos, which step implements,
+// on the messages that the acceptor receives. It starts from the state in
+// a.storage, and saves its state there before replying to each message, so
+// that the acceptor may recover after a crash. serve returns true if the
+// acceptor crashes, and false if it fails to recover its state or its input
+// channel is closed.
+func (a *Acceptor) serve() bool {
+	state, err := a.storage.Load()
+	if err != nil {
+		fmt.Fprintf(a.out, "acceptor %d failed to recover: %v\n", a.id, err)
+		return false
+	}
 
 	for {
-		m := <-a.input
+		m := a.input.receive()
+		if m == nil {
+			return false
+		}
 
-		fmt.Printf("acceptor %d received message %s\n", a.id, m)
+		fmt.Fprintf(a.out, "acceptor %d received message %s\n", a.id, m)
+		a.trace.arrival(EventReceived, AcceptorNode(a.id), m, "")
 
-		switch msg := m.(type) {
-		case prepare:
-			epoch := msg.epoch
-			if promisedEpoch.Nil() || epoch.Cmp(promisedEpoch) >= 0 {
-				promisedEpoch = epoch
-				msg.replyTo <- promise{acceptorID: a.id, epoch: epoch,
-					acceptedEpoch: acceptedEpoch, acceptedValue: acceptedValue}
-			}
-		case propose:
-			epoch := msg.epoch
-			value := msg.value
-			if promisedEpoch.Nil() || epoch.Cmp(promisedEpoch) >= 0 {
-				promisedEpoch = epoch
-				acceptedValue, acceptedEpoch = value, epoch
-				msg.replyTo <- accept{acceptorID: a.id, epoch: epoch}
+		if _, ok := m.(Crash); ok {
+			return true
+		}
+
+		var outputs []output
+		state, outputs = a.step(state, m)
+		if len(outputs) == 0 {
+			continue
+		}
+		if !leavesState(m, outputs) && !a.save(state) {
+			continue
+		}
+		for _, o := range outputs {
+			a.transport.Send(AcceptorNode(a.id), o.to, o.msg)
+		}
+	}
+}
+
+// awaitRestart loses every message sent to the crashed acceptor until it
+// receives a restart message, and then returns true; or returns false if its
+// input channel is closed first. If the acceptor is to lose its state, then
+// awaitRestart erases the state from a.storage, after telling the monitor of
+// a run, if any, that the acceptor restarts afresh.
+func (a *Acceptor) awaitRestart() bool {
+	for {
+		m := a.input.receive()
+		if m == nil {
+			return false
+		}
+
+		if msg, ok := m.(Restart); ok {
+			fmt.Fprintf(a.out, "acceptor %d received message %s\n", a.id, msg)
+			a.trace.arrival(EventReceived, AcceptorNode(a.id), msg, "")
+			if msg.loseState {
+				if s, ok := a.storage.(monitoredStorage); ok {
+					s.monitor.restarted(a.id)
+				}
+				a.save(AcceptorState{})
 			}
+			return true
 		}
+
+		fmt.Fprintf(a.out, "acceptor %d is down, lost message %s\n", a.id, m)
+		a.trace.arrival(EventDropped, AcceptorNode(a.id), m, "acceptor down")
+	}
+}
+
+// save saves the acceptor's state to a.storage. If saving fails, it returns
+// false, and the acceptor must not reply to the message it is handling.
+func (a *Acceptor) save(state AcceptorState) bool {
+	if err := a.storage.Save(state); err != nil {
+		fmt.Fprintf(a.out, "acceptor %d failed to save state: %v\n", a.id, err)
+		return false
+	}
+	return true
+}
+
+// leavesState returns whether the acceptor's outputs in reply to m leave its
+// state alone, so that it need not save the state before sending them.
+func leavesState(m Message, outputs []output) bool {
+	if _, ok := m.(query); ok {
+		return true
 	}
+	_, ok := outputs[0].msg.(reject)
+	return ok
 }
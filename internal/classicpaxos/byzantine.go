@@ -0,0 +1,485 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"sync"
+)
+
+// Byzantine Paxos [1] tolerates acceptors that deviate arbitrarily from the
+// acceptor algorithm, rather than merely crash, provided the proposers and
+// learners are correct. Of n = 3f+1 acceptors, up to f may be malicious. Three
+// changes to Classic Paxos suffice:
+//
+//  1. Every message is signed with its sender's ed25519 key, so that a
+//     malicious acceptor cannot speak in the name of another.
+//  2. A promise that reports an accepted proposal carries the proposer's
+//     signed propose message as proof, so that a malicious acceptor cannot
+//     invent a proposal, though it may hide one.
+//  3. A quorum in either phase is any 2f+1 acceptors, so that any two quorums
+//     share at least f+1 acceptors, of which at least one is correct.
+//
+// A value is then chosen once f+1 correct acceptors have accepted it, and
+// every phase 1 quorum includes a correct acceptor that reports it, or a
+// later proposal, which carries the same value.
+//
+// [1] Miguel Castro and Barbara Liskov. 1999. Practical Byzantine fault
+// tolerance. In Proceedings of the Third Symposium on Operating Systems
+// Design and Implementation (OSDI '99), 173-186.
+
+// Misbehavior is a way in which a malicious acceptor deviates from the
+// acceptor algorithm.
+type Misbehavior int
+
+const (
+	// Equivocate answers each proposer in the names of every acceptor, so
+	// that each proposer believes that a quorum agrees with it.
+	Equivocate Misbehavior = iota
+
+	// LieAboutValue reports, in each promise, that the acceptor accepted
+	// maliciousValue in the epoch in which it accepted a proposal.
+	LieAboutValue
+
+	// ForgeEpochs reports, in each promise, that the acceptor accepted its
+	// value in the epoch just before the prepared one, so that the value
+	// overrides every value that other acceptors report.
+	ForgeEpochs
+)
+
+// maliciousValue is the value that a malicious acceptor reports when it lies.
+var maliciousValue = stringValue("evil")
+
+// misbehaviors are the names of the misbehaviors.
+var misbehaviors = []string{"equivocate", "lie", "forge"}
+
+// String returns the name of a misbehavior.
+func (b Misbehavior) String() string {
+	if b < 0 || int(b) >= len(misbehaviors) {
+		return fmt.Sprintf("misbehavior(%d)", int(b))
+	}
+	return misbehaviors[b]
+}
+
+// ParseMisbehavior returns the misbehavior whose name is s.
+func ParseMisbehavior(s string) (Misbehavior, error) {
+	for b, name := range misbehaviors {
+		if name == s {
+			return Misbehavior(b), nil
+		}
+	}
+	return 0, fmt.Errorf("misbehavior %q is not equivocate, lie or forge", s)
+}
+
+// MaliciousAcceptor makes an acceptor malicious in a run.
+type MaliciousAcceptor struct {
+	Acceptor int         // which acceptor is malicious
+	Behavior Misbehavior // how it misbehaves
+}
+
+// String returns the string form of a malicious acceptor.
+func (m MaliciousAcceptor) String() string {
+	return fmt.Sprintf("acceptor %d: %s", m.Acceptor, m.Behavior)
+}
+
+// byzantineQuorums returns the quorum system of Byzantine Paxos among
+// nAcceptors acceptors, of which it tolerates f malicious: a quorum in either
+// phase is any set of acceptors large enough that two quorums share f+1.
+func byzantineQuorums(nAcceptors int) Flexible {
+	f := maxMalicious(nAcceptors)
+	q := (nAcceptors + f + 2) / 2
+	return Flexible{Phase1: q, Phase2: q}
+}
+
+// maxMalicious returns the number of malicious acceptors among nAcceptors
+// that Byzantine Paxos tolerates.
+func maxMalicious(nAcceptors int) int {
+	if nAcceptors < 1 {
+		return 0
+	}
+	return (nAcceptors - 1) / 3
+}
+
+// checkByzantine returns a non-nil error if c.MaliciousAcceptors refers to an
+// acceptor that does not exist or appears twice, or if c asks for Byzantine
+// Paxos with more malicious acceptors than it tolerates, or with an option
+// that it does not support.
+func (c *Config) checkByzantine() error {
+	malicious := make(map[int]bool)
+	for _, m := range c.MaliciousAcceptors {
+		if m.Acceptor < 0 || m.Acceptor >= c.NAcceptors {
+			return fmt.Errorf("malicious nonexistent acceptor %d", m.Acceptor)
+		}
+		if malicious[m.Acceptor] {
+			return fmt.Errorf("acceptor %d is malicious twice", m.Acceptor)
+		}
+		malicious[m.Acceptor] = true
+	}
+
+	if !c.Byzantine {
+		return nil
+	}
+	if f := maxMalicious(c.NAcceptors); len(malicious) > f {
+		return fmt.Errorf("Byzantine Paxos with %d acceptors tolerates at most "+
+			"%d malicious acceptor/s", c.NAcceptors, f)
+	}
+	switch {
+	case c.Quorums != nil:
+		return fmt.Errorf("Byzantine Paxos chooses its own quorums")
+	case c.Fast:
+		return fmt.Errorf("Byzantine Paxos does not support Fast Paxos")
+	case c.WALDir != "":
+		return fmt.Errorf("Byzantine Paxos acceptors do not support a WALDir")
+	}
+	return nil
+}
+
+// signed is a message signed by the node that sent it.
+type signed struct {
+	msg      Message // message signed
+	from, to Node    // sender and receiver
+	sig      []byte  // sender's signature of msg, from and to
+}
+
+// String returns the string form of a signed message.
+func (s signed) String() string {
+	return fmt.Sprintf("%s, signed by %s", s.msg, s.from)
+}
+
+// provenPromise is a promise that carries, as proof of the proposal that the
+// acceptor reports it accepted, the proposer's signed propose message.
+type provenPromise struct {
+	promise
+	proof *signed // nil if the acceptor reports no proposal
+}
+
+// signedBytes returns the bytes that the node from signs to send m to the
+// node to: the wire form of m, or its string form if it has none, followed by
+// the signature of its proof.
+func signedBytes(from, to Node, m Message) []byte {
+	if p, ok := m.(provenPromise); ok {
+		b := signedBytes(from, to, p.promise)
+		if p.proof != nil {
+			b = append(b, p.proof.sig...)
+		}
+		return b
+	}
+	if b, err := EncodeMessage(from, "", to, m); err == nil {
+		return b
+	}
+	return []byte(fmt.Sprintf("%s from %s to %s", m, from, to))
+}
+
+// keyring holds the key pair of every participant in a run of Byzantine
+// Paxos. Every participant knows every public key, but signs with its own
+// private key only.
+type keyring struct {
+	public  map[Node]ed25519.PublicKey
+	private map[Node]ed25519.PrivateKey
+}
+
+// newKeyring returns a keyring with a key pair for each node, generated from
+// r so that a simulated run is repeatable.
+func newKeyring(r *rand.Rand, nodes []Node) *keyring {
+	k := &keyring{public: make(map[Node]ed25519.PublicKey),
+		private: make(map[Node]ed25519.PrivateKey)}
+	for _, n := range nodes {
+		seed := make([]byte, ed25519.SeedSize)
+		r.Read(seed)
+		k.private[n] = ed25519.NewKeyFromSeed(seed)
+		k.public[n] = k.private[n].Public().(ed25519.PublicKey)
+	}
+	return k
+}
+
+// sign returns m, sent from the node from to the node to, signed with the
+// private key of from.
+func (k *keyring) sign(from, to Node, m Message) signed {
+	return signed{msg: m, from: from, to: to,
+		sig: ed25519.Sign(k.private[from], signedBytes(from, to, m))}
+}
+
+// check returns a non-nil error unless s was signed by the node that it says
+// sent it, and that node is the one that m says sent it.
+func (k *keyring) check(s signed) error {
+	if n, ok := sender(s.msg); ok && n != s.from {
+		return fmt.Errorf("sent by %s in the name of %s", s.from, n)
+	}
+	public, ok := k.public[s.from]
+	if !ok {
+		return fmt.Errorf("signed by unknown node %s", s.from)
+	}
+	if !ed25519.Verify(public, signedBytes(s.from, s.to, s.msg), s.sig) {
+		return fmt.Errorf("signature of %s does not verify", s.from)
+	}
+	return nil
+}
+
+// checkProof returns a non-nil error unless p carries a signed propose
+// message for the proposal that it reports, in an epoch no later than p's.
+func (k *keyring) checkProof(p provenPromise) error {
+	if p.acceptedEpoch.Nil() {
+		return nil
+	}
+	if p.proof == nil {
+		return fmt.Errorf("no proof of proposal (%s, %s)", p.acceptedEpoch,
+			p.acceptedValue)
+	}
+	if err := k.check(*p.proof); err != nil {
+		return fmt.Errorf("proof %v", err)
+	}
+	proposal, ok := p.proof.msg.(propose)
+	if !ok {
+		return fmt.Errorf("proof %s is not a propose message", p.proof.msg)
+	}
+	if proposal.epoch.Cmp(p.acceptedEpoch) != 0 ||
+		proposal.value != p.acceptedValue {
+		return fmt.Errorf("proof is of proposal (%s, %s), not (%s, %s)",
+			proposal.epoch, proposal.value, p.acceptedEpoch, p.acceptedValue)
+	}
+	if p.acceptedEpoch.Cmp(p.epoch) > 0 {
+		return fmt.Errorf("accepted epoch %s is after promised epoch %s",
+			p.acceptedEpoch, p.epoch)
+	}
+	return nil
+}
+
+// authenticator is the Transport of a participant in Byzantine Paxos. It signs
+// each message that the participant sends, and attaches proof to each
+// promise. It also verifies each message that arrives for the participant,
+// and loses the message unless it verifies.
+type authenticator struct {
+	node   Node      // participant's node
+	keys   *keyring  // keys of every participant
+	net    Transport // network over which to send signed messages
+	output channel   // verified messages for the participant
+	trace  *tracer   // authenticator traces the messages it loses
+	out    io.Writer // authenticator prints the messages it loses to out
+
+	mu     sync.Mutex        // guards proofs
+	proofs map[string]signed // signed propose messages received, by epoch
+}
+
+// newAuthenticator returns an authenticator for the participant at node n,
+// and starts its goroutine, which verifies the messages on input, using the
+// scheduler s.
+func newAuthenticator(s scheduler, n Node, keys *keyring, input channel,
+	net Transport, trace *tracer, out io.Writer) *authenticator {
+
+	a := &authenticator{node: n, keys: keys, net: net,
+		output: s.newChannel(0), trace: trace, out: out,
+		proofs: make(map[string]signed)}
+	s.spawn(func() { a.run(input) })
+	return a
+}
+
+// Send signs m, along with any proof that it needs, with the participant's key,
+// and sends it from the node from to the node to. A signature in the name of
+// another node does not verify.
+func (a *authenticator) Send(from, to Node, m Message) {
+	if p, ok := m.(promise); ok {
+		proven := provenPromise{promise: p}
+		a.mu.Lock()
+		if proof, ok := a.proofs[p.acceptedEpoch.String()]; ok &&
+			!p.acceptedEpoch.Nil() {
+			proven.proof = &proof
+		}
+		a.mu.Unlock()
+		m = proven
+	}
+	a.net.Send(from, to, a.keys.sign(a.node, to, m))
+}
+
+// run places each message on input that verifies on a.output, stripped of its
+// signature and proof, until input is closed. It keeps the signed propose
+// messages, to prove later promises.
+func (a *authenticator) run(input channel) {
+	for {
+		m := input.receive()
+		if m == nil {
+			a.output.close()
+			return
+		}
+
+		msg, err := a.verify(m)
+		if err != nil {
+			fmt.Fprintf(a.out, "%s discarded message %s: %v\n", a.node, m, err)
+			a.trace.arrival(EventDropped, a.node, m, err.Error())
+			continue
+		}
+		if p, ok := msg.(propose); ok {
+			a.mu.Lock()
+			a.proofs[p.epoch.String()] = m.(signed)
+			a.mu.Unlock()
+		}
+		a.output.send(msg)
+	}
+}
+
+// verify returns the message that m carries, or an error if m is not signed
+// by its sender, or is a promise without proof of the proposal it reports.
+func (a *authenticator) verify(m Message) (Message, error) {
+	s, ok := m.(signed)
+	if !ok {
+		return nil, fmt.Errorf("not signed")
+	}
+	if err := a.keys.check(s); err != nil {
+		return nil, err
+	}
+	if p, ok := s.msg.(provenPromise); ok {
+		if err := a.keys.checkProof(p); err != nil {
+			return nil, err
+		}
+		return p.promise, nil
+	}
+	return s.msg, nil
+}
+
+// maliciousAcceptor is an acceptor that follows the acceptor algorithm, but
+// then tampers with the messages it sends, as its misbehavior says. It keeps
+// its state in memory, and ignores crashes.
+type maliciousAcceptor struct {
+	acceptorCore             // acceptor identifier and number of learners
+	behavior     Misbehavior // how the acceptor misbehaves
+	nAcceptors   int         // number of acceptors, in whose names to speak
+	input        channel     // input channel
+	transport    Transport   // for replying to proposers and notifying learners
+	trace        *tracer     // acceptor traces the messages it receives
+	out          io.Writer   // acceptor prints its progress and lies to out
+}
+
+// newMaliciousAcceptor creates a malicious acceptor with the given
+// parameters, and starts its goroutine using the scheduler s.
+func newMaliciousAcceptor(s scheduler, id int, behavior Misbehavior,
+	nAcceptors int, input channel, transport Transport, nLearners int,
+	trace *tracer, out io.Writer) *maliciousAcceptor {
+
+	a := &maliciousAcceptor{
+		acceptorCore: acceptorCore{id: id, nLearners: nLearners},
+		behavior:     behavior,
+		nAcceptors:   nAcceptors,
+		input:        input,
+		transport:    transport,
+		trace:        trace,
+		out:          out,
+	}
+	s.spawn(a.run)
+	return a
+}
+
+// run runs the malicious acceptor until its input channel is closed.
+func (a *maliciousAcceptor) run() {
+	var state AcceptorState
+	for {
+		m := a.input.receive()
+		if m == nil {
+			return
+		}
+		switch m.(type) {
+		case Crash, Restart:
+			continue // a malicious acceptor does not play by the rules
+		}
+
+		fmt.Fprintf(a.out, "acceptor %d received message %s\n", a.id, m)
+		a.trace.arrival(EventReceived, AcceptorNode(a.id), m, "")
+
+		var outputs []output
+		state, outputs = a.step(state, m)
+		for _, o := range outputs {
+			for _, lie := range a.tamper(state, o) {
+				if lie != o.msg {
+					fmt.Fprintf(a.out, "acceptor %d lies to %s: %s\n", a.id, o.to,
+						lie)
+				}
+				a.transport.Send(AcceptorNode(a.id), o.to, lie)
+			}
+		}
+	}
+}
+
+// tamper returns the messages that the acceptor sends in place of the output
+// o, given its state s after the step that produced o.
+func (a *maliciousAcceptor) tamper(s AcceptorState, o output) []Message {
+	switch msg := o.msg.(type) {
+	case promise:
+		switch a.behavior {
+		case Equivocate:
+			// every other acceptor promises, having accepted nothing
+			var lies []Message
+			for id := 0; id < a.nAcceptors; id++ {
+				if id == a.id {
+					lies = append(lies, msg)
+				} else {
+					lies = append(lies, promise{epoch: msg.epoch, acceptorID: id})
+				}
+			}
+			return lies
+		case LieAboutValue:
+			if !msg.acceptedEpoch.Nil() {
+				msg.acceptedValue = maliciousValue
+			}
+		case ForgeEpochs:
+			if before := previousEpoch(msg.epoch); !before.Nil() {
+				msg.acceptedEpoch = before
+				if msg.acceptedValue.Nil() {
+					msg.acceptedValue = maliciousValue
+				}
+			}
+		}
+		return []Message{msg}
+	case accept:
+		if a.behavior == Equivocate {
+			var lies []Message
+			for id := 0; id < a.nAcceptors; id++ {
+				lies = append(lies, accept{epoch: msg.epoch, acceptorID: id})
+			}
+			return lies
+		}
+	case accepted:
+		if a.behavior == Equivocate {
+			var lies []Message
+			for id := 0; id < a.nAcceptors; id++ {
+				lies = append(lies, accepted{epoch: msg.epoch, value: msg.value,
+					acceptorID: id})
+			}
+			return lies
+		}
+	}
+	return []Message{o.msg}
+}
+
+// previousEpoch returns the epoch just before e, whichever proposer it
+// belongs to, or the zero epoch if e is the first epoch of all. Before the
+// pair epoch (r, 0) comes the epoch of round r - 1 of the greatest proposer
+// that there could be.
+func previousEpoch(e Epoch) Epoch {
+	if e.isPair() && e.proposer > 0 {
+		return Epoch{i: e.i, proposer: e.proposer - 1}
+	}
+	if e.Nil() || e.i.Sign() <= 0 {
+		return Epoch{}
+	}
+	if e.isPair() {
+		return Epoch{i: new(big.Int).Sub(e.i, big.NewInt(1)),
+			proposer: math.MaxInt32}
+	}
+	return Epoch{i: new(big.Int).Sub(e.i, big.NewInt(1)),
+		nProposers: e.nProposers}
+}
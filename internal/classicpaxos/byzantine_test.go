@@ -0,0 +1,153 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThatByzantineQuorumsShareACorrectAcceptor(t *testing.T) {
+	for _, test := range []struct {
+		nAcceptors, malicious, quorum int
+	}{
+		{4, 1, 3},
+		{5, 1, 4},
+		{7, 2, 5},
+		{10, 3, 7},
+	} {
+		if got := maxMalicious(test.nAcceptors); got != test.malicious {
+			t.Errorf("%d acceptors tolerate %d malicious, want %d",
+				test.nAcceptors, got, test.malicious)
+		}
+		want := Flexible{Phase1: test.quorum, Phase2: test.quorum}
+		if got := byzantineQuorums(test.nAcceptors); got != want {
+			t.Errorf("quorums of %d acceptors: got %v, want %v",
+				test.nAcceptors, got, want)
+		}
+	}
+}
+
+func TestThatKeyringRejectsForgedMessages(t *testing.T) {
+	p0, a0, a1 := ProposerNode(0), AcceptorNode(0), AcceptorNode(1)
+	k := newKeyring(rand.New(rand.NewSource(1)), []Node{p0, a0, a1})
+	e, later := newEpoch(0, 1), newEpoch(0, 1).Next()
+	proof := k.sign(p0, a0, propose{epoch: e, value: v0})
+
+	tampered := k.sign(a0, p0, promise{epoch: e, acceptorID: 0})
+	tampered.msg = promise{epoch: later, acceptorID: 0}
+
+	unknown := k.sign(a0, p0, promise{epoch: e, acceptorID: 2})
+	unknown.from = AcceptorNode(2)
+
+	for _, test := range []struct {
+		name string
+		s    signed
+		err  string // empty if s should verify
+	}{
+		{"signed by its sender", k.sign(a0, p0, accept{epoch: e}), ""},
+		{"signed in another's name", k.sign(a0, p0, accept{epoch: e,
+			acceptorID: 1}), "sent by a0 in the name of a1"},
+		{"altered after signing", tampered, "does not verify"},
+		{"signed by an unknown node", unknown, "unknown node"},
+	} {
+		err := k.check(test.s)
+		if test.err == "" && err != nil ||
+			test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		p    provenPromise
+		err  string // empty if p should verify
+	}{
+		{"nothing accepted needs no proof",
+			provenPromise{promise: promise{epoch: later}}, ""},
+		{"accepted proposal with its proof",
+			provenPromise{promise{later, e, v0, 0}, &proof}, ""},
+		{"accepted proposal without proof",
+			provenPromise{promise: promise{later, e, v0, 0}}, "no proof"},
+		{"lie about the value",
+			provenPromise{promise{later, e, maliciousValue, 0}, &proof}, "not (0, evil)"},
+		{"lie about the epoch",
+			provenPromise{promise{later, later, v0, 0}, &proof}, "not (1, v0)"},
+	} {
+		err := k.checkProof(test.p)
+		if test.err == "" && err != nil ||
+			test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestThatMaliciousAcceptorsBreakClassicPaxos(t *testing.T) {
+	for _, behavior := range []Misbehavior{Equivocate, LieAboutValue,
+		ForgeEpochs} {
+
+		broken := false
+		for seed := int64(1); seed <= 100 && !broken; seed++ {
+			c := byzantineConfig(seed, false, behavior)
+			broken = c.Run() != nil
+		}
+		if !broken {
+			t.Errorf("acceptor that misbehaves with %s broke no run", behavior)
+		}
+	}
+}
+
+func TestThatByzantinePaxosToleratesMaliciousAcceptors(t *testing.T) {
+	for _, behavior := range []Misbehavior{Equivocate, LieAboutValue,
+		ForgeEpochs} {
+
+		for seed := int64(1); seed <= 20; seed++ {
+			c := byzantineConfig(seed, true, behavior)
+			if err := c.Run(); err != nil {
+				t.Errorf("%s, seed %d: %v", behavior, seed, err)
+			}
+		}
+	}
+}
+
+// byzantineConfig returns the configuration of a run with 4 acceptors, of
+// which acceptor 3 misbehaves as behavior says.
+func byzantineConfig(seed int64, byzantine bool,
+	behavior Misbehavior) Config {
+
+	return Config{NProposers: 3, NAcceptors: 4, NLearners: 2,
+		ProposerTimeout: 50 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond, Buffer: 3, Seed: seed,
+		Output: io.Discard, Byzantine: byzantine,
+		MaliciousAcceptors: []MaliciousAcceptor{{Acceptor: 3,
+			Behavior: behavior}}}
+}
+
+func TestThatByzantinePaxosRefusesTooManyMaliciousAcceptors(t *testing.T) {
+	for _, malicious := range [][]MaliciousAcceptor{
+		{{Acceptor: 2}, {Acceptor: 3}},
+		{{Acceptor: 3}, {Acceptor: 3, Behavior: ForgeEpochs}},
+		{{Acceptor: 4}},
+	} {
+		c := Config{NProposers: 2, NAcceptors: 4, Byzantine: true, Seed: 1,
+			MaliciousAcceptors: malicious, Output: io.Discard}
+		if err := c.Run(); err == nil {
+			t.Errorf("ran Byzantine Paxos with malicious acceptors %v", malicious)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import "fmt"
+
+// Cheap Paxos [1] runs with fewer working acceptors than Classic Paxos needs to
+// tolerate the same number of failures. The last few acceptors are auxiliary:
+// the proposers send them nothing while the main acceptors, which form a
+// quorum in both phases on their own, answer. Once a proposer times out
+// waiting for a main acceptor, it engages the auxiliary acceptors too, so that
+// a quorum can form without the unresponsive one. With f+1 main acceptors and
+// f auxiliary acceptors, a run tolerates f failures, yet the auxiliary
+// acceptors sit idle until one happens.
+//
+// Since only which acceptors a proposer sends to changes, and not which sets
+// are quorums, Cheap Paxos is exactly as safe as Classic Paxos.
+//
+// [1] Leslie Lamport and Mike Massa. 2004. Cheap Paxos. In Proceedings of the
+// International Conference on Dependable Systems and Networks (DSN 2004),
+// 307-314.
+
+// cheapQuorums is the quorum system of a run of Cheap Paxos. Its quorums are
+// those of the quorum system that it embeds, and its last Auxiliary acceptors
+// are auxiliary.
+type cheapQuorums struct {
+	QuorumSystem     // quorums in either phase
+	Auxiliary    int // number of auxiliary acceptors
+}
+
+// String returns the string form of a Cheap Paxos quorum system.
+func (q cheapQuorums) String() string {
+	return fmt.Sprintf("%v with %d auxiliary acceptor/s", q.QuorumSystem,
+		q.Auxiliary)
+}
+
+// checkCheap returns a non-nil error unless c.NAuxiliary leaves main acceptors
+// that form a quorum in both phases on their own, or if c asks for Cheap Paxos
+// with an option that it does not support.
+func (c *Config) checkCheap() error {
+	if c.NAuxiliary == 0 {
+		return nil
+	}
+	if c.NAuxiliary < 0 || c.NAuxiliary >= c.NAcceptors {
+		return fmt.Errorf("%d auxiliary acceptor/s of %d acceptor/s",
+			c.NAuxiliary, c.NAcceptors)
+	}
+	switch {
+	case c.Fast:
+		return fmt.Errorf("Cheap Paxos does not support Fast Paxos")
+	case c.Byzantine:
+		return fmt.Errorf("Cheap Paxos does not support Byzantine Paxos")
+	}
+
+	main := make(map[int]bool)
+	for a := 0; a < c.NAcceptors-c.NAuxiliary; a++ {
+		main[a] = true
+	}
+	q := c.classicQuorums()
+	if !q.IsPhase1Quorum(main) || !q.IsPhase2Quorum(main) {
+		return fmt.Errorf("the %d main acceptor/s do not form a quorum in %v",
+			len(main), q)
+	}
+	return nil
+}
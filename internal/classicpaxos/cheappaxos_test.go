@@ -0,0 +1,134 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThatProposerStepEngagesAuxiliaryAcceptorsAfterATimeout(t *testing.T) {
+	e1, e3 := newEpoch(1, 2), newEpoch(3, 2)
+
+	// acceptors 3 and 4 are auxiliary
+	p := proposerCore{id: 1, nProposers: 2, nAcceptors: 5,
+		quorums: cheapQuorums{QuorumSystem: Majority{NAcceptors: 5},
+			Auxiliary: 2}}
+
+	phase1 := func(epoch Epoch, auxiliary bool) proposerState {
+		return proposerState{round: 1, phase: proposerPhase1, epoch: epoch,
+			candidate: v1, promised: map[int]bool{}, accepted: map[int]bool{},
+			auxiliary: auxiliary}
+	}
+	retry := phase1(e3, true)
+	retry.round = 2
+
+	tests := []struct {
+		name    string
+		state   proposerState
+		in      Message
+		want    proposerState
+		outputs string
+	}{
+		{"start prepares the main acceptors only",
+			proposerState{}, proposalStart{value: v1}, phase1(e1, false),
+			"[prepare(1) from proposer 1 to a0 prepare(1) from proposer 1 to a1 " +
+				"prepare(1) from proposer 1 to a2]"},
+		{"timeout prepares the auxiliary acceptors too",
+			phase1(e1, false), proposalTimeout{}, retry,
+			"[prepare(3) from proposer 1 to a0 prepare(3) from proposer 1 to a1 " +
+				"prepare(3) from proposer 1 to a2 prepare(3) from proposer 1 to a3 " +
+				"prepare(3) from proposer 1 to a4]"},
+	}
+
+	for _, test := range tests {
+		got, outputs := p.step(test.state, test.in)
+		if got.String() != test.want.String() {
+			t.Errorf("%s: got state %s, want %s", test.name, got, test.want)
+		}
+		if fmt.Sprint(outputs) != test.outputs {
+			t.Errorf("%s: got outputs %v, want %s", test.name, outputs,
+				test.outputs)
+		}
+	}
+}
+
+func TestThatCheapPaxosRefusesMainAcceptorsThatAreNotAQuorum(t *testing.T) {
+	for _, c := range []Config{
+		{NProposers: 1, NAcceptors: 5, NAuxiliary: 3},
+		{NProposers: 1, NAcceptors: 5, NAuxiliary: 5},
+		{NProposers: 1, NAcceptors: 5, NAuxiliary: 1,
+			Quorums: Flexible{Phase1: 5, Phase2: 1}},
+		{NProposers: 2, NAcceptors: 5, NAuxiliary: 2, Fast: true},
+	} {
+		c.Seed, c.Output = 1, io.Discard
+		if err := c.Run(); err == nil {
+			t.Errorf("ran Cheap Paxos with %d of %d acceptors auxiliary and "+
+				"quorums %v", c.NAuxiliary, c.NAcceptors, c.Quorums)
+		}
+	}
+}
+
+func TestThatAuxiliaryAcceptorsSitIdleWithoutFailures(t *testing.T) {
+	var metrics [2]Metrics
+	for i, nAuxiliary := range []int{0, 2} {
+		var out strings.Builder
+		c := Config{NProposers: 3, NAcceptors: 5, NAuxiliary: nAuxiliary,
+			ProposerTimeout: 50 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond, Buffer: 2,
+			Retry: DistinguishedProposer{}, Seed: 1, Output: &out}
+		cluster, err := c.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cluster.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		metrics[i] = cluster.Metrics()
+
+		if nAuxiliary > 0 && (strings.Contains(out.String(), "acceptor 3") ||
+			strings.Contains(out.String(), "acceptor 4")) {
+
+			t.Errorf("auxiliary acceptors took part in a run without failures")
+		}
+	}
+
+	without, with := metrics[0], metrics[1]
+	if with.Messages >= without.Messages {
+		t.Errorf("got %d messages with auxiliary acceptors, want fewer than %d "+
+			"without", with.Messages, without.Messages)
+	}
+}
+
+func TestThatAuxiliaryAcceptorsStandInForAFailedMainAcceptor(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		var out strings.Builder
+		c := Config{NProposers: 3, NAcceptors: 5, NAuxiliary: 2,
+			ProposerTimeout: 50 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond, Buffer: 2, Drop: 0.1,
+			AcceptorFaults: []AcceptorFault{{Acceptor: 0, CrashAt: 0}},
+			Seed:           seed, Output: &out}
+		if err := c.Run(); err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+		if !strings.Contains(out.String(), "engages the auxiliary acceptors") {
+			t.Errorf("seed %d: no proposer engaged the auxiliary acceptors", seed)
+		}
+	}
+}
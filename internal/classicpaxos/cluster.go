@@ -0,0 +1,100 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import "context"
+
+// Cluster is a run of Classic Paxos in progress, started by Config.Start.
+type Cluster struct {
+	cancel  context.CancelFunc // stops the run
+	done    chan struct{}      // closed once every participant has stopped
+	err     error              // result of the run
+	metrics *metricsRecorder   // measures the run's proposers
+}
+
+// Start starts a run of Classic Paxos for the scenario given by the
+// configuration c. The run continues until the participants agree, or until
+// ctx is done or the cluster's Stop method is called, at which point every
+// participant stops.
+func (c *Config) Start(ctx context.Context) (*Cluster, error) {
+	r, err := c.newRun()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cluster := &Cluster{cancel: cancel, done: make(chan struct{}),
+		metrics: r.metrics}
+
+	go func() {
+		defer close(cluster.done)
+		defer cancel()
+
+		err := r.sched.run(ctx, func() {
+			// 1. create acceptors
+			if cluster.err = r.newAcceptors(); cluster.err != nil {
+				return
+			}
+			r.reconfigure()
+
+			// 2. create proposers and learners
+			valueChannel := r.sched.newChannel(r.NProposers + r.NLearners)
+			r.newLearners(valueChannel)
+			r.newProposers(ctx, valueChannel)
+
+			// 3. check whether proposers and learners agreed on same value
+			cluster.err = r.checkValues(valueChannel)
+		})
+		if err != nil {
+			cluster.err = err
+		}
+		if err := r.closeStorages(); err != nil && cluster.err == nil {
+			cluster.err = err
+		}
+
+		// 4. check whether the run violated an invariant along the way
+		if err := r.monitor.violation(); err != nil {
+			cluster.err = err
+		}
+
+		// 5. check whether the whole run was traced
+		if cluster.err == nil {
+			cluster.err = r.trace.err()
+		}
+	}()
+
+	return cluster, nil
+}
+
+// Stop stops every participant in the run. It does not wait for them to stop.
+func (cl *Cluster) Stop() {
+	cl.cancel()
+}
+
+// Wait waits until every participant in the run has stopped. It returns a
+// non-nil error if the participants disagreed, if the run violated a safety
+// invariant of Classic Paxos, or if the run stopped before they all agreed,
+// for example because a simulated run deadlocked. A violated invariant takes
+// precedence, since it says which step went wrong.
+func (cl *Cluster) Wait() error {
+	<-cl.done
+	return cl.err
+}
+
+// Metrics returns the metrics of the proposers that have stopped proposing so
+// far, which, once Wait returns, are all of them.
+func (cl *Cluster) Metrics() Metrics {
+	return cl.metrics.get()
+}
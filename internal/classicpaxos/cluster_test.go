@@ -0,0 +1,149 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkNoLeaks fails t if, within a second, the number of goroutines does not
+// return to at most before.
+func checkNoLeaks(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := runtime.NumGoroutine(); n > before {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		t.Errorf("%d goroutines leaked:\n%s", n-before, buf)
+	}
+}
+
+// openFiles returns the number of files that the process has open, or skips t
+// if the platform does not list them in /proc.
+func openFiles(t *testing.T) int {
+	t.Helper()
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open files: %v", err)
+	}
+	return len(fds)
+}
+
+func TestThatRunLeaksNoGoroutines(t *testing.T) {
+	ms := time.Millisecond
+	before := runtime.NumGoroutine()
+
+	// seed 0 runs in real time, and seed 1 is simulated
+	for _, seed := range []int64{0, 1} {
+		c := Config{NProposers: 3, NAcceptors: 5, NLearners: 2,
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Drop: 0.1, Seed: seed, Output: io.Discard,
+			AcceptorFaults: []AcceptorFault{
+				{Acceptor: 0, CrashAt: 10 * ms, RestartAt: 20 * ms},
+				{Acceptor: 1, CrashAt: time.Hour, RestartAt: 2 * time.Hour},
+			}}
+
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+
+		files := openFiles(t)
+		c.WALDir = t.TempDir()
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d and a WALDir, got %v", seed, err)
+		}
+		if n := openFiles(t); n > files {
+			t.Errorf("with seed %d and a WALDir, %d files leaked", seed, n-files)
+		}
+		c.WALDir = ""
+
+		err := c.RunLog(func(l *Log) {
+			l.Append("c0")
+			l.Read(0)
+		})
+		if err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+	}
+
+	checkNoLeaks(t, before)
+}
+
+func TestThatStopEndsARunThatCannotAgree(t *testing.T) {
+	ms := time.Millisecond
+	before := runtime.NumGoroutine()
+
+	for _, seed := range []int64{0, 1} {
+		// the proposers can never reach a majority of acceptors
+		c := Config{NProposers: 2, NAcceptors: 3, ProposerTimeout: 10 * ms,
+			ChannelTimeout: ms, Buffer: 1, Seed: seed, Output: io.Discard,
+			Partitions: []Partition{{Groups: [][]Node{
+				{ProposerNode(0), ProposerNode(1), AcceptorNode(0)}}}}}
+
+		cluster, err := c.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(50 * ms)
+		cluster.Stop()
+
+		if err := cluster.Wait(); err == nil {
+			t.Errorf("with seed %d, got no error, want one", seed)
+		}
+	}
+
+	checkNoLeaks(t, before)
+}
+
+func TestThatAConfigMayStartRunsThatOverlap(t *testing.T) {
+	ms := time.Millisecond
+
+	for _, seed := range []int64{0, 1} {
+		c := Config{NProposers: 3, NAcceptors: 5, NLearners: 2,
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Drop: 0.1, Seed: seed, Output: io.Discard}
+
+		var clusters []*Cluster
+		for i := 0; i < 3; i++ {
+			cluster, err := c.Start(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			clusters = append(clusters, cluster)
+		}
+
+		for i, cluster := range clusters {
+			if err := cluster.Wait(); err != nil {
+				t.Errorf("with seed %d, run %d got %v", seed, i, err)
+			}
+			if decided := cluster.Metrics().Decided; decided != c.NProposers {
+				t.Errorf("with seed %d, %d proposer/s of run %d decided, "+
+					"want %d", seed, decided, i, c.NProposers)
+			}
+		}
+	}
+}
@@ -15,14 +15,19 @@
 package classicpaxos
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"time"
 )
 
 // Config represents configuration for Classic Paxos, including number of
-// proposers, number of acceptors, proposer timeout, and lossyChannel
-// parameters.
+// proposers, acceptors and learners, proposer timeout, lossyChannel parameters,
+// the seed for pseudo-random choices, where acceptors store their state,
+// which participants crash, and how the network fails.
 type Config struct {
 	// number of proposers
 	NProposers int
@@ -30,9 +35,66 @@ type Config struct {
 	// number of acceptors
 	NAcceptors int
 
-	// how long proposer waits for acceptor responses before re-proposing
+	// how many of the acceptors, counting back from the last, are auxiliary
+	// acceptors of Cheap Paxos, which proposers engage only once a main
+	// acceptor fails to answer; if zero, every acceptor is a main acceptor
+	NAuxiliary int
+
+	// number of learners
+	NLearners int
+
+	// acceptors of the first configuration, if the run reconfigures; if nil,
+	// every acceptor
+	InitialAcceptors []int
+
+	// configurations of acceptors installed while the proposers decide, in
+	// the order of their times; the quorums of each are its majorities
+	Reconfigurations []Reconfiguration
+
+	// which acceptors form quorums in each phase (Majority if nil)
+	Quorums QuorumSystem
+
+	// candidate value of each proposer, which may be empty but not nil; if
+	// nil, proposer i proposes the value "v<i>"
+	CandidateValues []Value
+
+	// if not nil, called by a learner with each value it learns is chosen;
+	// unless Seed is non-zero, it must be safe for concurrent use
+	OnLearn func(learner int, value Value)
+
+	// how long proposer waits for acceptor responses before re-proposing, and
+	// learner waits for accepted messages before querying the acceptors
 	ProposerTimeout time.Duration
 
+	// how proposers avoid preempting one another (FixedRetry with no delay if
+	// nil)
+	Retry RetryStrategy
+
+	// which epochs the proposers use (ModuloEpochs if zero); with PairEpochs,
+	// a proposer's epochs do not depend on NProposers
+	Epochs EpochScheme
+
+	// if true, acceptors silently ignore prepare and propose messages for
+	// epochs less than their promised epochs, so the proposers wait for their
+	// timeouts; if false, acceptors reject them, and the proposers move on to a
+	// greater epoch at once
+	NoRejects bool
+
+	// if true, run Fast Paxos: proposer 0 coordinates, and opens a fast epoch
+	// in which the other proposers send their values directly to the acceptors
+	Fast bool
+
+	// size of a fast quorum in Fast Paxos; if zero, the least size for which
+	// every phase 1 quorum intersects every two fast quorums
+	FastQuorum int
+
+	// if true, run Byzantine Paxos: participants sign their messages, and
+	// quorums are large enough to tolerate malicious acceptors
+	Byzantine bool
+
+	// acceptors that deviate from the acceptor algorithm
+	MaliciousAcceptors []MaliciousAcceptor
+
 	// how long lossyChannel waits for buffer to fill before returning message
 	ChannelTimeout time.Duration
 
@@ -41,87 +103,434 @@ type Config struct {
 
 	// probability that lossyChannel drops a message
 	Drop float64
+
+	// seed for pseudo-random choices; if non-zero, the run is simulated against
+	// a virtual clock, and runs with the same configuration and seed are
+	// identical; if zero, the run happens in real time and is not repeatable
+	Seed int64
+
+	// directory in which each acceptor logs its state to a file, from which it
+	// recovers in later runs; if empty, acceptors keep their state in memory
+	WALDir string
+
+	// crashes and restarts of acceptors
+	AcceptorFaults []AcceptorFault
+
+	// crashes of proposers
+	ProposerFaults []ProposerFault
+
+	// partitions of the network
+	Partitions []Partition
+
+	// links on which the network loses messages in one direction only
+	LinkCuts []LinkCut
+
+	// where participants print their progress (os.Stdout if nil); unless Seed
+	// is non-zero, it must be safe for concurrent use
+	Output io.Writer
+
+	// if not nil, receives an event for each step of the run
+	Trace EventSink
 }
 
-// Run runs Classic Paxos for the scenario given by the configuration c.
+// run is a run of Classic Paxos: a copy of the configuration that started it,
+// and the state that exists only while it lasts. Since the state is not kept
+// in the Config, a Config may start any number of runs, even at once.
+type run struct {
+	Config
+
+	*Env // environment of the run, whose metrics also measure its proposers
+
+	keys *keyring // keys of the run's participants, if Byzantine
+
+	// configurations of the run's acceptors, if it reconfigures
+	reconfig *reconfiguration
+
+	monitor *monitor // audits the run
+
+	storages []Storage // storages that the run opened, closed once it ends
+}
+
+// Run runs Classic Paxos for the scenario given by the configuration c, and
+// returns once every participant has stopped.
 func (c *Config) Run() error {
-	// 1. create acceptors
-	acceptorChannels := c.newAcceptors()
+	cluster, err := c.Start(context.Background())
+	if err != nil {
+		return err
+	}
+	return cluster.Wait()
+}
 
-	// 2. create proposers
-	valueChannel := c.newProposers(acceptorChannels)
+// newRun checks c, and returns a run of c with its own scheduler, source of
+// pseudo-random choices and network.
+func (c *Config) newRun() (*run, error) {
+	if err := c.checkFaults(); err != nil {
+		return nil, err
+	}
+	if c.Quorums != nil {
+		if err := CheckQuorums(c.Quorums, c.NAcceptors); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.checkByzantine(); err != nil {
+		return nil, err
+	}
+	if err := c.checkCheap(); err != nil {
+		return nil, err
+	}
+	if err := c.checkReconfigurations(); err != nil {
+		return nil, err
+	}
+	if err := c.checkCandidateValues(); err != nil {
+		return nil, err
+	}
+	if c.Fast {
+		if _, err := fastQuorum(c.classicQuorums(), c.NAcceptors,
+			c.FastQuorum); err != nil {
 
-	// 3. check whether proposers agreed on same value
-	return c.checkValues(valueChannel)
+			return nil, err
+		}
+	}
+
+	env, err := NewEnv(EnvConfig{Seed: c.Seed,
+		ChannelTimeout: c.ChannelTimeout, Buffer: c.Buffer, Drop: c.Drop,
+		Partitions: c.Partitions, LinkCuts: c.LinkCuts, Exists: c.exists,
+		Output: c.output(), Trace: c.Trace})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &run{Config: *c, Env: env}
+	if c.reconfigures() {
+		r.reconfig = newReconfiguration(c.initialAcceptors())
+	}
+	r.monitor = newMonitor(r.sched, r.quorums(), c.output())
+	r.net.monitor = r.monitor // the network tells the monitor what is sent
+	if c.Byzantine {
+		r.keys = newKeyring(r.rand, c.nodes())
+	}
+
+	return r, nil
 }
 
-// newAcceptors creates c.NAcceptors acceptors. It returns the channels that are
-// inputs to the acceptors' lossy channels for use by the proposers.
-func (c *Config) newAcceptors() []chan<- message {
-	channels := make([]*lossyChannel, c.NAcceptors)
-	for i := 0; i < c.NAcceptors; i++ {
-		channels[i] = newLossyChannel(c.Buffer, c.ChannelTimeout, c.Drop)
+// output returns the writer to which participants print their progress.
+func (c *Config) output() io.Writer {
+	if c.Output == nil {
+		return os.Stdout
 	}
+	return c.Output
+}
 
-	acceptors := make([]*acceptor, c.NAcceptors)
-	for i := 0; i < c.NAcceptors; i++ {
-		acceptors[i] = newAcceptor(i, channels[i].output)
+// quorums returns the quorum system for the run, which with reconfigurations
+// holds the configuration of each epoch.
+func (r *run) quorums() QuorumSystem {
+	if r.reconfig != nil {
+		return r.reconfig
 	}
+	return r.Config.quorums()
+}
 
-	result := make([]chan<- message, c.NAcceptors)
-	for i := 0; i < c.NAcceptors; i++ {
-		result[i] = channels[i].input
+// quorums returns the quorum system for a run without reconfigurations, which
+// in Fast Paxos includes the fast quorums, and in Cheap Paxos the number of
+// auxiliary acceptors.
+func (c *Config) quorums() QuorumSystem {
+	if c.Fast {
+		fast, _ := fastQuorum(c.classicQuorums(), c.NAcceptors, c.FastQuorum)
+		return fastQuorums{QuorumSystem: c.classicQuorums(), Fast: fast}
+	}
+	if c.NAuxiliary > 0 {
+		return cheapQuorums{QuorumSystem: c.classicQuorums(),
+			Auxiliary: c.NAuxiliary}
 	}
+	return c.classicQuorums()
+}
 
-	return result
+// classicQuorums returns the quorum system for the classic epochs of a run.
+func (c *Config) classicQuorums() QuorumSystem {
+	if c.Byzantine {
+		return byzantineQuorums(c.NAcceptors)
+	}
+	if c.Quorums == nil {
+		return Majority{NAcceptors: c.NAcceptors}
+	}
+	return c.Quorums
 }
 
-// newProposers creates c.NProposers proposers. acceptorChannels are the
-// acceptors' input channels. It returns a channel of the values that each
-// proposers believes was agreed.
-func (c *Config) newProposers(acceptorChannels []chan<- message) <-chan string {
-	proposers := make([]*proposer, c.NProposers)
+// link connects the node n to the network through the lossy channel lc, and
+// returns the channel on which the participant at n receives its messages and
+// the Transport over which it sends them. In Byzantine Paxos, an authenticator
+// sits between the participant and the network.
+func (r *run) link(n Node, lc *lossyChannel) (channel, Transport) {
+	r.net.connect(n, lc.input)
+	if !r.Byzantine {
+		return lc.output, r.net
+	}
+	a := newAuthenticator(r.sched, n, r.keys, lc.output, r.net, r.trace,
+		r.output())
+	return a.output, a
+}
 
-	var group sync.WaitGroup
-	group.Add(c.NProposers)
+// newStorage returns the storage for the acceptor numbered id: a log file in
+// r.WALDir if r.WALDir is non-empty, and otherwise memory. The storage tells
+// r.monitor the states that the acceptor loads and saves. closeStorages closes
+// it once the run ends.
+func (r *run) newStorage(id int) (Storage, error) {
+	if r.WALDir == "" {
+		return monitoredStorage{NewMemoryStorage(), id, r.monitor}, nil
+	}
+	path := filepath.Join(r.WALDir, fmt.Sprintf("acceptor-%d.log", id))
+	storage, err := OpenFileStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	r.storages = append(r.storages, storage)
+	return monitoredStorage{storage, id, r.monitor}, nil
+}
 
-	channels := make([]*lossyChannel, c.NProposers)
-	for i := 0; i < c.NProposers; i++ {
-		channels[i] = newLossyChannel(c.Buffer, c.ChannelTimeout, c.Drop)
+// closeStorages closes the storages that the run opened, and returns the first
+// error in closing them.
+func (r *run) closeStorages() error {
+	var first error
+	for _, storage := range r.storages {
+		if err := storage.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	r.storages = nil
+	return first
+}
+
+// newAcceptors creates r.NAcceptors acceptors, and connects the inputs to their
+// lossy channels to the network.
+func (r *run) newAcceptors() error {
+	storages := make([]Storage, r.NAcceptors)
+	for i := 0; i < r.NAcceptors; i++ {
+		var err error
+		if storages[i], err = r.newStorage(i); err != nil {
+			return err
+		}
+	}
+
+	inputs := make([]channel, r.NAcceptors)
+	transports := make([]Transport, r.NAcceptors)
+	for i := 0; i < r.NAcceptors; i++ {
+		inputs[i], transports[i] = r.link(AcceptorNode(i),
+			r.newLossyChannel(AcceptorNode(i)))
+	}
+
+	malicious := make(map[int]Misbehavior)
+	for _, m := range r.MaliciousAcceptors {
+		malicious[m.Acceptor] = m.Behavior
+	}
+
+	for i := 0; i < r.NAcceptors; i++ {
+		if behavior, ok := malicious[i]; ok {
+			newMaliciousAcceptor(r.sched, i, behavior, r.NAcceptors, inputs[i],
+				transports[i], r.NLearners, r.trace, r.output())
+			continue
+		}
+		newAcceptor(r.sched, i, inputs[i], transports[i], r.NLearners,
+			r.NoRejects, storages[i], r.trace, r.output())
+	}
+
+	for _, f := range r.AcceptorFaults {
+		r.injectAcceptorFault(f, inputs[f.Acceptor])
+	}
+
+	return nil
+}
+
+// newProposers creates r.NProposers proposers, and connects the inputs to their
+// lossy channels to the network. Each proposer proposes its own value in its
+// own goroutine until ctx is done, and places the value that it believes was
+// agreed on valueChannel, or a proposerCrash if it crashes. With a
+// distinguished proposer, the proposers forward their values to the leader
+// instead, and the leader goes on answering them once it has decided. In Fast
+// Paxos, the proposers other than the coordinator are clients, and the
+// coordinator tells them the value it decides.
+func (r *run) newProposers(ctx context.Context, valueChannel channel) {
+	proposers := make([]*Proposer, r.NProposers)
+
+	inputs := make([]channel, r.NProposers)
+	transports := make([]Transport, r.NProposers)
+	for i := 0; i < r.NProposers; i++ {
+		inputs[i], transports[i] = r.link(ProposerNode(i),
+			r.newLossyChannel(ProposerNode(i)))
+	}
+
+	faults := r.proposerFaults()
+
+	for i := 0; i < r.NProposers; i++ {
+		rnd := rand.New(rand.NewSource(r.rand.Int63()))
+		proposers[i] = newProposer(r.sched, i, r.NProposers, r.Epochs,
+			inputs[i], transports[i], r.NAcceptors, r.quorums(),
+			r.ProposerTimeout, r.retry(), rnd, faults[i], r.trace, r.output())
+	}
+
+	for i := 0; i < r.NProposers; i++ {
+		p := proposers[i]
+		candidateValue := r.candidateValue(i)
+		r.sched.spawn(func() {
+			var value Value
+			var err error
+			d, distinguished := r.Retry.(DistinguishedProposer)
+			switch {
+			case r.Fast && p.id != fastCoordinator:
+				value, err = p.offer(ctx, d, candidateValue)
+			case distinguished && !r.Fast:
+				value, err = p.follow(ctx, d, candidateValue)
+			default:
+				value, err = p.Propose(ctx, candidateValue)
+			}
+
+			r.metrics.finished(p, err == nil, r.sched.now())
+			if p.crashed {
+				valueChannel.send(proposerCrash{})
+				return
+			}
+			if err != nil {
+				return
+			}
+			valueChannel.send(value)
+
+			if r.Fast && p.state.phase == proposerDecided {
+				p.announce(value)
+			}
+			if (distinguished || r.Fast) && p.state.phase == proposerDecided {
+				p.lead(value) // answer the proposers that follow
+			}
+		})
+	}
+}
+
+// retry returns the retry strategy for a run.
+func (c *Config) retry() RetryStrategy {
+	if c.Retry == nil {
+		return FixedRetry{}
+	}
+	return c.Retry
+}
+
+// newLearners creates r.NLearners learners, and connects the inputs to their
+// lossy channels to the network. Each learner places the values that it learns
+// are chosen on valueChannel, and passes them to r.OnLearn if it is not nil.
+func (r *run) newLearners(valueChannel channel) {
+	learners := make([]*Learner, r.NLearners)
+
+	inputs := make([]channel, r.NLearners)
+	transports := make([]Transport, r.NLearners)
+	for i := 0; i < r.NLearners; i++ {
+		inputs[i], transports[i] = r.link(LearnerNode(i),
+			r.newLossyChannel(LearnerNode(i)))
 	}
 
-	valueChannel := make(chan string, c.NProposers)
+	onLearn := func(learner int, value Value) {
+		if r.OnLearn != nil {
+			r.OnLearn(learner, value)
+		}
+		valueChannel.send(value)
+	}
 
+	for i := 0; i < r.NLearners; i++ {
+		learners[i] = newLearner(r.sched, i, inputs[i], r.quorums(),
+			transports[i], r.NAcceptors, r.ProposerTimeout, onLearn, r.trace,
+			r.output())
+	}
+}
+
+// checkFaults returns a non-nil error if c.AcceptorFaults or c.ProposerFaults
+// refers to a participant that does not exist.
+func (c *Config) checkFaults() error {
+	for _, f := range c.AcceptorFaults {
+		if f.Acceptor < 0 || f.Acceptor >= c.NAcceptors {
+			return fmt.Errorf("fault for nonexistent acceptor %d", f.Acceptor)
+		}
+	}
+	for _, f := range c.AcceptorFaults {
+		if f.RestartAt > 0 && f.RestartAt <= f.CrashAt {
+			return fmt.Errorf(
+				"acceptor %d restarts at %s, before it crashes at %s",
+				f.Acceptor, f.RestartAt, f.CrashAt)
+		}
+	}
+	for _, f := range c.ProposerFaults {
+		if f.Proposer < 0 || f.Proposer >= c.NProposers {
+			return fmt.Errorf("fault for nonexistent proposer %d", f.Proposer)
+		}
+	}
+	return nil
+}
+
+// nodes returns every participant in a run.
+func (c *Config) nodes() []Node {
+	var nodes []Node
 	for i := 0; i < c.NProposers; i++ {
-		proposers[i] = newProposer(i, c.NProposers, channels[i].output,
-			channels[i].input, acceptorChannels, c.ProposerTimeout,
-			valueChannel)
+		nodes = append(nodes, ProposerNode(i))
+	}
+	for i := 0; i < c.NAcceptors; i++ {
+		nodes = append(nodes, AcceptorNode(i))
+	}
+	for i := 0; i < c.NLearners; i++ {
+		nodes = append(nodes, LearnerNode(i))
 	}
+	return nodes
+}
 
-	return valueChannel
+// exists returns true if and only if the node n is a participant in a run.
+func (c *Config) exists(n Node) bool {
+	switch n.Role {
+	case RoleProposer:
+		return n.ID >= 0 && n.ID < c.NProposers
+	case RoleAcceptor:
+		return n.ID >= 0 && n.ID < c.NAcceptors
+	case RoleLearner:
+		return n.ID >= 0 && n.ID < c.NLearners
+	}
+	return false
 }
 
-// checkValues waits until n values appear on the values channel, checks whether
-// the values are identical, and returns a non-nil error if they differ.
-func (c *Config) checkValues(values <-chan string) error {
-	vals := make([]string, 0, c.NAcceptors)
+// checkValues waits until each proposer places its value or its crash, and
+// each learner its value, on the values channel, checks whether the values are
+// identical, and returns a non-nil error if they differ, or if the values
+// channel is closed first. Once every proposer has crashed, it stops waiting
+// for the learners, which may never learn a value.
+func (c *Config) checkValues(values channel) error {
+	n := c.NProposers + c.NLearners
+	vals := make([]Value, 0, n)
+	crashed := 0
 
 	problem := false
 
-	for i := 0; i < c.NProposers; i++ {
-		vals = append(vals, <-values)
+	for len(vals)+crashed < n && crashed < c.NProposers {
+		m := values.receive()
+		if m == nil {
+			return fmt.Errorf(
+				"the run stopped after %d of %d values were agreed",
+				len(vals)+crashed, n)
+		}
+		if _, ok := m.(proposerCrash); ok {
+			crashed++
+			continue
+		}
+		i := len(vals)
+		vals = append(vals, m.(Value))
 		if i > 0 && vals[i] != vals[0] {
-			fmt.Printf(
-				"uh oh! 2 proposers believe different values were agreed (%s versus %s)\n",
-				vals[0], vals[i])
+			fmt.Fprintf(c.output(), "uh oh! 2 participants believe "+
+				"different values were agreed (%s versus %s)\n", vals[0],
+				vals[i])
 			problem = true
 		}
 	}
 
+	if len(vals) == 0 {
+		fmt.Fprintf(c.output(),
+			"every proposer crashed, so no values were agreed\n")
+		return nil
+	}
 	if !problem {
-		fmt.Printf(
+		fmt.Fprintf(c.output(),
 			"yay! %d values were agreed, and they were all the same (%s)\n",
-			c.NProposers, vals[0])
+			len(vals), vals[0])
 		return nil
 	} else {
 		return fmt.Errorf(
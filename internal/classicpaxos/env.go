@@ -0,0 +1,224 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// EnvConfig represents configuration for the environment of a run, which the
+// runs of Classic Paxos and of the other protocols in this module share: the
+// seed for pseudo-random choices, the lossyChannel parameters, and how the
+// network fails.
+type EnvConfig struct {
+	// seed for pseudo-random choices; if non-zero, the run is simulated against
+	// a virtual clock, and runs with the same configuration and seed are
+	// identical; if zero, the run happens in real time and is not repeatable
+	Seed int64
+
+	// how long lossyChannel waits for buffer to fill before returning message
+	ChannelTimeout time.Duration
+
+	// how many messages lossyChannel buffers
+	Buffer int
+
+	// probability that lossyChannel drops a message
+	Drop float64
+
+	// partitions of the network
+	Partitions []Partition
+
+	// links on which the network loses messages in one direction only
+	LinkCuts []LinkCut
+
+	// returns true if and only if the node n takes part in the run; if nil,
+	// every node does
+	Exists func(n Node) bool
+
+	// where the network prints the messages that it loses (nowhere if nil)
+	Output io.Writer
+
+	// if not nil, receives an event for each step of the run
+	Trace EventSink
+}
+
+// Env is the environment of a run: its scheduler, its source of pseudo-random
+// choices, the tracer that emits its events, and the network between its
+// nodes. An Env is also the Transport over which the nodes send messages.
+type Env struct {
+	sched   scheduler        // scheduler for the run
+	rand    *rand.Rand       // source of the run's pseudo-random choices
+	trace   *tracer          // emits the run's events to Trace
+	net     *network         // network for the run
+	metrics *metricsRecorder // counts the run's messages
+
+	timeout time.Duration // how long lossy channels wait for buffer to fill
+	buffer  int           // how many messages lossy channels buffer
+	drop    float64       // probability that lossy channels drop a message
+}
+
+// NewEnv checks that the partitions and link cuts of c refer only to nodes
+// that exist, and returns the environment of a run with the configuration c.
+func NewEnv(c EnvConfig) (*Env, error) {
+	var nodes []Node
+	for _, p := range c.Partitions {
+		for _, group := range p.Groups {
+			nodes = append(nodes, group...)
+		}
+	}
+	for _, l := range c.LinkCuts {
+		nodes = append(nodes, l.From, l.To)
+	}
+	for _, n := range nodes {
+		if c.Exists != nil && !c.Exists(n) {
+			return nil, fmt.Errorf("network fault for nonexistent node %s", n)
+		}
+	}
+
+	e := &Env{timeout: c.ChannelTimeout, buffer: c.Buffer, drop: c.Drop}
+	seed := c.Seed
+	if seed == 0 {
+		e.sched = newRealScheduler()
+		seed = time.Now().UnixNano()
+	} else {
+		e.sched = newSimScheduler()
+	}
+	e.rand = rand.New(rand.NewSource(seed))
+	e.trace = newTracer(e.sched, c.Trace)
+	e.metrics = &metricsRecorder{}
+	e.net = newNetwork(e.sched, c.Partitions, c.LinkCuts, e.trace, nil,
+		e.metrics, orDiscard(c.Output))
+	return e, nil
+}
+
+// Run runs f, along with any goroutines that it spawns, until f returns, and
+// then stops the run and waits until every goroutine has returned. It returns
+// a non-nil error if a simulated run deadlocked.
+func (e *Env) Run(f func()) error {
+	return e.sched.run(context.Background(), f)
+}
+
+// Spawn starts f in a new goroutine of the run.
+func (e *Env) Spawn(f func()) {
+	e.sched.spawn(f)
+}
+
+// Now returns the time elapsed since the start of the run, which in a
+// simulated run is virtual.
+func (e *Env) Now() time.Duration {
+	return e.sched.now()
+}
+
+// Rand returns the run's source of pseudo-random choices. It is not safe for
+// concurrent use.
+func (e *Env) Rand() *rand.Rand {
+	return e.rand
+}
+
+// NewChannel returns a new channel of the run that buffers size messages.
+func (e *Env) NewChannel(size int) Channel {
+	return Channel{e.sched.newChannel(size)}
+}
+
+// newLossyChannel returns a new lossyChannel for messages to the node n, with
+// the run's parameters, and with its own source of pseudo-random choices
+// derived from the run's.
+func (e *Env) newLossyChannel(n Node) *lossyChannel {
+	rnd := rand.New(rand.NewSource(e.rand.Int63()))
+	return newLossyChannel(e.sched, rnd, e.buffer, e.timeout, e.drop, n,
+		e.trace)
+}
+
+// Listen connects the node n to the network through a new lossy channel, and
+// returns the channel on which n receives its messages.
+func (e *Env) Listen(n Node) Channel {
+	lc := e.newLossyChannel(n)
+	e.net.connect(n, lc.input)
+	return Channel{lc.output}
+}
+
+// Connect connects the node n to the network, so that messages sent to n are
+// placed on input as they are sent.
+func (e *Env) Connect(n Node, input Channel) {
+	e.net.connect(n, input.c)
+}
+
+// Send implements Transport.
+func (e *Env) Send(from, to Node, m Message) {
+	e.net.Send(from, to, m)
+}
+
+// ScheduleCrash starts a goroutine that places a Crash message on input at
+// time crashAt, and, if restartAt is positive, a Restart message at time
+// restartAt.
+func (e *Env) ScheduleCrash(input Channel, crashAt, restartAt time.Duration) {
+	scheduleCrash(e.sched, input.c, crashAt, restartAt, false)
+}
+
+// TraceArrival emits an event of the given kind for the message m, which
+// arrived at the node n, where it was received or lost as detail says.
+func (e *Env) TraceArrival(kind EventKind, n Node, m Message, detail string) {
+	e.trace.arrival(kind, n, m, detail)
+}
+
+// TraceStep emits an event of the given kind, which involves no message, at
+// the node n.
+func (e *Env) TraceStep(kind EventKind, n Node, value string) {
+	e.trace.step(kind, n, Epoch{}, value)
+}
+
+// TraceErr returns the error that the run's EventSink reports, if it has an
+// Err method, or nil.
+func (e *Env) TraceErr() error {
+	return e.trace.err()
+}
+
+// Messages returns the number of messages sent over the network so far,
+// whether or not they arrived.
+func (e *Env) Messages() int {
+	return e.metrics.get().Messages
+}
+
+// Channel is a FIFO queue of messages belonging to the scheduler of a run.
+type Channel struct {
+	c channel
+}
+
+// Send places m on the channel.
+func (c Channel) Send(m Message) {
+	c.c.send(m)
+}
+
+// Receive waits for a message and returns it, or returns nil if the channel
+// is closed.
+func (c Channel) Receive() Message {
+	return c.c.receive()
+}
+
+// ReceiveTimeout waits up to timeout for a message. It returns the message and
+// true, or nil and false if the timeout expires first, or nil and true if the
+// channel is closed.
+func (c Channel) ReceiveTimeout(timeout time.Duration) (Message, bool) {
+	return c.c.receiveTimeout(timeout)
+}
+
+// Close closes the channel.
+func (c Channel) Close() {
+	c.c.close()
+}
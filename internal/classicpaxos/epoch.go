@@ -15,6 +15,7 @@
 package classicpaxos
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math/big"
 )
@@ -31,9 +32,37 @@ import (
 // A typical Paxos implementation would use a 32- or 64-bit integer instead of a
 // big.Int. To sidestep the small complexities of overflow, we use a big.Int
 // instead.
+//
+// Since these epochs depend on n, adding a proposer would change every
+// proposer's epochs, and two proposers could then use the same one. With
+// PairEpochs, proposer p instead uses the pairs (0, p), (1, p), (2, p), ...,
+// which are ordered lexicographically by round and then by proposer, so that
+// proposers may join or leave without renumbering the others' epochs.
+//
+// Epochs are values. Copies of an epoch, such as those in messages still in
+// flight, share its big.Int, so nothing ever changes the big.Int of an epoch;
+// an epoch with another integer gets a big.Int of its own.
 type Epoch struct {
-	i          *big.Int
-	nProposers int // total number of proposers
+	i          *big.Int // the integer, or the round of a pair epoch
+	nProposers int      // total number of proposers, or zero in a pair epoch
+	proposer   int      // proposer of a pair epoch
+}
+
+// EpochScheme determines which epochs each proposer uses.
+type EpochScheme int
+
+const (
+	ModuloEpochs EpochScheme = iota // proposer p of n uses p, n + p, 2n + p, ...
+	PairEpochs                      // proposer p uses (0, p), (1, p), (2, p), ...
+)
+
+// epoch returns the epoch of the given round of the proposer numbered id, of
+// nProposers proposers, in the scheme s.
+func (s EpochScheme) epoch(round, id, nProposers int) Epoch {
+	if s == PairEpochs {
+		return newPairEpoch(round, id)
+	}
+	return newEpoch(round*nProposers+id, nProposers)
 }
 
 // newEpoch returns the initial epoch for the proposer numbered proposerID.
@@ -45,10 +74,20 @@ func newEpoch(proposerID, nProposers int) Epoch {
 	}
 }
 
-// Next returns the next epoch, equal to e + e.proposers.
+// newPairEpoch returns the pair epoch (round, proposerID).
+func newPairEpoch(round, proposerID int) Epoch {
+	return Epoch{i: big.NewInt(int64(round)), proposer: proposerID}
+}
+
+// Next returns the next epoch of the same proposer, equal to e + e.proposers,
+// or (round + 1, proposer) if e is a pair epoch. It leaves e unchanged.
 func (e Epoch) Next() Epoch {
+	if e.isPair() {
+		return Epoch{i: new(big.Int).Add(e.i, big.NewInt(1)),
+			proposer: e.proposer}
+	}
 	return Epoch{
-		i:          e.i.Add(e.i, big.NewInt(int64(e.nProposers))),
+		i:          new(big.Int).Add(e.i, big.NewInt(int64(e.nProposers))),
 		nProposers: e.nProposers,
 	}
 }
@@ -58,19 +97,155 @@ func (e Epoch) Nil() bool {
 	return e == Epoch{}
 }
 
+// isPair returns true if and only if e is a pair epoch.
+func (e Epoch) isPair() bool {
+	return e.i != nil && e.nProposers == 0
+}
+
+// pair returns the round and the proposer of e, whose round the caller must
+// not change. For an epoch i of n proposers they are the quotient and
+// remainder of i divided by n, so that the epochs of both schemes are ordered
+// alike.
+func (e Epoch) pair() (*big.Int, int) {
+	if e.isPair() {
+		return e.i, e.proposer
+	}
+	round, proposer := new(big.Int).DivMod(e.i, big.NewInt(int64(e.nProposers)),
+		new(big.Int))
+	return round, int(proposer.Int64())
+}
+
 // Cmp compares e and f and returns:
 //
 //   -1 if e <  f
 //    0 if e == f
 //   +1 if e >  f
 func (e Epoch) Cmp(f Epoch) int {
-	return e.i.Cmp(f.i)
+	if !e.isPair() && !f.isPair() {
+		return e.i.Cmp(f.i)
+	}
+
+	eRound, eProposer := e.pair()
+	fRound, fProposer := f.pair()
+	if c := eRound.Cmp(fRound); c != 0 {
+		return c
+	}
+	switch {
+	case eProposer < fProposer:
+		return -1
+	case eProposer > fProposer:
+		return 1
+	}
+	return 0
 }
 
-// String returns the string version of an epoch.
+// String returns the string version of an epoch: "nil", the integer, or
+// the round and the proposer of a pair epoch separated by a dot.
 func (e Epoch) String() string {
 	if e.Nil() {
 		return "nil"
 	}
+	if e.isPair() {
+		return fmt.Sprintf("%s.%d", e.i, e.proposer)
+	}
 	return fmt.Sprintf("%s", e.i)
 }
+
+// Tags of the binary form of an epoch, which identify its kind.
+const (
+	epochTagNil    byte = iota // the nil epoch
+	epochTagModulo             // an epoch of ModuloEpochs
+	epochTagPair               // an epoch of PairEpochs
+)
+
+// MarshalBinary returns the binary form of e: a tag byte for its kind,
+// followed for a non-nil epoch by the number of proposers of a modulo epoch
+// or the proposer of a pair epoch as a uvarint, and then by the gob encoding
+// of its integer or round.
+func (e Epoch) MarshalBinary() ([]byte, error) {
+	if e.Nil() {
+		return []byte{epochTagNil}, nil
+	}
+
+	tag, n := epochTagModulo, e.nProposers
+	if e.isPair() {
+		tag, n = epochTagPair, e.proposer
+	}
+
+	i, err := e.i.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	data := append([]byte{tag}, buf[:binary.PutUvarint(buf, uint64(n))]...)
+	return append(data, i...), nil
+}
+
+// UnmarshalBinary sets e to the epoch whose binary form is data. It does not
+// change the integer of the epoch that e held before.
+func (e *Epoch) UnmarshalBinary(data []byte) error {
+	malformed := fmt.Errorf("malformed binary epoch %x", data)
+
+	if len(data) == 0 {
+		return malformed
+	}
+	tag := data[0]
+	if tag == epochTagNil {
+		if len(data) != 1 {
+			return malformed
+		}
+		*e = Epoch{}
+		return nil
+	}
+	if tag != epochTagModulo && tag != epochTagPair {
+		return malformed
+	}
+
+	n, size := binary.Uvarint(data[1:])
+	if size <= 0 || n > uint64(maxInt) {
+		return malformed
+	}
+
+	rest := data[1+size:]
+	if len(rest) == 0 {
+		return malformed
+	}
+	i := new(big.Int)
+	if err := i.GobDecode(rest); err != nil {
+		return malformed
+	}
+
+	if i.Sign() < 0 {
+		return malformed // epochs of both schemes start at 0
+	}
+
+	if tag == epochTagPair {
+		*e = Epoch{i: i, proposer: int(n)}
+		return nil
+	}
+	if n == 0 {
+		return malformed
+	}
+	*e = Epoch{i: i, nProposers: int(n)}
+	return nil
+}
+
+// maxInt is the greatest int.
+const maxInt = int(^uint(0) >> 1)
+
+// MarshalText returns the text form of e, which is its form in a log record.
+func (e Epoch) MarshalText() ([]byte, error) {
+	return []byte(formatEpoch(e)), nil
+}
+
+// UnmarshalText sets e to the epoch whose text form is text. It does not
+// change the integer of the epoch that e held before.
+func (e *Epoch) UnmarshalText(text []byte) error {
+	epoch, err := parseEpoch(string(text))
+	if err != nil {
+		return err
+	}
+	*e = epoch
+	return nil
+}
@@ -0,0 +1,347 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"io"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// checkTotalOrder fails t unless Cmp orders epochs as a strict total order in
+// which each epoch comes before the next, and unless distinct epochs have
+// distinct string forms.
+func checkTotalOrder(t *testing.T, epochs []Epoch) {
+	t.Helper()
+
+	sign := func(i, j int) int {
+		switch {
+		case i < j:
+			return -1
+		case i > j:
+			return 1
+		}
+		return 0
+	}
+
+	strs := make(map[string]bool)
+	for i, e := range epochs {
+		if strs[e.String()] {
+			t.Errorf("epoch %s is used twice", e)
+		}
+		strs[e.String()] = true
+
+		for j, f := range epochs {
+			if got := e.Cmp(f); got != sign(i, j) {
+				t.Errorf("%s.Cmp(%s) = %d, want %d", e, f, got, sign(i, j))
+			}
+		}
+	}
+}
+
+func TestThatModuloEpochsAreTotallyOrderedAndUnique(t *testing.T) {
+	p := proposerCore{nProposers: 3}
+
+	// each proposer in turn, as when they preempt one another
+	var epochs []Epoch
+	var e Epoch
+	for i := 0; i < 20; i++ {
+		p.id = i % 3
+		e = p.nextEpoch(e)
+		epochs = append(epochs, e)
+	}
+	checkTotalOrder(t, epochs)
+
+	if epochs[7].String() != "7" {
+		t.Errorf("got epoch %s, want 7", epochs[7])
+	}
+}
+
+func TestThatPairEpochsAreTotallyOrderedAndUnique(t *testing.T) {
+	// proposers 0 and 2 start, proposer 9 joins, proposer 2 leaves and
+	// proposer 1 joins, with no proposer knowing how many others there are
+	ids := []int{0, 2, 0, 2, 9, 0, 9, 1, 9, 1, 0, 1, 1, 9, 0}
+	var epochs []Epoch
+	var e Epoch
+	for _, id := range ids {
+		p := proposerCore{id: id, epochs: PairEpochs}
+		e = p.nextEpoch(e)
+		epochs = append(epochs, e)
+	}
+	checkTotalOrder(t, epochs)
+
+	want := "0.0 0.2 1.0 1.2 1.9 2.0 2.9 3.1 3.9 4.1 5.0 5.1 6.1 6.9 7.0"
+	var got string
+	for i, e := range epochs {
+		if i > 0 {
+			got += " "
+		}
+		got += e.String()
+	}
+	if got != want {
+		t.Errorf("got epochs %s, want %s", got, want)
+	}
+}
+
+func TestThatTheNextEpochIsTheLeastGreaterEpochOfTheProposer(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		id := r.Intn(10)
+		p := proposerCore{id: id, nProposers: 10, epochs: PairEpochs}
+		e := newPairEpoch(r.Intn(5), r.Intn(10))
+
+		next := p.nextEpoch(e)
+		if next.Cmp(e) <= 0 || next.proposer != id {
+			t.Fatalf("next epoch of proposer %d after %s is %s", id, e, next)
+		}
+		// no epoch of the proposer lies between them
+		if prev := newPairEpoch(int(next.i.Int64())-1, id); prev.Cmp(e) > 0 {
+			t.Fatalf("next epoch of proposer %d after %s is %s, not %s", id, e,
+				next, prev)
+		}
+
+		// the schemes agree on the order of a proposer's epochs
+		p.epochs = ModuloEpochs
+		modulo := p.nextEpoch(newEpoch(int(e.i.Int64())*10+e.proposer, 10))
+		if modulo.Cmp(next) != 0 || next.Cmp(modulo) != 0 {
+			t.Fatalf("next epoch of proposer %d after %s is %s, but %s in "+
+				"the modulo scheme", id, e, next, modulo)
+		}
+	}
+}
+
+func TestThatEpochsSurviveTheirRecordForm(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	for _, e := range []Epoch{{}, newEpoch(5, 3), newPairEpoch(4, 7),
+		{i: huge, proposer: 2}} {
+
+		got, err := parseEpoch(formatEpoch(e))
+		if err != nil || got.String() != e.String() ||
+			!e.Nil() && got.Cmp(e) != 0 {
+
+			t.Errorf("epoch %s came back as %s (%v)", e, got, err)
+		}
+	}
+
+	for _, s := range []string{"1.", ".1", "1.2.3", "-1.2", "1.-2", "3/0",
+		"-3/5"} {
+
+		if e, err := parseEpoch(s); err == nil {
+			t.Errorf("parsed %q as %s, want an error", s, e)
+		}
+	}
+}
+
+func TestThatEpochsSurviveTheirBinaryAndTextForms(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	for _, e := range []Epoch{{}, newEpoch(0, 1), newEpoch(5, 3),
+		newPairEpoch(0, 0), newPairEpoch(4, 7), {i: huge, nProposers: 4},
+		{i: huge, proposer: 2}} {
+
+		data, err := e.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := newEpoch(1, 2)
+		if err := got.UnmarshalBinary(data); err != nil ||
+			got.String() != e.String() || !e.Nil() && got.Cmp(e) != 0 {
+
+			t.Errorf("epoch %s came back from binary as %s (%v)", e, got, err)
+		}
+
+		text, err := e.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = newEpoch(1, 2)
+		if err := got.UnmarshalText(text); err != nil ||
+			got.String() != e.String() || !e.Nil() && got.Cmp(e) != 0 {
+
+			t.Errorf("epoch %s came back from text %q as %s (%v)", e, text,
+				got, err)
+		}
+	}
+
+	negativePair, _ := Epoch{i: big.NewInt(-1), proposer: 1}.MarshalBinary()
+	negativeModulo, _ := Epoch{i: big.NewInt(-3), nProposers: 5}.MarshalBinary()
+	for _, data := range [][]byte{nil, {0, 0}, {3, 1, 2}, {1, 0, 2},
+		{1, 0x80}, {1, 3}, {2, 1, 9}, negativePair, negativeModulo} {
+
+		e := newEpoch(1, 2)
+		if err := e.UnmarshalBinary(data); err == nil {
+			t.Errorf("unmarshaled %x as %s, want an error", data, e)
+		}
+	}
+}
+
+// messageEpochs returns the epochs that m carries.
+func messageEpochs(m Message) []Epoch {
+	switch msg := m.(type) {
+	case prepare:
+		return []Epoch{msg.epoch}
+	case promise:
+		return []Epoch{msg.epoch, msg.acceptedEpoch}
+	case propose:
+		return []Epoch{msg.epoch}
+	case accept:
+		return []Epoch{msg.epoch}
+	case accepted:
+		return []Epoch{msg.epoch}
+	case reject:
+		return []Epoch{msg.epoch, msg.promisedEpoch}
+	}
+	return nil
+}
+
+func TestThatAMessagesEpochNeverChangesAfterItIsSent(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for run := 0; run < 50; run++ {
+		const nProposers, nAcceptors = 3, 3
+		epochs := ModuloEpochs
+		if run%2 == 1 {
+			epochs = PairEpochs
+		}
+
+		proposers := make([]proposerCore, nProposers)
+		pStates := make([]proposerState, nProposers)
+		for i := range proposers {
+			proposers[i] = proposerCore{id: i, nProposers: nProposers,
+				nAcceptors: nAcceptors, quorums: Majority{}, epochs: epochs}
+		}
+		acceptors := make([]acceptorCore, nAcceptors)
+		aStates := make([]AcceptorState, nAcceptors)
+		for i := range acceptors {
+			acceptors[i] = acceptorCore{id: i}
+		}
+
+		// every message sent, its string form when sent, and the messages
+		// not yet delivered
+		var sent []output
+		var forms []string
+		var pending []output
+		send := func(outputs []output) {
+			for _, o := range outputs {
+				sent = append(sent, o)
+				forms = append(forms, o.String())
+				pending = append(pending, o)
+			}
+		}
+
+		for i := range proposers {
+			var outputs []output
+			pStates[i], outputs = proposers[i].step(pStates[i],
+				proposalStart{value: v1})
+			send(outputs)
+		}
+
+		for step := 0; step < 200; step++ {
+			// deliver a pending message, or time a proposer out
+			if len(pending) == 0 || r.Intn(10) == 0 {
+				i := r.Intn(nProposers)
+				var outputs []output
+				pStates[i], outputs = proposers[i].step(pStates[i],
+					proposalTimeout{})
+				send(outputs)
+			} else {
+				k := r.Intn(len(pending))
+				o := pending[k]
+				pending = append(pending[:k], pending[k+1:]...)
+
+				var outputs []output
+				switch o.to.Role {
+				case RoleProposer:
+					pStates[o.to.ID], outputs = proposers[o.to.ID].step(
+						pStates[o.to.ID], o.msg)
+				case RoleAcceptor:
+					aStates[o.to.ID], outputs = acceptors[o.to.ID].step(
+						aStates[o.to.ID], o.msg)
+				}
+				send(outputs)
+			}
+
+			// whatever else is done with the epochs of messages sent
+			for _, o := range sent {
+				for _, e := range messageEpochs(o.msg) {
+					if e.Nil() {
+						continue
+					}
+					e.Next()
+					proposers[r.Intn(nProposers)].nextEpoch(e)
+					previousEpoch(e)
+					e.pair()
+
+					f := e
+					data, _ := e.MarshalBinary()
+					if err := f.UnmarshalBinary(data); err != nil {
+						t.Fatal(err)
+					}
+					text, _ := e.MarshalText()
+					if err := f.UnmarshalText(text); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			for k, o := range sent {
+				if o.String() != forms[k] {
+					t.Fatalf("in run %d, message %s became %s", run, forms[k],
+						o)
+				}
+			}
+		}
+	}
+}
+
+func TestThatEpochsAreUnchangedByNext(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		e := newEpoch(r.Intn(100), 1+r.Intn(10))
+		if r.Intn(2) == 0 {
+			e = newPairEpoch(r.Intn(100), r.Intn(10))
+		}
+		copied, form := e, e.String()
+
+		next := e.Next()
+		if e.String() != form || copied.String() != form {
+			t.Fatalf("Next changed epoch %s to %s", form, e)
+		}
+		if next.Cmp(e) <= 0 {
+			t.Fatalf("next epoch after %s is %s", e, next)
+		}
+		next.Next()
+		if next.Cmp(e.Next()) != 0 {
+			t.Fatalf("Next of the next epoch after %s changed it", e)
+		}
+	}
+}
+
+func TestThatRunsWithPairEpochsAgree(t *testing.T) {
+	ms := time.Millisecond
+	for seed := int64(1); seed <= 20; seed++ {
+		c := Config{NProposers: 5, NAcceptors: 3, Epochs: PairEpochs,
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Drop: 0.1, Seed: seed, Output: io.Discard}
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+
+		c.Fast, c.Drop = true, 0
+		if err := c.Run(); err != nil {
+			t.Errorf("in Fast Paxos with seed %d, got %v", seed, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+)
+
+// Fast Paxos [1] saves a message delay over Classic Paxos when proposers
+// rarely collide. Proposer 0 is the coordinator: rather than run phase 1 for
+// its first epoch, which is the one fast epoch, it sends an any message,
+// which lets each acceptor accept the first value that a client sends it
+// directly. The other proposers are the clients. A value is chosen in the fast
+// epoch once a fast quorum of acceptors has accepted it, so a client whose
+// value is chosen learns so one round trip after sending it. If clients'
+// values collide, so that no value can gain a fast quorum, the coordinator
+// recovers with a round of Classic Paxos in a later, classic epoch.
+//
+// For recovery to be safe, every phase 1 quorum must intersect every two fast
+// quorums: then among the promises of a phase 1 quorum, a value that may have
+// been chosen in the fast epoch has more votes than any other.
+//
+// [1] Leslie Lamport. 2006. Fast Paxos. Distributed Computing 19, 2, 79-103.
+
+// anyValue is the message by which the coordinator opens the fast epoch, in
+// which each acceptor may accept any value that a client sends it.
+type anyValue struct {
+	epoch      Epoch
+	proposerID int
+}
+
+// String returns the string form of an any message.
+func (a anyValue) String() string {
+	return fmt.Sprintf("any(%s) from proposer %d", a.epoch, a.proposerID)
+}
+
+// clientValue is the message by which a client sends its value directly to
+// the acceptors in Fast Paxos.
+type clientValue struct {
+	value      Value
+	proposerID int
+}
+
+// String returns the string form of a client value message.
+func (c clientValue) String() string {
+	return fmt.Sprintf("value(%s) from proposer %d", c.value, c.proposerID)
+}
+
+// fastCoordinator is the proposer that coordinates Fast Paxos.
+const fastCoordinator = 0
+
+// isFastEpoch returns true if and only if e is the fast epoch of Fast Paxos,
+// which is the first epoch of the coordinator.
+func isFastEpoch(e Epoch) bool {
+	if e.Nil() {
+		return false
+	}
+	round, proposer := e.pair()
+	return round.Sign() == 0 && proposer == fastCoordinator
+}
+
+// fastQuorums is the quorum system of a run of Fast Paxos. Its phase 1 and
+// phase 2 quorums are those of the classic epochs, and a value is chosen in
+// the fast epoch once Fast acceptors have accepted it.
+type fastQuorums struct {
+	QuorumSystem     // quorums in the classic epochs
+	Fast         int // size of a fast quorum
+}
+
+// String returns the string form of a Fast Paxos quorum system.
+func (q fastQuorums) String() string {
+	return fmt.Sprintf("%v with fast quorums of %d", q.QuorumSystem, q.Fast)
+}
+
+// isChosen returns true if and only if a value that the acceptors whose
+// identifiers are the keys of acceptors have accepted in epoch e is chosen,
+// given the quorum system q.
+func isChosen(q QuorumSystem, e Epoch, acceptors map[int]bool) bool {
+	if r, ok := q.(*reconfiguration); ok {
+		return r.isChosen(e, acceptors)
+	}
+	if f, ok := q.(fastQuorums); ok && isFastEpoch(e) {
+		return len(acceptors) >= f.Fast
+	}
+	return q.IsPhase2Quorum(acceptors)
+}
+
+// minPhase1Quorum returns the size of the smallest phase 1 quorum of q among
+// nAcceptors acceptors, which must be at most maxCheckedAcceptors.
+func minPhase1Quorum(q QuorumSystem, nAcceptors int) int {
+	min := nAcceptors
+	for set := 0; set < 1<<nAcceptors; set++ {
+		acceptors := acceptorSet(set, nAcceptors)
+		if len(acceptors) < min && q.IsPhase1Quorum(acceptors) {
+			min = len(acceptors)
+		}
+	}
+	return min
+}
+
+// fastQuorum returns the size of a fast quorum among nAcceptors acceptors
+// whose phase 1 quorums are those of q: fast if it is positive, and otherwise
+// the least size for which every phase 1 quorum intersects every two fast
+// quorums. It returns an error if q's phase 1 quorums do not intersect every
+// two fast quorums of that size.
+func fastQuorum(q QuorumSystem, nAcceptors, fast int) (int, error) {
+	if nAcceptors > maxCheckedAcceptors {
+		return 0, fmt.Errorf("cannot check quorums of more than %d acceptors",
+			maxCheckedAcceptors)
+	}
+
+	// A phase 1 quorum of m acceptors avoids the intersection of two fast
+	// quorums if and only if the n-fast acceptors outside each cover it.
+	m := minPhase1Quorum(q, nAcceptors)
+	if fast <= 0 {
+		fast = nAcceptors - (m+1)/2 + 1
+	}
+	if fast > nAcceptors || m <= 2*(nAcceptors-fast) {
+		return 0, fmt.Errorf("fast quorums of %d of %d acceptors do not "+
+			"intersect every phase 1 quorum of %v and every other fast quorum",
+			fast, nAcceptors, q)
+	}
+	return fast, nil
+}
+
+// offer runs a client of Fast Paxos, and returns the value decided. It sends
+// candidateValue directly to every acceptor, and counts the acceptors that
+// accept each value in the fast epoch, until a fast quorum has accepted one.
+// After each timeout it sends candidateValue again, and forwards it to the
+// coordinator, which answers with an outcome message once it has decided. If
+// the coordinator does not answer, as strategy d says, the client proposes
+// candidateValue itself in classic epochs. Like Propose, offer returns
+// ctx.Err() if ctx is done first.
+func (p *Proposer) offer(ctx context.Context, d DistinguishedProposer,
+	candidateValue Value) (Value, error) {
+
+	votes := make(map[Value]map[int]bool) // keys are values, then acceptors
+	fast := p.quorums.(fastQuorums).Fast
+
+	for i := 0; i < d.suspectAfter(); i++ {
+		for a := 0; a < p.nAcceptors; a++ {
+			p.transport.Send(ProposerNode(p.id), AcceptorNode(a),
+				clientValue{value: candidateValue, proposerID: p.id})
+		}
+		if i > 0 {
+			p.transport.Send(ProposerNode(p.id), ProposerNode(fastCoordinator),
+				forward{value: candidateValue, proposerID: p.id})
+		}
+
+		deadline := p.sched.now() + p.timeout
+		for {
+			msg, ok := p.input.receiveTimeout(deadline - p.sched.now())
+			if !ok {
+				p.trace.step(EventTimeout, ProposerNode(p.id), Epoch{}, "")
+				if err := ctx.Err(); err != nil {
+					return Value{}, err
+				}
+				break
+			}
+			if msg == nil {
+				return Value{}, fmt.Errorf("proposer %d stopped", p.id)
+			}
+			if err := ctx.Err(); err != nil {
+				return Value{}, err
+			}
+
+			fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, msg)
+			p.trace.arrival(EventReceived, ProposerNode(p.id), msg, "")
+
+			var epoch Epoch
+			var value Value
+			switch msg := msg.(type) {
+			case accepted:
+				if !isFastEpoch(msg.epoch) {
+					continue
+				}
+				votes[msg.value] = with(votes[msg.value], msg.acceptorID)
+				if len(votes[msg.value]) < fast {
+					continue
+				}
+				epoch, value = msg.epoch, msg.value
+			case outcome:
+				value = msg.value
+			default:
+				continue
+			}
+
+			fmt.Fprintf(p.out, "proposer %d believes value %s is decided\n",
+				p.id, value)
+			p.trace.step(EventDecided, ProposerNode(p.id), epoch, value.String())
+			return value, nil
+		}
+	}
+
+	fmt.Fprintf(p.out, "proposer %d suspects coordinator %d\n", p.id,
+		fastCoordinator)
+	return p.Propose(ctx, candidateValue)
+}
+
+// announce tells every other proposer, with an outcome message, that value
+// is decided.
+func (p *Proposer) announce(value Value) {
+	for id := 0; id < p.nProposers; id++ {
+		if id != p.id {
+			p.transport.Send(ProposerNode(p.id), ProposerNode(id),
+				outcome{value: value, proposerID: p.id})
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThatAcceptorStepVotesOnceInTheFastEpoch(t *testing.T) {
+	fast, e3 := newEpoch(0, 3), newEpoch(3, 3)
+	a := acceptorCore{id: 4, nLearners: 1}
+
+	tests := []struct {
+		name    string
+		state   AcceptorState
+		in      Message
+		want    AcceptorState
+		outputs string
+	}{
+		{"any with no promise promises the fast epoch",
+			AcceptorState{}, anyValue{epoch: fast},
+			AcceptorState{PromisedEpoch: fast},
+			"[]"},
+		{"any in an earlier epoch is rejected",
+			AcceptorState{PromisedEpoch: e3}, anyValue{epoch: fast},
+			AcceptorState{PromisedEpoch: e3},
+			"[reject(0, 3) from acceptor 4 to p0]"},
+		{"first client value after any is accepted and announced",
+			AcceptorState{PromisedEpoch: fast},
+			clientValue{value: v1, proposerID: 1},
+			AcceptorState{PromisedEpoch: fast, AcceptedEpoch: fast,
+				AcceptedValue: v1},
+			"[accepted(0, v1) from acceptor 4 to p0 " +
+				"accepted(0, v1) from acceptor 4 to p1 " +
+				"accepted(0, v1) from acceptor 4 to l0]"},
+		{"second client value is ignored",
+			AcceptorState{PromisedEpoch: fast, AcceptedEpoch: fast,
+				AcceptedValue: v1},
+			clientValue{value: v2, proposerID: 2},
+			AcceptorState{PromisedEpoch: fast, AcceptedEpoch: fast,
+				AcceptedValue: v1},
+			"[]"},
+		{"client value before any is ignored",
+			AcceptorState{}, clientValue{value: v1, proposerID: 1},
+			AcceptorState{},
+			"[]"},
+		{"client value after a classic promise is ignored",
+			AcceptorState{PromisedEpoch: e3},
+			clientValue{value: v1, proposerID: 1},
+			AcceptorState{PromisedEpoch: e3},
+			"[]"},
+	}
+
+	for _, test := range tests {
+		got, outputs := a.step(test.state, test.in)
+		if formatRecord(got) != formatRecord(test.want) {
+			t.Errorf("%s: got state %q, want %q", test.name, formatRecord(got),
+				formatRecord(test.want))
+		}
+		if fmt.Sprint(outputs) != test.outputs {
+			t.Errorf("%s: got outputs %v, want %s", test.name, outputs,
+				test.outputs)
+		}
+	}
+}
+
+func TestThatCoordinatorStepRecoversFromCollisions(t *testing.T) {
+	fast, e3 := newEpoch(0, 3), newEpoch(3, 3)
+
+	// a fast quorum is any 4 of the 5 acceptors
+	p := proposerCore{id: 0, nProposers: 3, nAcceptors: 5,
+		quorums: fastQuorums{QuorumSystem: Majority{NAcceptors: 5}, Fast: 4}}
+
+	set := func(acceptors ...int) map[int]bool {
+		s := map[int]bool{}
+		for _, a := range acceptors {
+			s[a] = true
+		}
+		return s
+	}
+
+	// states of the coordinator in the fast epoch, and in its recovery
+	voting := func(votes map[Value]map[int]bool) proposerState {
+		return proposerState{round: 1, phase: proposerFast, epoch: fast,
+			candidate: v0, promised: set(), accepted: set(), votes: votes}
+	}
+	decided := voting(map[Value]map[int]bool{v1: set(0, 1, 2, 3)})
+	decided.phase, decided.value = proposerDecided, v1
+	recovery := proposerState{round: 2, phase: proposerPhase1, epoch: e3,
+		candidate: v0, promised: set(), accepted: set()}
+	prepare3 := "[prepare(3) from proposer 0 to a0 " +
+		"prepare(3) from proposer 0 to a1 prepare(3) from proposer 0 to a2 " +
+		"prepare(3) from proposer 0 to a3 prepare(3) from proposer 0 to a4]"
+
+	tests := []struct {
+		name    string
+		state   proposerState
+		in      Message
+		want    proposerState
+		outputs string
+	}{
+		{"start opens the fast epoch",
+			proposerState{}, proposalStart{value: v0},
+			voting(map[Value]map[int]bool{}),
+			"[any(0) from proposer 0 to a0 any(0) from proposer 0 to a1 " +
+				"any(0) from proposer 0 to a2 any(0) from proposer 0 to a3 " +
+				"any(0) from proposer 0 to a4]"},
+		{"vote is counted",
+			voting(map[Value]map[int]bool{v1: set(0)}),
+			accepted{epoch: fast, value: v1, acceptorID: 1},
+			voting(map[Value]map[int]bool{v1: set(0, 1)}),
+			"[]"},
+		{"fast quorum of votes decides the value",
+			voting(map[Value]map[int]bool{v1: set(0, 1, 2)}),
+			accepted{epoch: fast, value: v1, acceptorID: 3},
+			decided,
+			"[]"},
+		{"vote that leaves a fast quorum possible is counted",
+			voting(map[Value]map[int]bool{v1: set(0, 1)}),
+			accepted{epoch: fast, value: v2, acceptorID: 2},
+			voting(map[Value]map[int]bool{v1: set(0, 1), v2: set(2)}),
+			"[]"},
+		{"collision starts recovery in a classic epoch",
+			voting(map[Value]map[int]bool{v1: set(0, 1), v2: set(2)}),
+			accepted{epoch: fast, value: v2, acceptorID: 3},
+			recovery, prepare3},
+		{"timeout in the fast epoch starts recovery",
+			voting(map[Value]map[int]bool{v1: set(0)}), proposalTimeout{},
+			recovery, prepare3},
+		{"quorum of promises proposes the value with the most fast votes",
+			proposerState{round: 2, phase: proposerPhase1, epoch: e3,
+				candidate: v0, value: v2, maxEpoch: fast,
+				promised: set(0, 1), accepted: set(),
+				votes: map[Value]map[int]bool{v2: set(0), v1: set(1)}},
+			promise{epoch: e3, acceptedEpoch: fast, acceptedValue: v1,
+				acceptorID: 2},
+			proposerState{round: 2, phase: proposerPhase2, epoch: e3,
+				candidate: v0, value: v1, maxEpoch: fast,
+				promised: set(0, 1, 2), accepted: set(),
+				votes: map[Value]map[int]bool{v2: set(0), v1: set(1, 2)}},
+			"[propose(3, v1) from proposer 0 to a0 " +
+				"propose(3, v1) from proposer 0 to a1 " +
+				"propose(3, v1) from proposer 0 to a2 " +
+				"propose(3, v1) from proposer 0 to a3 " +
+				"propose(3, v1) from proposer 0 to a4]"},
+	}
+
+	for _, test := range tests {
+		got, outputs := p.step(test.state, test.in)
+		if got.String() != test.want.String() {
+			t.Errorf("%s: got state %s, want %s", test.name, got, test.want)
+		}
+		if fmt.Sprint(outputs) != test.outputs {
+			t.Errorf("%s: got outputs %v, want %s", test.name, outputs,
+				test.outputs)
+		}
+	}
+}
+
+func TestThatFastQuorumsIntersectEveryPhase1Quorum(t *testing.T) {
+	for _, test := range []struct {
+		quorums    QuorumSystem
+		nAcceptors int
+		want       int
+	}{
+		{Majority{NAcceptors: 3}, 3, 3},
+		{Majority{NAcceptors: 4}, 4, 3},
+		{Majority{NAcceptors: 5}, 5, 4},
+		{Majority{NAcceptors: 7}, 7, 6},
+		{Flexible{Phase1: 4, Phase2: 2}, 5, 4},
+	} {
+		got, err := fastQuorum(test.quorums, test.nAcceptors, 0)
+		if err != nil || got != test.want {
+			t.Errorf("fast quorum of %v among %d acceptors: got %d, %v, want %d",
+				test.quorums, test.nAcceptors, got, err, test.want)
+		}
+	}
+
+	c := Config{NProposers: 2, NAcceptors: 5, Fast: true, FastQuorum: 3,
+		Seed: 1, Output: io.Discard}
+	if err := c.Run(); err == nil {
+		t.Error("ran Fast Paxos with fast quorums of 3 of 5 acceptors")
+	}
+}
+
+func TestThatFastPaxosAgreesDespiteReordering(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		c := Config{NProposers: 5, NAcceptors: 5, Fast: true,
+			ProposerTimeout: 100 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond,
+			Buffer:          4, Drop: 0.2, Seed: seed, Output: io.Discard}
+		if err := c.Run(); err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+	}
+}
+
+func TestThatASoleClientDecidesInOneRoundTrip(t *testing.T) {
+	var output bytes.Buffer
+	c := Config{NProposers: 2, NAcceptors: 5, NLearners: 1, Fast: true,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          1, Seed: 1, Output: &output}
+
+	cluster, err := c.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(),
+		"proposer 1 believes value v1 is decided") {
+		t.Error("client did not decide its own value")
+	}
+	if strings.Contains(output.String(), "prepare(") {
+		t.Error("coordinator recovered in a classic epoch without a collision")
+	}
+	if m := cluster.Metrics(); m.Rounds != 1 {
+		t.Errorf("got %d rounds, want 1", m.Rounds)
+	}
+}
+
+func TestThatCollidingClientsRecoverInAClassicEpoch(t *testing.T) {
+	var output bytes.Buffer
+	c := Config{NProposers: 5, NAcceptors: 5, Fast: true,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          4, Seed: 1, Output: &output}
+
+	cluster, err := c.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "prepare(5) from proposer 0") {
+		t.Error("coordinator did not recover in its first classic epoch")
+	}
+	if m := cluster.Metrics(); m.Rounds < 2 || m.Decided != 5 {
+		t.Errorf("got %d rounds and %d proposers deciding, want at least 2 "+
+			"rounds and 5 proposers", m.Rounds, m.Decided)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"time"
+)
+
+// AcceptorFault schedules a crash of an acceptor and, optionally, its restart.
+// A crashed acceptor loses every message sent to it until it restarts. On
+// restart, it recovers its state from its storage, unless LoseState is true,
+// in which case it restarts as if it had never received a message. Classic
+// Paxos tolerates the former but not the latter.
+type AcceptorFault struct {
+	// acceptor that crashes
+	Acceptor int
+
+	// time since the start of the run at which the acceptor crashes
+	CrashAt time.Duration
+
+	// time since the start of the run at which the acceptor restarts; if zero,
+	// the acceptor never restarts
+	RestartAt time.Duration
+
+	// whether the acceptor loses its state when it restarts
+	LoseState bool
+}
+
+// String returns the string form of an acceptor fault.
+func (f AcceptorFault) String() string {
+	s := fmt.Sprintf("acceptor %d crashes at %s", f.Acceptor, f.CrashAt)
+	if f.RestartAt > 0 {
+		s += fmt.Sprintf(" and restarts at %s", f.RestartAt)
+		if f.LoseState {
+			s += " without its state"
+		}
+	}
+	return s
+}
+
+// ProposerFault schedules a crash of a proposer in the middle of phase 2: the
+// first time after CrashAt that the proposer enters phase 2, it sends its
+// propose message to only half of the acceptors (rounded up) before crashing.
+// A crashed proposer never restarts.
+type ProposerFault struct {
+	// proposer that crashes
+	Proposer int
+
+	// time since the start of the run after which the proposer crashes
+	CrashAt time.Duration
+}
+
+// String returns the string form of a proposer fault.
+func (f ProposerFault) String() string {
+	return fmt.Sprintf("proposer %d crashes in phase 2 after %s", f.Proposer,
+		f.CrashAt)
+}
+
+// Crash is the message that tells a participant in a run, such as an
+// acceptor, to crash.
+type Crash struct{}
+
+// String returns the string form of a crash message.
+func (Crash) String() string {
+	return "crash"
+}
+
+// Restart is the message that tells a crashed participant to restart.
+type Restart struct {
+	loseState bool // whether an acceptor loses its state
+}
+
+// String returns the string form of a restart message.
+func (r Restart) String() string {
+	if r.loseState {
+		return "restart without state"
+	}
+	return "restart"
+}
+
+// injectAcceptorFault starts a goroutine that sends crash and restart messages
+// to the input channel of an acceptor at the times scheduled by f.
+func (r *run) injectAcceptorFault(f AcceptorFault, input channel) {
+	scheduleCrash(r.sched, input, f.CrashAt, f.RestartAt, f.LoseState)
+}
+
+// scheduleCrash starts a goroutine, using the scheduler s, that places a crash
+// message on input at time crashAt, and, if restartAt is positive, a restart
+// message at time restartAt.
+func scheduleCrash(s scheduler, input channel, crashAt,
+	restartAt time.Duration, loseState bool) {
+
+	s.spawn(func() {
+		s.sleep(crashAt - s.now())
+		input.send(Crash{})
+
+		if restartAt > 0 {
+			s.sleep(restartAt - s.now())
+			input.send(Restart{loseState: loseState})
+		}
+	})
+}
+
+// proposerFaults returns, for each proposer, its earliest fault in
+// c.ProposerFaults, or nil if it has none.
+func (c *Config) proposerFaults() []*ProposerFault {
+	faults := make([]*ProposerFault, c.NProposers)
+	for i := range c.ProposerFaults {
+		f := &c.ProposerFaults[i]
+		if faults[f.Proposer] == nil || f.CrashAt < faults[f.Proposer].CrashAt {
+			faults[f.Proposer] = f
+		}
+	}
+	return faults
+}
+
+// proposerCrash is placed on the values channel, instead of a value, by a
+// proposer whose fault has fired.
+type proposerCrash struct{}
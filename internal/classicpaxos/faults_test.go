@@ -0,0 +1,140 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// crashMajority returns a Config in which a majority of acceptors crash and
+// restart, either with or without their state.
+func crashMajority(seed int64, loseState bool) Config {
+	ms := time.Millisecond
+
+	return Config{NProposers: 5, NAcceptors: 3, ProposerTimeout: 100 * ms,
+		ChannelTimeout: 10 * ms, Buffer: 2, Drop: 0.1, Seed: seed,
+		Output: io.Discard,
+		AcceptorFaults: []AcceptorFault{
+			{Acceptor: 0, CrashAt: 40 * ms, RestartAt: 45 * ms, LoseState: loseState},
+			{Acceptor: 1, CrashAt: 40 * ms, RestartAt: 45 * ms, LoseState: loseState},
+		}}
+}
+
+func TestThatAgreementToleratesAcceptorRestarts(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		c := crashMajority(seed, false)
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+	}
+}
+
+func TestThatAcceptorsLosingStateBreakAgreement(t *testing.T) {
+	// the errors of the monitor's invariants 2 and 3, and of checkValues
+	disagreements := []string{"as well as value", "chosen in earlier epoch",
+		"proposed in later epoch", "some of them differ"}
+
+	broken := false
+	for seed := int64(1); seed <= 20; seed++ {
+		c := crashMajority(seed, true)
+		err := c.Run()
+		if err == nil {
+			continue
+		}
+
+		disagreed := false
+		for _, d := range disagreements {
+			disagreed = disagreed || strings.Contains(err.Error(), d)
+		}
+		if !disagreed {
+			t.Errorf("with seed %d, got %v, want a disagreement", seed, err)
+		}
+		broken = broken || disagreed
+	}
+
+	if !broken {
+		t.Errorf("agreement held in every run, want some run to violate it")
+	}
+}
+
+func TestThatAgreementToleratesProposerCrashes(t *testing.T) {
+	ms := time.Millisecond
+
+	for seed := int64(1); seed <= 20; seed++ {
+		c := Config{NProposers: 3, NAcceptors: 5, ProposerTimeout: 100 * ms,
+			ChannelTimeout: 10 * ms, Buffer: 2, Drop: 0.1, Seed: seed,
+			Output:         io.Discard,
+			ProposerFaults: []ProposerFault{{Proposer: 0}, {Proposer: 1}},
+			AcceptorFaults: []AcceptorFault{{Acceptor: 4, CrashAt: 20 * ms}}}
+
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+	}
+}
+
+func TestThatProposersWhoseFaultsNeverFireAreChecked(t *testing.T) {
+	ms := time.Millisecond
+
+	for _, nProposers := range []int{1, 3} {
+		var out bytes.Buffer
+		c := Config{NProposers: nProposers, NAcceptors: 3,
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Seed: 1, Output: &out,
+			ProposerFaults: []ProposerFault{{Proposer: 0, CrashAt: time.Hour}}}
+
+		if err := c.Run(); err != nil {
+			t.Fatalf("with %d proposers, got %v", nProposers, err)
+		}
+		want := fmt.Sprintf("yay! %d values were agreed", nProposers)
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("with %d proposers, got output %q, want %q", nProposers,
+				out.String(), want)
+		}
+	}
+}
+
+func TestThatRestartsBeforeCrashesAreRejected(t *testing.T) {
+	ms := time.Millisecond
+
+	for _, restartAt := range []time.Duration{10 * ms, 20 * ms} {
+		c := Config{NProposers: 1, NAcceptors: 3,
+			AcceptorFaults: []AcceptorFault{
+				{Acceptor: 0, CrashAt: 20 * ms, RestartAt: restartAt}}}
+		if err := c.Run(); err == nil {
+			t.Errorf("restart at %s: got nil error", restartAt)
+		}
+	}
+}
+
+func TestThatFaultsForNonexistentParticipantsAreRejected(t *testing.T) {
+	configs := []Config{
+		{NProposers: 1, NAcceptors: 1,
+			AcceptorFaults: []AcceptorFault{{Acceptor: 1}}},
+		{NProposers: 1, NAcceptors: 1,
+			ProposerFaults: []ProposerFault{{Proposer: -1}}},
+	}
+
+	for i, c := range configs {
+		if err := c.Run(); err == nil {
+			t.Errorf("test case %d: got nil error", i)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+)
+
+// forward is the message by which a proposer that is not the leader hands its
+// candidate value to the proposer that it believes is the leader.
+type forward struct {
+	value      Value
+	proposerID int
+}
+
+// String returns the string form of a forward message.
+func (f forward) String() string {
+	return fmt.Sprintf("forward(%s) from proposer %d", f.value, f.proposerID)
+}
+
+// outcome is the message by which the leader tells a proposer that forwarded
+// its candidate value which value is decided.
+type outcome struct {
+	value      Value
+	proposerID int
+}
+
+// String returns the string form of an outcome message.
+func (o outcome) String() string {
+	return fmt.Sprintf("outcome(%s) from proposer %d", o.value, o.proposerID)
+}
+
+// follow returns the value that the leader says is decided, having forwarded
+// candidateValue to it, or proposes candidateValue itself if it suspects every
+// lower-numbered proposer, as strategy d says. Like Propose, follow returns
+// ctx.Err() if ctx is done first.
+func (p *Proposer) follow(ctx context.Context, d DistinguishedProposer,
+	candidateValue Value) (Value, error) {
+
+	for leader := 0; leader < p.id; leader++ {
+		for i := 0; i < d.suspectAfter(); i++ {
+			p.transport.Send(ProposerNode(p.id), ProposerNode(leader),
+				forward{value: candidateValue, proposerID: p.id})
+
+			value, ok, err := p.awaitOutcome(ctx)
+			if err != nil {
+				return Value{}, err
+			}
+			if ok {
+				fmt.Fprintf(p.out, "proposer %d believes value %s is decided\n",
+					p.id, value)
+				p.trace.step(EventDecided, ProposerNode(p.id), Epoch{},
+					value.String())
+				return value, nil
+			}
+		}
+		fmt.Fprintf(p.out, "proposer %d suspects leader %d\n", p.id, leader)
+	}
+
+	if p.id > 0 {
+		fmt.Fprintf(p.out, "proposer %d takes over as leader\n", p.id)
+	}
+	return p.Propose(ctx, candidateValue)
+}
+
+// awaitOutcome waits up to the proposer's timeout for an outcome message,
+// and returns the value decided and true, or false if none arrives in time.
+// It returns an error if ctx is done or the proposer stops first.
+func (p *Proposer) awaitOutcome(ctx context.Context) (Value, bool, error) {
+	deadline := p.sched.now() + p.timeout
+	for {
+		msg, ok := p.input.receiveTimeout(deadline - p.sched.now())
+		if !ok {
+			return Value{}, false, ctx.Err()
+		}
+		if msg == nil {
+			return Value{}, false, fmt.Errorf("proposer %d stopped", p.id)
+		}
+		if err := ctx.Err(); err != nil {
+			return Value{}, false, err
+		}
+
+		fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, msg)
+		p.trace.arrival(EventReceived, ProposerNode(p.id), msg, "")
+
+		if d, ok := msg.(outcome); ok {
+			return d.value, true, nil
+		}
+	}
+}
+
+// lead answers each forward message that the proposer receives, once it has
+// decided value as the leader, with an outcome message, until its input
+// channel is closed.
+func (p *Proposer) lead(value Value) {
+	for {
+		msg := p.input.receive()
+		if msg == nil {
+			return
+		}
+
+		fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, msg)
+		p.trace.arrival(EventReceived, ProposerNode(p.id), msg, "")
+
+		if f, ok := msg.(forward); ok {
+			p.transport.Send(ProposerNode(p.id), ProposerNode(f.proposerID),
+				outcome{value: value, proposerID: p.id})
+		}
+	}
+}
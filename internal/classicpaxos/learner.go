@@ -0,0 +1,190 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Learner represents the learner role in Classic Paxos. A learner never
+// proposes; it learns that a value is chosen once a phase 2 quorum of
+// acceptors tell it that they accepted the value in the same epoch.
+type Learner struct {
+	input   channel                   // input channel
+	id      int                       // learner identifier
+	quorums QuorumSystem              // which acceptors form quorums
+	onLearn func(id int, value Value) // called with each chosen value
+	trace   *tracer                   // learner traces its progress
+	out     io.Writer                 // learner prints its progress to out
+
+	// if not nil, the learner sends query messages to the nAcceptors
+	// acceptors over transport, whenever it has waited for timeout without
+	// learning a value
+	transport  Transport
+	nAcceptors int
+	timeout    time.Duration
+
+	done chan struct{} // closed when the learner's goroutine returns
+}
+
+// query is the message by which a learner that has yet to learn a value asks
+// an acceptor again for the proposal that it accepted last, in case the
+// acceptor's accepted message was lost.
+type query struct {
+	learnerID int
+}
+
+// String returns the string form of a query message.
+func (q query) String() string {
+	return fmt.Sprintf("query() from learner %d", q.learnerID)
+}
+
+// NewLearner returns a learner numbered id, which calls onLearn with its id
+// and each value that it learns is chosen by a phase 2 quorum in quorums. The
+// learner runs in its own goroutine until ctx is done, calls onLearn from that
+// goroutine, and prints its progress to out unless out is nil. It only
+// listens, so it learns a value only if it receives enough of the acceptors'
+// accepted messages.
+func NewLearner(ctx context.Context, id int, quorums QuorumSystem,
+	onLearn func(id int, value Value), out io.Writer) *Learner {
+
+	s := newRealScheduler()
+	s.stopWhenDone(ctx)
+	return newLearner(s, id, s.newChannel(inboxSize), quorums, nil, 0, 0,
+		onLearn, nil, orDiscard(out))
+}
+
+// newLearner creates a learner with the given parameters and starts its
+// goroutine using the scheduler s. Unless transport is nil, the learner
+// queries the nAcceptors acceptors whenever it has waited for timeout without
+// learning a value.
+func newLearner(s scheduler, id int,
+	input channel,
+	quorums QuorumSystem,
+	transport Transport,
+	nAcceptors int,
+	timeout time.Duration,
+	onLearn func(id int, value Value),
+	trace *tracer,
+	out io.Writer) *Learner {
+
+	l := &Learner{
+		input:      input,
+		id:         id,
+		quorums:    quorums,
+		onLearn:    onLearn,
+		trace:      trace,
+		out:        out,
+		transport:  transport,
+		nAcceptors: nAcceptors,
+		timeout:    timeout,
+		done:       make(chan struct{}),
+	}
+	s.spawn(func() {
+		defer close(l.done)
+		l.run()
+	})
+	return l
+}
+
+// Wait waits until the learner's goroutine returns, which happens once its
+// context is done.
+func (l *Learner) Wait() {
+	<-l.done
+}
+
+// Deliver delivers m, which was sent to the learner, or loses m if the
+// learner has yet to receive inboxSize messages delivered earlier.
+func (l *Learner) Deliver(m Message) {
+	l.input.trySend(m)
+}
+
+// run counts the acceptors that have accepted each proposal. Whenever a phase
+// 2 quorum has accepted a proposal whose value differs from the values
+// learned before, it reports the value as chosen. If Classic Paxos is safe, it
+// reports just one value. Until it learns a value, run queries the acceptors
+// after each timeout, since the accepted messages may be lost. run returns
+// once its input channel is closed.
+func (l *Learner) run() {
+	// keys are proposals (epoch, value), then acceptors that have accepted them
+	acceptedAcceptors := make(map[string]map[int]bool)
+	learned := make(map[Value]bool) // keys are chosen values
+
+	for {
+		m, ok := l.receive(len(learned) == 0)
+		if !ok {
+			l.query()
+			continue
+		}
+		if m == nil {
+			return
+		}
+
+		fmt.Fprintf(l.out, "learner %d received message %s\n", l.id, m)
+		l.trace.arrival(EventReceived, LearnerNode(l.id), m, "")
+
+		msg, ok := m.(accepted)
+		if !ok {
+			continue
+		}
+
+		proposal := fmt.Sprintf("(%s, %s)", msg.epoch, msg.value)
+		if acceptedAcceptors[proposal] == nil {
+			acceptedAcceptors[proposal] = make(map[int]bool)
+		}
+		acceptedAcceptors[proposal][msg.acceptorID] = true
+
+		if !isChosen(l.quorums, msg.epoch, acceptedAcceptors[proposal]) ||
+			learned[msg.value] {
+			continue
+		}
+
+		if len(learned) > 0 {
+			fmt.Fprintf(l.out, "uh oh! learner %d learned a second value %s\n",
+				l.id, msg.value)
+		}
+		fmt.Fprintf(l.out, "learner %d learned that value %s is chosen\n", l.id,
+			msg.value)
+		l.trace.step(EventDecided, LearnerNode(l.id), msg.epoch,
+			msg.value.String())
+
+		learned[msg.value] = true
+		l.onLearn(l.id, msg.value)
+	}
+}
+
+// receive waits for a message and returns it and true, or returns nil and true
+// if the learner's input channel is closed. If waiting and the learner can
+// query the acceptors, it waits only up to its timeout, and returns nil and
+// false if the timeout expires first.
+func (l *Learner) receive(waiting bool) (Message, bool) {
+	if !waiting || l.transport == nil {
+		return l.input.receive(), true
+	}
+	return l.input.receiveTimeout(l.timeout)
+}
+
+// query asks every acceptor again for the proposal that it accepted last.
+func (l *Learner) query() {
+	fmt.Fprintf(l.out, "learner %d timed out, queries the acceptors\n", l.id)
+	l.trace.step(EventTimeout, LearnerNode(l.id), Epoch{}, "")
+	for a := 0; a < l.nAcceptors; a++ {
+		l.transport.Send(LearnerNode(l.id), AcceptorNode(a),
+			query{learnerID: l.id})
+	}
+}
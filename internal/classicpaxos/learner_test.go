@@ -0,0 +1,92 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThatLearnersLearnTheAgreedValue(t *testing.T) {
+	ms := time.Millisecond
+
+	for seed := int64(1); seed <= 20; seed++ {
+		learned := make(map[int][]Value)
+		c := Config{NProposers: 3, NAcceptors: 5, NLearners: 3,
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Drop: 0.1, Seed: seed, Output: io.Discard,
+			OnLearn: func(learner int, value Value) {
+				learned[learner] = append(learned[learner], value)
+			}}
+
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+
+		if len(learned) != c.NLearners {
+			t.Errorf("with seed %d, %d learners learned a value, want %d", seed,
+				len(learned), c.NLearners)
+		}
+		for learner, values := range learned {
+			if len(values) != 1 || values[0] != learned[0][0] {
+				t.Errorf("with seed %d, learner %d learned %v, want [%s]", seed,
+					learner, values, learned[0][0])
+			}
+		}
+	}
+}
+
+func TestThatLearnersObserveAcceptorsLosingState(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		learned := make(map[Value]bool)
+		c := crashMajority(seed, true)
+		c.NLearners = 1
+		c.OnLearn = func(learner int, value Value) {
+			learned[value] = true
+		}
+
+		c.Run()
+
+		if len(learned) > 1 {
+			return // as expected
+		}
+	}
+	t.Errorf("learner learned one value in every run, want some run in which " +
+		"it learns two")
+}
+
+func TestThatLearnersLearnAValueWhenMessagesToThemAreLost(t *testing.T) {
+	ms := time.Millisecond
+
+	for _, seed := range []int64{5, 11, 25, 33} {
+		learned := make(map[int]bool)
+		c := Config{NProposers: 2, NAcceptors: 3, NLearners: 2,
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Drop: 0.2, Seed: seed, Output: io.Discard,
+			OnLearn: func(learner int, value Value) {
+				learned[learner] = true
+			}}
+
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+
+		if len(learned) != c.NLearners {
+			t.Errorf("with seed %d, %d learners learned a value, want %d", seed,
+				len(learned), c.NLearners)
+		}
+	}
+}
@@ -15,6 +15,7 @@
 package classicpaxos
 
 import (
+	"fmt"
 	"math/rand"
 	"time"
 )
@@ -23,10 +24,10 @@ import (
 // messages.
 type lossyChannel struct {
 	// input channel for receiving messages that get buffered
-	input chan message
+	input channel
 
 	// buffer of messages
-	buf []message
+	buf []Message
 
 	// amount of time to wait for buffer to fill before returning a message from
 	// receive
@@ -40,25 +41,52 @@ type lossyChannel struct {
 
 	// output channel onto which non-dropped, possibly reordered messages get
 	// placed
-	output chan message
+	output channel
+
+	// scheduler whose clock measures the timeout
+	sched scheduler
+
+	// source of the decisions to drop and reorder messages
+	rand *rand.Rand
+
+	// node to which the messages are sent
+	node Node
+
+	// tracer of dropped and reordered messages
+	trace *tracer
+
+	// order in which the messages in buf arrived, numbered from 0
+	arrivals []int
+
+	// number of messages buffered so far
+	nArrived int
 }
 
-// newLossyChannel returns a new lossyChannel with the given parameters.
-// size is the number of messages to buffer before returning one from receive.
-// timeout is the amount of time to wait for the lossy channel to contain
-// size messages before returning a message. drop is the probability in the
-// range [0, 1) of dropping a message.
-func newLossyChannel(size int, timeout time.Duration, drop float64) *lossyChannel {
+// newLossyChannel returns a new lossyChannel with the given parameters, and
+// starts its goroutine using the scheduler s. r decides which messages are
+// dropped and how they are reordered. size is the number of messages to
+// buffer before returning one from receive. timeout is the amount of time to
+// wait for the lossy channel to contain size messages before returning a
+// message. drop is the probability in the range [0, 1) of dropping a message.
+// The messages are sent to node n, and trace traces the ones dropped and
+// reordered.
+func newLossyChannel(s scheduler, r *rand.Rand, size int, timeout time.Duration,
+	drop float64, n Node, trace *tracer) *lossyChannel {
+
 	l := &lossyChannel{
-		input:   make(chan message, size),
-		buf:     make([]message, 0, size),
+		input:   s.newChannel(size),
+		buf:     make([]Message, 0, size),
 		timeout: timeout,
 		size:    size,
 		drop:    drop,
-		output:  make(chan message, size),
+		output:  s.newChannel(size),
+		sched:   s,
+		rand:    r,
+		node:    n,
+		trace:   trace,
 	}
 
-	go l.run()
+	s.spawn(l.run)
 
 	return l
 }
@@ -71,7 +99,7 @@ func (l *lossyChannel) run() {
 		if msg == nil {
 			return
 		}
-		l.output <- msg
+		l.output.send(msg)
 	}
 }
 
@@ -81,50 +109,73 @@ func (l *lossyChannel) run() {
 // messages, it returns one of them selected pseudo-randomly; else (if the
 // channel contains zero messages), it waits to receive a message, and returns
 // that message. It drops incoming messages with probability l.drop.
-func (l *lossyChannel) receive() message {
-	start := time.Now()
+func (l *lossyChannel) receive() Message {
+	start := l.sched.now()
 
 	for {
-		remaining := l.timeout - time.Since(start) // time left
+		remaining := l.timeout - (l.sched.now() - start) // time left
 
 		// if there are at least l.size messages in the buffer, or there is no
 		// time left and we have at least 1 message in the buffer, then permute
 		// l.buf and return one of its messages
 		if len(l.buf) >= l.size || remaining <= 0 && len(l.buf) > 0 {
-			rand.Shuffle(len(l.buf), func(i, j int) {
+			l.rand.Shuffle(len(l.buf), func(i, j int) {
 				l.buf[i], l.buf[j] = l.buf[j], l.buf[i]
+				l.arrivals[i], l.arrivals[j] = l.arrivals[j], l.arrivals[i]
 			})
 
 			msg := l.buf[0]
+			if overtaken := l.overtaken(); overtaken > 0 {
+				l.trace.arrival(EventReordered, l.node, msg,
+					fmt.Sprintf("ahead of %d earlier message/s", overtaken))
+			}
 
-			buf := make([]message, len(l.buf)-1)
+			buf := make([]Message, len(l.buf)-1)
 			copy(buf, l.buf[1:])
 			l.buf = buf
+			l.arrivals = l.arrivals[1:]
 
 			return msg
 		}
 
-		select {
-		case msg := <-l.input:
-			if rand.Float64() >= l.drop {
+		if msg, ok := l.input.receiveTimeout(remaining); ok {
+			if msg == nil {
+				return nil // closed
+			}
+			if l.rand.Float64() >= l.drop {
 				l.buf = append(l.buf, msg) // yay! buffer the message
+				l.arrivals = append(l.arrivals, l.nArrived)
+				l.nArrived++
+			} else {
+				l.trace.arrival(EventDropped, l.node, msg, "lossy channel")
 			}
-		case <-time.After(remaining):
-			if len(l.buf) <= 0 {
-				// time's up! return the next message that's not dropped
-				for {
-					msg := <-l.input
-					if rand.Float64() >= l.drop {
-						return msg
-					}
+		} else if len(l.buf) <= 0 {
+			// time's up! return the next message that's not dropped
+			for {
+				msg := l.input.receive()
+				if msg == nil || l.rand.Float64() >= l.drop {
+					return msg
 				}
-			} // else resume at beginning of for loop
+				l.trace.arrival(EventDropped, l.node, msg, "lossy channel")
+			}
+		} // else resume at beginning of for loop
+	}
+}
+
+// overtaken returns the number of messages in l.buf that arrived before the
+// first.
+func (l *lossyChannel) overtaken() int {
+	n := 0
+	for _, a := range l.arrivals[1:] {
+		if a < l.arrivals[0] {
+			n++
 		}
 	}
+	return n
 }
 
 // close closes l's input and output channels.
 func (l *lossyChannel) close() {
-	close(l.input)
-	close(l.output)
+	l.input.close()
+	l.output.close()
 }
@@ -26,18 +26,22 @@ type testMessage struct {
 }
 
 func TestThatLossyChannelDelivers1Message(t *testing.T) {
+	s := newRealScheduler()
+	input := s.newChannel(1)
 	l := lossyChannel{
-		input:   make(chan message, 1),
+		input:   input,
 		timeout: time.Second,
 		size:    1,
 		drop:    0,
+		sched:   s,
+		rand:    rand.New(rand.NewSource(1)),
 	}
 
 	msg := testMessage{number: rand.Int()}
 
 	go func() {
 		time.Sleep(100 * time.Millisecond)
-		l.input <- msg
+		input.send(msg)
 	}()
 
 	got := (l.receive()).(testMessage)
@@ -48,16 +52,20 @@ func TestThatLossyChannelDelivers1Message(t *testing.T) {
 }
 
 func TestThatLossyChannelDelivers10Messages(t *testing.T) {
+	s := newRealScheduler()
+	input := s.newChannel(10)
 	l := lossyChannel{
-		input:   make(chan message, 10),
+		input:   input,
 		timeout: time.Millisecond,
 		size:    10,
 		drop:    0,
+		sched:   s,
+		rand:    rand.New(rand.NewSource(1)),
 	}
 
 	// send 10 messages
 	for n := 0; n < 10; n++ {
-		l.input <- testMessage{number: n}
+		input.send(testMessage{number: n})
 	}
 
 	received := make(map[int]bool) // received messages
@@ -76,14 +84,18 @@ func TestThatLossyChannelDelivers10Messages(t *testing.T) {
 }
 
 func TestThatLossyChannelDropsMessages(t *testing.T) {
+	s := newRealScheduler()
+	input := s.newChannel(1)
 	l := lossyChannel{
-		input:   make(chan message, 1),
+		input:   input,
 		timeout: time.Millisecond,
 		size:    1,
 		drop:    1, // always drop
+		sched:   s,
+		rand:    rand.New(rand.NewSource(1)),
 	}
 
-	l.input <- testMessage{}
+	input.send(testMessage{})
 
 	var mut sync.Mutex
 	received := false
@@ -16,13 +16,15 @@ package classicpaxos
 
 import "fmt"
 
-type message interface{}
+// Message is a message that a participant in Classic Paxos sends to another.
+// Its dynamic type is one of the message types of this package, so a Transport
+// may carry it but need not inspect it.
+type Message interface{}
 
 // prepare is the message sent by the proposer in phase 1 of Classic Paxos.
 type prepare struct {
 	epoch      Epoch
 	proposerID int
-	replyTo    chan<- message
 }
 
 // String returns the string form of a prepare message.
@@ -34,29 +36,21 @@ func (p prepare) String() string {
 // promise is the message sent by the acceptor in phase 1 of Classic Paxos.
 type promise struct {
 	epoch, acceptedEpoch Epoch
-	acceptedValue        string
+	acceptedValue        Value
 	acceptorID           int
 }
 
 // String returns the string form of a promise message.
 func (p promise) String() string {
-	var v string
-	if p.acceptedValue == "" {
-		v = "nil"
-	} else {
-		v = p.acceptedValue
-	}
-
 	return fmt.Sprintf("promise(%s, %s, %s) from acceptor %d",
-		p.epoch, p.acceptedEpoch, v, p.acceptorID)
+		p.epoch, p.acceptedEpoch, p.acceptedValue, p.acceptorID)
 }
 
 // propose is the message sent by the proposer in phase 2 of Classic Paxos.
 type propose struct {
 	epoch      Epoch
-	value      string
+	value      Value
 	proposerID int
-	replyTo    chan<- message
 }
 
 // String returns the string form of a propose message.
@@ -76,3 +70,31 @@ func (a accept) String() string {
 	return fmt.Sprintf("accept(%s) from acceptor %d",
 		a.epoch, a.acceptorID)
 }
+
+// accepted is the message sent by the acceptor to every learner when it
+// accepts a proposal.
+type accepted struct {
+	epoch      Epoch
+	value      Value
+	acceptorID int
+}
+
+// String returns the string form of an accepted message.
+func (a accepted) String() string {
+	return fmt.Sprintf("accepted(%s, %s) from acceptor %d",
+		a.epoch, a.value, a.acceptorID)
+}
+
+// reject is the message sent by the acceptor in reply to a prepare or propose
+// message for an epoch less than its promised epoch, so that the proposer can
+// abandon that epoch at once, rather than wait for its timeout.
+type reject struct {
+	epoch, promisedEpoch Epoch
+	acceptorID           int
+}
+
+// String returns the string form of a reject message.
+func (r reject) String() string {
+	return fmt.Sprintf("reject(%s, %s) from acceptor %d",
+		r.epoch, r.promisedEpoch, r.acceptorID)
+}
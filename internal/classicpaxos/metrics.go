@@ -0,0 +1,95 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Metrics measures how much work the proposers in a run did to decide, and
+// how long they took, and how many messages the participants sent.
+type Metrics struct {
+	// number of rounds that the proposers started, in total
+	Rounds int
+
+	// number of reject messages that the proposers received, in total
+	Rejects int
+
+	// number of proposers that decided a value
+	Decided int
+
+	// number of messages that the participants sent over the network, in
+	// total, whether or not they arrived
+	Messages int
+
+	// mean and greatest time from the start of the run until a proposer
+	// decided, over the proposers that decided; in a simulated run, the time
+	// is virtual
+	MeanLatency, MaxLatency time.Duration
+}
+
+// String returns the string form of metrics.
+func (m Metrics) String() string {
+	return fmt.Sprintf("%d proposer/s decided after %d round/s, %d "+
+		"reject/s and %d message/s, with mean latency %s and max latency %s",
+		m.Decided, m.Rounds, m.Rejects, m.Messages, m.MeanLatency,
+		m.MaxLatency)
+}
+
+// metricsRecorder accumulates the metrics of a run from proposers that may
+// finish concurrently.
+type metricsRecorder struct {
+	mu      sync.Mutex
+	metrics Metrics
+	total   time.Duration // sum of the latencies of the proposers that decided
+}
+
+// finished records that the proposer p has stopped proposing, having decided
+// a value at time now since the start of the run if decided is true.
+func (r *metricsRecorder) finished(p *Proposer, decided bool,
+	now time.Duration) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics.Rounds += p.state.round
+	r.metrics.Rejects += p.rejects
+	if !decided {
+		return
+	}
+
+	r.metrics.Decided++
+	r.total += now
+	r.metrics.MeanLatency = r.total / time.Duration(r.metrics.Decided)
+	if now > r.metrics.MaxLatency {
+		r.metrics.MaxLatency = now
+	}
+}
+
+// sent records that a participant sent a message over the network.
+func (r *metricsRecorder) sent() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics.Messages++
+}
+
+// get returns the metrics recorded so far.
+func (r *metricsRecorder) get() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metrics
+}
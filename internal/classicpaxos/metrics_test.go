@@ -0,0 +1,71 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestThatRejectsSpeedUpProposersBehindTheAcceptors tests that proposers that
+// start afresh, against acceptors that recover promises of high epochs from
+// their logs, need fewer rounds and less time to decide when the acceptors
+// reject their early epochs than when the acceptors ignore them.
+func TestThatRejectsSpeedUpProposersBehindTheAcceptors(t *testing.T) {
+	var metrics [2]Metrics
+
+	for i, noRejects := range []bool{true, false} {
+		c := Config{NProposers: 10, NAcceptors: 5,
+			ProposerTimeout: 100 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond,
+			Buffer:          2, Drop: 0.1, Seed: 1, NoRejects: noRejects,
+			WALDir: t.TempDir(), Output: io.Discard}
+
+		// a busy run drives the acceptors' promised epochs up
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		c.NProposers, c.Seed = 2, 2
+		cluster, err := c.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cluster.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		metrics[i] = cluster.Metrics()
+	}
+
+	without, with := metrics[0], metrics[1]
+	if with.Decided != 2 || without.Decided != 2 {
+		t.Fatalf("got %d and %d proposers deciding, want 2", with.Decided,
+			without.Decided)
+	}
+	if with.Rejects == 0 || without.Rejects != 0 {
+		t.Errorf("got %d rejects with and %d without, want some and none",
+			with.Rejects, without.Rejects)
+	}
+	if with.Rounds >= without.Rounds {
+		t.Errorf("got %d rounds with rejects, want fewer than %d without",
+			with.Rounds, without.Rounds)
+	}
+	if with.MaxLatency >= without.MaxLatency {
+		t.Errorf("got latency %s with rejects, want less than %s without",
+			with.MaxLatency, without.MaxLatency)
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// ModelCheck represents configuration for exhaustively checking Classic Paxos
+// with a small number of participants. Rather than run the participants, the
+// model checker takes their steps with proposerCore.step and
+// acceptorCore.step, the same algorithms that a run drives, and explores
+// every state that they can reach by delivering the messages in flight in any
+// order, dropping any of them, and timing out any proposer at any moment. It
+// checks in every state that at most one value is chosen.
+//
+// Dropping a message leads to no state that leaving it in flight forever does
+// not, so the model checker drops a message only once its receiver would ignore
+// it, which keeps equivalent states from multiplying.
+//
+// The acceptors of the model send no reject messages, since their only effect
+// is to start a proposer's next round sooner, and in a later epoch, than a
+// timeout would. The model has no learners, and its proposers use
+// ModuloEpochs.
+type ModelCheck struct {
+	// number of proposers
+	NProposers int
+
+	// number of acceptors
+	NAcceptors int
+
+	// number of rounds that each proposer may start, which bounds the epochs
+	MaxRounds int
+
+	// which acceptors form quorums in each phase (Majority if nil); unlike a
+	// run, a model check accepts quorums that do not intersect, so as to show
+	// what goes wrong without them
+	Quorums QuorumSystem
+
+	// if positive, the number of states after which to give up
+	MaxStates int
+}
+
+// ModelCheckResult is the result of a model check.
+type ModelCheckResult struct {
+	// number of distinct states explored
+	States int
+
+	// if not nil, the steps from the initial state to the first state found
+	// in which two values are chosen; no shorter sequence of steps exists
+	Counterexample []string
+}
+
+// modelMessage is a message in flight in the model, along with its string
+// form, which identifies it and orders the messages in flight canonically.
+type modelMessage struct {
+	output
+	key string
+}
+
+// modelVote records the acceptors that have ever accepted the value proposed
+// in an epoch.
+type modelVote struct {
+	epoch     Epoch
+	value     Value
+	acceptors map[int]bool
+}
+
+// modelState is a state of the model: the state of every participant, the
+// messages in flight, and the history of votes from which chosen values are
+// known. Like the steps of the participants, which never modify the maps of a
+// state, the model checker replaces rather than modifies the maps of votes,
+// so that states may share them.
+type modelState struct {
+	proposers []proposerState
+	acceptors []AcceptorState
+	messages  []modelMessage // in a canonical order
+	votes     []modelVote    // in increasing order of epoch
+}
+
+// hash returns a 64-bit hash of s, using buf as scratch space, along with buf.
+func (s *modelState) hash(buf []byte) (uint64, []byte) {
+	b := buf[:0]
+	ints := func(is ...int) {
+		for _, i := range is {
+			b = append(b, byte(i), byte(i>>8), byte(i>>16), byte(i>>24))
+		}
+	}
+	epoch := func(e Epoch) {
+		if e.Nil() {
+			b = append(b, '-')
+		} else {
+			b = e.i.Append(b, 10)
+		}
+		b = append(b, 0)
+	}
+	value := func(v Value) {
+		if v.Nil() {
+			b = append(b, '-')
+		} else {
+			b = append(b, v.data...)
+		}
+		b = append(b, 0)
+	}
+	set := func(acceptors map[int]bool) {
+		bits := 0
+		for a := range acceptors {
+			bits |= 1 << a
+		}
+		ints(bits)
+	}
+
+	for _, p := range s.proposers {
+		ints(p.round, p.phase)
+		epoch(p.epoch)
+		value(p.candidate)
+		value(p.value)
+		epoch(p.maxEpoch)
+		set(p.promised)
+		set(p.accepted)
+	}
+	for _, a := range s.acceptors {
+		epoch(a.PromisedEpoch)
+		epoch(a.AcceptedEpoch)
+		value(a.AcceptedValue)
+	}
+	ints(len(s.messages))
+	for _, m := range s.messages {
+		b = append(b, m.key...)
+		b = append(b, 0)
+	}
+	for _, v := range s.votes {
+		epoch(v.epoch)
+		value(v.value)
+		set(v.acceptors)
+	}
+
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64(), b
+}
+
+// clone returns a copy of s that shares no slices with it.
+func (s *modelState) clone() *modelState {
+	return &modelState{
+		proposers: append([]proposerState(nil), s.proposers...),
+		acceptors: append([]AcceptorState(nil), s.acceptors...),
+		messages:  append([]modelMessage(nil), s.messages...),
+		votes:     append([]modelVote(nil), s.votes...),
+	}
+}
+
+// send places the outputs in flight, keeping s.messages in canonical order.
+func (s *modelState) send(outputs []output) {
+	for _, o := range outputs {
+		m := modelMessage{output: o, key: o.String()}
+		i := sort.Search(len(s.messages), func(i int) bool {
+			return s.messages[i].key >= m.key
+		})
+		s.messages = append(s.messages, modelMessage{})
+		copy(s.messages[i+1:], s.messages[i:])
+		s.messages[i] = m
+	}
+}
+
+// modelAction is a step of the model: the delivery of a message, or the
+// timeout of a proposer.
+type modelAction struct {
+	timeout  bool         // whether the step is a timeout
+	proposer int          // proposer that times out
+	epoch    Epoch        // epoch that the proposer prepares on timing out
+	message  modelMessage // message delivered
+}
+
+// String returns the string form of a step.
+func (a modelAction) String() string {
+	if a.timeout {
+		return fmt.Sprintf("p%d times out and prepares epoch %s", a.proposer,
+			a.epoch)
+	}
+	return "deliver " + a.message.key
+}
+
+// modelNode records how the model checker first reached a state.
+type modelNode struct {
+	parent int32       // index of the previous node, or -1 at the initial state
+	action modelAction // step from the previous node
+}
+
+// modelChecker explores the states of the model for a ModelCheck. To keep the
+// states in memory, it remembers each state reached only by a 64-bit hash, so
+// it could in principle miss a state whose hash collides with another's, but
+// the chance of that among millions of states is about one in a million.
+type modelChecker struct {
+	ModelCheck
+	proposers []proposerCore      // proposer algorithms
+	acceptors []acceptorCore      // acceptor algorithms
+	nodes     []modelNode         // states reached, in breadth-first order
+	seen      map[uint64]struct{} // hashes of states reached
+	buf       []byte              // scratch space for hashing
+}
+
+// Run explores the states of the model breadth first, and returns the number
+// of states explored. If it finds a state in which two values are chosen, it
+// returns a counterexample that reaches the state in as few steps as possible,
+// along with an error that describes the violation. It also returns an error
+// if it gives up after m.MaxStates states.
+func (m ModelCheck) Run() (ModelCheckResult, error) {
+	if m.NProposers <= 0 || m.NAcceptors <= 0 || m.MaxRounds <= 0 {
+		return ModelCheckResult{}, fmt.Errorf(
+			"model check needs at least 1 proposer, acceptor and round")
+	}
+	if m.NAcceptors > maxCheckedAcceptors {
+		return ModelCheckResult{}, fmt.Errorf(
+			"model check supports at most %d acceptors", maxCheckedAcceptors)
+	}
+	if m.Quorums == nil {
+		m.Quorums = Majority{NAcceptors: m.NAcceptors}
+	}
+
+	c := &modelChecker{ModelCheck: m, seen: make(map[uint64]struct{})}
+	for i := 0; i < m.NProposers; i++ {
+		c.proposers = append(c.proposers, proposerCore{id: i,
+			nProposers: m.NProposers, nAcceptors: m.NAcceptors,
+			quorums: m.Quorums, epochs: ModuloEpochs})
+	}
+	for i := 0; i < m.NAcceptors; i++ {
+		c.acceptors = append(c.acceptors, acceptorCore{id: i, noRejects: true})
+	}
+
+	// the states of the current depth, and their nodes
+	frontier := []*modelState{c.initial()}
+	indices := []int32{0}
+	c.add(frontier[0], -1, modelAction{})
+
+	for len(frontier) > 0 {
+		var nextFrontier []*modelState
+		var nextIndices []int32
+
+		for i, s := range frontier {
+			for _, action := range c.actions(s) {
+				next := c.apply(s, action)
+				if !c.add(next, indices[i], action) {
+					continue
+				}
+				if err := c.check(next); err != nil {
+					return ModelCheckResult{States: len(c.nodes),
+						Counterexample: c.trace(len(c.nodes) - 1)}, err
+				}
+				if m.MaxStates > 0 && len(c.nodes) >= m.MaxStates {
+					return ModelCheckResult{States: len(c.nodes)}, fmt.Errorf(
+						"model check gave up after %d states", m.MaxStates)
+				}
+				nextFrontier = append(nextFrontier, next)
+				nextIndices = append(nextIndices, int32(len(c.nodes)-1))
+			}
+		}
+
+		frontier, indices = nextFrontier, nextIndices
+	}
+
+	return ModelCheckResult{States: len(c.nodes)}, nil
+}
+
+// add adds the state s, reached from node parent by action, unless it has been
+// reached before. It returns true if and only if s is new.
+func (c *modelChecker) add(s *modelState, parent int32,
+	action modelAction) bool {
+
+	var h uint64
+	h, c.buf = s.hash(c.buf)
+	if _, ok := c.seen[h]; ok {
+		return false
+	}
+	c.seen[h] = struct{}{}
+	c.nodes = append(c.nodes, modelNode{parent: parent, action: action})
+	return true
+}
+
+// trace returns the steps from the initial state to node i.
+func (c *modelChecker) trace(i int) []string {
+	var steps []string
+	for n := int32(i); c.nodes[n].parent >= 0; n = c.nodes[n].parent {
+		steps = append(steps, c.nodes[n].action.String())
+	}
+	for l, r := 0, len(steps)-1; l < r; l, r = l+1, r-1 {
+		steps[l], steps[r] = steps[r], steps[l]
+	}
+	return steps
+}
+
+// initial returns the initial state, in which every proposer has started its
+// first round, proposing the candidate value vN if it is proposer N.
+func (c *modelChecker) initial() *modelState {
+	s := &modelState{
+		proposers: make([]proposerState, c.NProposers),
+		acceptors: make([]AcceptorState, c.NAcceptors),
+	}
+	for i, p := range c.proposers {
+		var outputs []output
+		s.proposers[i], outputs = p.step(proposerState{},
+			proposalStart{value: stringValue(fmt.Sprintf("v%d", i))})
+		s.send(outputs)
+	}
+	return s
+}
+
+// actions returns the steps that can be taken from s: the delivery of any
+// message in flight, or the timeout of any proposer that has not decided and
+// may start another round.
+func (c *modelChecker) actions(s *modelState) []modelAction {
+	var actions []modelAction
+
+	for i, m := range s.messages {
+		if i > 0 && m.key == s.messages[i-1].key {
+			continue // same as the step for the identical message
+		}
+		actions = append(actions, modelAction{message: m})
+	}
+
+	for i, p := range s.proposers {
+		if p.phase != proposerDecided && p.round < c.MaxRounds {
+			actions = append(actions, modelAction{timeout: true, proposer: i,
+				epoch: c.proposers[i].nextEpoch(p.epoch)})
+		}
+	}
+
+	return actions
+}
+
+// apply returns the state reached from s by action, in which the proposer
+// that times out, or the receiver of the message delivered, takes its step.
+func (c *modelChecker) apply(s *modelState, action modelAction) *modelState {
+	next := s.clone()
+	var outputs []output
+	if action.timeout {
+		i := action.proposer
+		next.proposers[i], outputs = c.proposers[i].step(next.proposers[i],
+			proposalTimeout{})
+	} else {
+		for i, m := range next.messages {
+			if m.key == action.message.key {
+				next.messages = append(next.messages[:i],
+					next.messages[i+1:]...)
+				break
+			}
+		}
+		outputs = c.deliver(next, action.message.output)
+	}
+	next.send(outputs)
+	next.collect()
+	return next
+}
+
+// deliver delivers m to its receiver in s, which takes its step, and returns
+// the messages that the receiver sends.
+func (c *modelChecker) deliver(s *modelState, m output) []output {
+	var outputs []output
+	i := m.to.ID
+	if m.to.Role == RoleProposer {
+		s.proposers[i], outputs = c.proposers[i].step(s.proposers[i], m.msg)
+		return outputs
+	}
+
+	s.acceptors[i], outputs = c.acceptors[i].step(s.acceptors[i], m.msg)
+	// without rejects, an acceptor replies to a proposal only if it accepts it
+	if p, ok := m.msg.(propose); ok && len(outputs) > 0 {
+		s.vote(p.epoch, p.value, i)
+	}
+	return outputs
+}
+
+// collect drops the messages in flight that their receivers will ignore,
+// whenever they arrive: messages to an acceptor for an epoch less than its
+// promised epoch, and messages to a proposer for a round or phase that it has
+// finished.
+func (s *modelState) collect() {
+	messages := s.messages[:0]
+	for _, m := range s.messages {
+		if s.ignores(m.output) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	s.messages = messages
+}
+
+// ignores returns true if and only if the receiver of m will ignore it,
+// whenever it arrives.
+func (s *modelState) ignores(m output) bool {
+	switch msg := m.msg.(type) {
+	case prepare:
+		a := s.acceptors[m.to.ID]
+		return !a.PromisedEpoch.Nil() && msg.epoch.Cmp(a.PromisedEpoch) < 0
+	case propose:
+		a := s.acceptors[m.to.ID]
+		return !a.PromisedEpoch.Nil() && msg.epoch.Cmp(a.PromisedEpoch) < 0
+	case promise:
+		p := s.proposers[m.to.ID]
+		return msg.epoch.Cmp(p.epoch) != 0 || p.phase != proposerPhase1
+	case accept:
+		p := s.proposers[m.to.ID]
+		return msg.epoch.Cmp(p.epoch) != 0 || p.phase != proposerPhase2
+	}
+	return false
+}
+
+// vote records that the acceptor numbered acceptor accepted value in epoch.
+func (s *modelState) vote(epoch Epoch, value Value, acceptor int) {
+	for i := range s.votes {
+		if s.votes[i].epoch.Cmp(epoch) == 0 {
+			s.votes[i].acceptors = with(s.votes[i].acceptors, acceptor)
+			return
+		}
+	}
+	s.votes = append(s.votes, modelVote{epoch: epoch, value: value,
+		acceptors: with(nil, acceptor)})
+	sort.Slice(s.votes, func(i, j int) bool {
+		return s.votes[i].epoch.Cmp(s.votes[j].epoch) < 0
+	})
+}
+
+// check returns a non-nil error if two different values are chosen in s.
+func (c *modelChecker) check(s *modelState) error {
+	var first *modelVote
+	for i := range s.votes {
+		v := &s.votes[i]
+		if !isChosen(c.Quorums, v.epoch, v.acceptors) {
+			continue
+		}
+		if first == nil {
+			first = v
+		} else if v.value != first.value {
+			return fmt.Errorf("agreement violated: value %s is chosen in "+
+				"epoch %s, and value %s in epoch %s", first.value, first.epoch,
+				v.value, v.epoch)
+		}
+	}
+	return nil
+}
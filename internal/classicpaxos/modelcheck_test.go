@@ -0,0 +1,84 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThatModelCheckFindsNoViolationWithMajorities(t *testing.T) {
+	m := ModelCheck{NProposers: 2, NAcceptors: 3, MaxRounds: 1}
+
+	result, err := m.Run()
+	if err != nil {
+		t.Fatalf("got %v, want no violation; counterexample:\n%s", err,
+			strings.Join(result.Counterexample, "\n"))
+	}
+	if result.States < 1000 {
+		t.Errorf("explored %d states, want at least 1000", result.States)
+	}
+}
+
+func TestThatModelCheckFindsAMinimalCounterexample(t *testing.T) {
+	// a phase 1 quorum of 1 acceptor need not intersect a phase 2 quorum of 2
+	m := ModelCheck{NProposers: 2, NAcceptors: 3, MaxRounds: 1,
+		Quorums: Flexible{Phase1: 1, Phase2: 2}}
+
+	result, err := m.Run()
+	if err == nil || !strings.Contains(err.Error(), "agreement violated") {
+		t.Fatalf("got %v, want agreement violated", err)
+	}
+
+	// each proposer needs 1 promise and 2 accepted proposals, after the
+	// acceptors of its promise and proposals receive its prepare
+	want := 8
+	if len(result.Counterexample) != want {
+		t.Errorf("got counterexample of %d steps, want %d:\n%s",
+			len(result.Counterexample), want,
+			strings.Join(result.Counterexample, "\n"))
+	}
+	// the steps deliver the messages that the proposers and acceptors of a
+	// run send
+	last := result.Counterexample[len(result.Counterexample)-1]
+	if step := "deliver propose(1, v1) from proposer 1 to a1"; last != step {
+		t.Errorf("got last step %q, want %q", last, step)
+	}
+}
+
+func TestThatModelCheckRejectsInvalidConfigurations(t *testing.T) {
+	for _, m := range []ModelCheck{
+		{NProposers: 0, NAcceptors: 3, MaxRounds: 1},
+		{NProposers: 2, NAcceptors: 0, MaxRounds: 1},
+		{NProposers: 2, NAcceptors: 3, MaxRounds: 0},
+		{NProposers: 2, NAcceptors: maxCheckedAcceptors + 1, MaxRounds: 1},
+	} {
+		if _, err := m.Run(); err == nil {
+			t.Errorf("model check of %+v succeeded, want an error", m)
+		}
+	}
+}
+
+func TestThatModelCheckGivesUpAfterMaxStates(t *testing.T) {
+	m := ModelCheck{NProposers: 2, NAcceptors: 3, MaxRounds: 2, MaxStates: 100}
+
+	result, err := m.Run()
+	if err == nil || !strings.Contains(err.Error(), "gave up after 100 states") {
+		t.Errorf("got %v, want model check to give up", err)
+	}
+	if result.States != 100 {
+		t.Errorf("explored %d states, want 100", result.States)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// monitor audits a run of Classic Paxos as it happens, by observing every
+// message sent over the network and every state that acceptors save. It
+// checks the safety properties from chapter 2 of Howard's dissertation:
+//
+//  1. no acceptor's promised epoch ever decreases;
+//  2. at most one value is chosen in each epoch;
+//  3. once a value is chosen in an epoch, every value proposed in a later
+//     epoch equals it; and
+//  4. each epoch is used by one proposer only, which never re-uses an earlier
+//     epoch in phase 1.
+//
+// A value is chosen in an epoch once a phase 2 quorum of acceptors has
+// accepted it in that epoch, or, in the fast epoch of Fast Paxos, a fast
+// quorum. The monitor remembers the first violation, and
+// prints every violation to out.
+type monitor struct {
+	quorums QuorumSystem // which acceptors form quorums in each phase
+	sched   scheduler    // scheduler whose clock timestamps violations
+	out     io.Writer    // monitor prints violations to out
+
+	mu        sync.Mutex              // guards the fields below
+	states    map[int]AcceptorState   // last known state of each acceptor
+	accepted  map[string]map[int]bool // keys are proposals, then acceptors
+	chosen    map[string]proposal     // keys are epochs
+	proposed  map[string]proposal     // keys are epochs
+	owners    map[string]int          // proposer using each epoch
+	lastEpoch map[int]Epoch           // last epoch each proposer prepared
+	err       error                   // first violation, if any
+}
+
+// proposal is a value proposed or chosen in an epoch.
+type proposal struct {
+	epoch Epoch
+	value Value
+}
+
+// newMonitor returns a monitor for a run with the given quorum system.
+func newMonitor(s scheduler, quorums QuorumSystem, out io.Writer) *monitor {
+	return &monitor{
+		quorums:   quorums,
+		sched:     s,
+		out:       out,
+		states:    make(map[int]AcceptorState),
+		accepted:  make(map[string]map[int]bool),
+		chosen:    make(map[string]proposal),
+		proposed:  make(map[string]proposal),
+		owners:    make(map[string]int),
+		lastEpoch: make(map[int]Epoch),
+	}
+}
+
+// violated records a violation, described by the format and its arguments.
+func (m *monitor) violated(format string, a ...interface{}) {
+	err := fmt.Errorf("invariant violated at %s: %s", m.sched.now(),
+		fmt.Sprintf(format, a...))
+	fmt.Fprintf(m.out, "uh oh! %v\n", err)
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+// violation returns the first violation, or nil if there has been none.
+func (m *monitor) violation() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// sent observes the message msg sent from the node from to the node to,
+// whether or not the network delivers it. A nil monitor observes nothing.
+func (m *monitor) sent(from, to Node, msg Message) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := msg.(signed); ok {
+		msg = s.msg
+	}
+
+	switch msg := msg.(type) {
+	case prepare:
+		m.used(from.ID, msg.epoch, msg, to)
+		last, ok := m.lastEpoch[from.ID]
+		if ok && less(msg.epoch, last) {
+			m.violated("proposer %d sent %s to %s after preparing epoch %s",
+				from.ID, msg, to, last)
+		}
+		if !ok || less(last, msg.epoch) {
+			m.lastEpoch[from.ID] = msg.epoch
+		}
+	case anyValue:
+		m.used(from.ID, msg.epoch, msg, to)
+	case propose:
+		m.used(from.ID, msg.epoch, msg, to)
+		for _, c := range m.chosen {
+			if less(c.epoch, msg.epoch) && c.value != msg.value {
+				m.violated("proposer %d sent %s to %s, but value %s was chosen "+
+					"in earlier epoch %s", from.ID, msg, to, c.value, c.epoch)
+			}
+		}
+		if _, ok := m.proposed[msg.epoch.String()]; !ok {
+			m.proposed[msg.epoch.String()] = proposal{msg.epoch, msg.value}
+		}
+	}
+}
+
+// used records that the proposer numbered id used epoch in msg, sent to the
+// node to.
+func (m *monitor) used(id int, epoch Epoch, msg Message, to Node) {
+	owner, ok := m.owners[epoch.String()]
+	if !ok {
+		m.owners[epoch.String()] = id
+	} else if owner != id {
+		m.violated("proposer %d sent %s to %s, but proposer %d also uses epoch %s",
+			id, msg, to, owner, epoch)
+	}
+}
+
+// loaded observes that the acceptor numbered id recovered the state s.
+func (m *monitor) loaded(id int, s AcceptorState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prev, ok := m.states[id]; ok && less(s.PromisedEpoch, prev.PromisedEpoch) {
+		m.violated("acceptor %d recovered promised epoch %s, after promising "+
+			"epoch %s", id, s.PromisedEpoch, prev.PromisedEpoch)
+	}
+	m.states[id] = s
+}
+
+// restarted observes that the acceptor numbered id restarts without its
+// state, as if it were a new acceptor, so that the monitor forgets its
+// promised epoch. The proposals that it accepted before still count towards
+// the values chosen, since they were chosen whatever the acceptor forgets.
+func (m *monitor) restarted(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, id)
+}
+
+// saved observes that the acceptor numbered id saved the state s.
+func (m *monitor) saved(id int, s AcceptorState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.states[id]
+	m.states[id] = s
+
+	if less(s.PromisedEpoch, prev.PromisedEpoch) {
+		m.violated("acceptor %d promised epoch %s, after promising epoch %s",
+			id, s.PromisedEpoch, prev.PromisedEpoch)
+	}
+
+	if s.AcceptedEpoch.Nil() || (!less(s.AcceptedEpoch, prev.AcceptedEpoch) &&
+		!less(prev.AcceptedEpoch, s.AcceptedEpoch) &&
+		s.AcceptedValue == prev.AcceptedValue) {
+		return // accepted nothing new
+	}
+
+	p := proposal{s.AcceptedEpoch, s.AcceptedValue}
+	key := fmt.Sprintf("(%s, %s)", p.epoch, p.value)
+	if m.accepted[key] == nil {
+		m.accepted[key] = make(map[int]bool)
+	}
+	m.accepted[key][id] = true
+
+	if isChosen(m.quorums, p.epoch, m.accepted[key]) {
+		m.choose(p, id)
+	}
+}
+
+// choose records that the proposal p is chosen, once acceptor numbered id
+// accepted it.
+func (m *monitor) choose(p proposal, id int) {
+	if c, ok := m.chosen[p.epoch.String()]; ok {
+		if c.value != p.value {
+			m.violated("acceptor %d accepted (%s, %s), so value %s was chosen "+
+				"in epoch %s, as well as value %s", id, p.epoch, p.value, p.value,
+				p.epoch, c.value)
+		}
+		return
+	}
+	m.chosen[p.epoch.String()] = p
+
+	for _, q := range m.proposed {
+		if less(p.epoch, q.epoch) && q.value != p.value {
+			m.violated("acceptor %d accepted (%s, %s), so value %s was chosen "+
+				"in epoch %s, but value %s was proposed in later epoch %s", id,
+				p.epoch, p.value, p.value, p.epoch, q.value, q.epoch)
+		}
+	}
+}
+
+// less returns true if and only if the epoch e is less than the epoch f,
+// where the zero epoch is less than every other epoch.
+func less(e, f Epoch) bool {
+	if e.Nil() || f.Nil() {
+		return e.Nil() && !f.Nil()
+	}
+	return e.Cmp(f) < 0
+}
+
+// monitoredStorage is a Storage that tells a monitor the state that an
+// acceptor loads and saves.
+type monitoredStorage struct {
+	Storage
+	id      int      // acceptor identifier
+	monitor *monitor // monitor to tell
+}
+
+func (s monitoredStorage) Load() (AcceptorState, error) {
+	state, err := s.Storage.Load()
+	if err == nil {
+		s.monitor.loaded(s.id, state)
+	}
+	return state, err
+}
+
+func (s monitoredStorage) Save(state AcceptorState) error {
+	err := s.Storage.Save(state)
+	if err == nil {
+		s.monitor.saved(s.id, state)
+	}
+	return err
+}
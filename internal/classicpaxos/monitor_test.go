@@ -0,0 +1,158 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// newTestMonitor returns a monitor of 3 acceptors with majority quorums.
+func newTestMonitor() *monitor {
+	return newMonitor(newSimScheduler(), Majority{NAcceptors: 3}, io.Discard)
+}
+
+// checkViolation fails the test unless m has recorded a violation that
+// mentions want.
+func checkViolation(t *testing.T, m *monitor, want string) {
+	t.Helper()
+	err := m.violation()
+	if err == nil {
+		t.Errorf("got no violation, want one mentioning %q", want)
+	} else if !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want a violation mentioning %q", err, want)
+	}
+}
+
+func TestThatMonitorAcceptsAClassicRound(t *testing.T) {
+	m := newTestMonitor()
+	e := newEpoch(1, 2)
+
+	for a := 0; a < 3; a++ {
+		m.sent(ProposerNode(1), AcceptorNode(a), prepare{epoch: e, proposerID: 1})
+		m.saved(a, AcceptorState{PromisedEpoch: e})
+	}
+	for a := 0; a < 3; a++ {
+		m.sent(ProposerNode(1), AcceptorNode(a),
+			propose{epoch: e, value: v1, proposerID: 1})
+		m.saved(a, AcceptorState{PromisedEpoch: e, AcceptedEpoch: e,
+			AcceptedValue: v1})
+	}
+
+	// a later round that adopts the chosen value is also fine
+	f := newEpoch(2, 2)
+	m.sent(ProposerNode(0), AcceptorNode(0), prepare{epoch: f})
+	m.sent(ProposerNode(0), AcceptorNode(0), propose{epoch: f, value: v1})
+
+	if err := m.violation(); err != nil {
+		t.Errorf("got %v, want no violation", err)
+	}
+}
+
+func TestThatMonitorCatchesPromisedEpochRegressing(t *testing.T) {
+	m := newTestMonitor()
+	m.saved(2, AcceptorState{PromisedEpoch: newEpoch(3, 2)})
+	m.saved(2, AcceptorState{PromisedEpoch: newEpoch(1, 2)})
+	checkViolation(t, m, "acceptor 2 promised epoch 1, after promising epoch 3")
+
+	m = newTestMonitor()
+	m.saved(0, AcceptorState{PromisedEpoch: newEpoch(3, 2)})
+	m.loaded(0, AcceptorState{})
+	checkViolation(t, m, "acceptor 0 recovered promised epoch nil")
+}
+
+func TestThatMonitorCatchesTwoValuesChosenInAnEpoch(t *testing.T) {
+	m := newTestMonitor()
+	e := newEpoch(1, 2)
+	for a := 0; a < 2; a++ {
+		m.saved(a, AcceptorState{PromisedEpoch: e, AcceptedEpoch: e,
+			AcceptedValue: stringValue("x")})
+	}
+	for a := 1; a < 3; a++ {
+		m.saved(a, AcceptorState{PromisedEpoch: e, AcceptedEpoch: e,
+			AcceptedValue: stringValue("y")})
+	}
+	checkViolation(t, m, "value y was chosen in epoch 1, as well as value x")
+}
+
+func TestThatMonitorCatchesALaterProposalOfAnotherValue(t *testing.T) {
+	e, f := newEpoch(1, 2), newEpoch(2, 2)
+
+	// the proposal follows the choice
+	m := newTestMonitor()
+	for a := 0; a < 2; a++ {
+		m.saved(a, AcceptorState{PromisedEpoch: e, AcceptedEpoch: e,
+			AcceptedValue: stringValue("x")})
+	}
+	m.sent(ProposerNode(0), AcceptorNode(2),
+		propose{epoch: f, value: stringValue("y"), proposerID: 0})
+	checkViolation(t, m, "value x was chosen in earlier epoch 1")
+
+	// the choice follows the proposal
+	m = newTestMonitor()
+	m.sent(ProposerNode(0), AcceptorNode(2),
+		propose{epoch: f, value: stringValue("y"), proposerID: 0})
+	for a := 0; a < 2; a++ {
+		m.saved(a, AcceptorState{PromisedEpoch: e, AcceptedEpoch: e,
+			AcceptedValue: stringValue("x")})
+	}
+	checkViolation(t, m, "value y was proposed in later epoch 2")
+}
+
+func TestThatMonitorCatchesSharedAndReusedEpochs(t *testing.T) {
+	m := newTestMonitor()
+	e := newEpoch(1, 2)
+	m.sent(ProposerNode(1), AcceptorNode(0), prepare{epoch: e, proposerID: 1})
+	m.sent(ProposerNode(0), AcceptorNode(0), prepare{epoch: e, proposerID: 0})
+	checkViolation(t, m, "proposer 1 also uses epoch 1")
+
+	m = newTestMonitor()
+	m.sent(ProposerNode(1), AcceptorNode(0),
+		prepare{epoch: newEpoch(3, 2), proposerID: 1})
+	m.sent(ProposerNode(1), AcceptorNode(0),
+		prepare{epoch: newEpoch(1, 2), proposerID: 1})
+	checkViolation(t, m, "after preparing epoch 3")
+}
+
+func TestThatMonitorForgetsAcceptorsThatRestartAfresh(t *testing.T) {
+	e := newEpoch(1, 2)
+	m := newTestMonitor()
+	for a := 0; a < 2; a++ {
+		m.saved(a, AcceptorState{PromisedEpoch: e, AcceptedEpoch: e,
+			AcceptedValue: stringValue("x")})
+	}
+	m.restarted(0)
+	m.saved(0, AcceptorState{})
+	if err := m.violation(); err != nil {
+		t.Fatalf("got %v, want no violation", err)
+	}
+
+	// the value chosen before the restart stays chosen
+	m.sent(ProposerNode(0), AcceptorNode(0),
+		propose{epoch: newEpoch(2, 2), value: stringValue("y"), proposerID: 0})
+	checkViolation(t, m, "value x was chosen in earlier epoch 1")
+}
+
+func TestThatRunReportsTheStepThatBrokeAgreement(t *testing.T) {
+	c := crashMajority(3, true)
+	err := c.Run()
+
+	want := "proposer 2 sent propose(22, v2) from proposer 2 to a0, but " +
+		"value v4 was chosen in earlier epoch 20"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want an error mentioning %q", err, want)
+	}
+}
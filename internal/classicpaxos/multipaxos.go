@@ -0,0 +1,658 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Multi-Paxos decides a log of commands rather than a single value, by running
+// an instance of Classic Paxos for each slot of the log. Its advantage over
+// running the instances independently is that a single prepare message covers
+// every slot from some slot onward. Once a proposer has received promises for
+// such a prepare, it is the leader: it may skip phase 1 for every later
+// command, and needs just one round trip to decide each one, until another
+// proposer preempts it with a greater epoch.
+
+// LogEntry is an entry of the log: a command, or a no-op, which a new leader
+// proposes for a slot in which it found no accepted proposal, but after which
+// some command was accepted. A no-op is marked as such, rather than by its
+// command, so that no command appended to the log can be mistaken for one.
+type LogEntry struct {
+	Command string // command, if the entry is not a no-op
+	NoOp    bool   // whether the entry is a no-op
+}
+
+// String returns the string form of a log entry: its command, or "no-op".
+func (e LogEntry) String() string {
+	if e.NoOp {
+		return "no-op"
+	}
+	return e.Command
+}
+
+// proposal returns the value and the no-op mark with which e is proposed.
+func (e LogEntry) proposal() (Value, bool) {
+	if e.NoOp {
+		return Value{}, true
+	}
+	return stringValue(e.Command), false
+}
+
+// proposedEntry returns the log entry proposed with value and the no-op mark
+// noop.
+func proposedEntry(value Value, noop bool) LogEntry {
+	if noop {
+		return LogEntry{NoOp: true}
+	}
+	return LogEntry{Command: string(value.Bytes())}
+}
+
+// slotProposal is a proposal (epoch, value) for a slot of the log, where the
+// value is nil for a no-op.
+type slotProposal struct {
+	slot  int
+	epoch Epoch
+	value Value
+	noop  bool
+}
+
+// String returns the string form of a slot proposal.
+func (p slotProposal) String() string {
+	return fmt.Sprintf("%d:(%s, %s)", p.slot, p.epoch,
+		proposedEntry(p.value, p.noop))
+}
+
+// logPrepare is the message sent by a proposer in phase 1 of Multi-Paxos. It
+// prepares every slot from slot onward.
+type logPrepare struct {
+	prepare
+	slot int
+}
+
+// String returns the string form of a logPrepare message.
+func (p logPrepare) String() string {
+	return fmt.Sprintf("prepare(%s) for slots from %d from proposer %d",
+		p.epoch, p.slot, p.proposerID)
+}
+
+// logPromise is the message sent by an acceptor in phase 1 of Multi-Paxos. It
+// holds the last proposal that the acceptor accepted in each slot from the
+// prepared slot onward.
+type logPromise struct {
+	epoch      Epoch
+	accepted   []slotProposal // in increasing order of slot
+	acceptorID int
+}
+
+// String returns the string form of a logPromise message.
+func (p logPromise) String() string {
+	return fmt.Sprintf("promise(%s, %v) from acceptor %d", p.epoch, p.accepted,
+		p.acceptorID)
+}
+
+// logPropose is the message sent by a proposer in phase 2 of Multi-Paxos. Its
+// value is nil if it proposes a no-op.
+type logPropose struct {
+	propose
+	slot int
+	noop bool
+}
+
+// String returns the string form of a logPropose message.
+func (p logPropose) String() string {
+	return fmt.Sprintf("propose(%s, %s) for slot %d from proposer %d",
+		p.epoch, proposedEntry(p.value, p.noop), p.slot, p.proposerID)
+}
+
+// logAccept is the message sent by an acceptor in phase 2 of Multi-Paxos.
+type logAccept struct {
+	accept
+	slot int
+}
+
+// String returns the string form of a logAccept message.
+func (a logAccept) String() string {
+	return fmt.Sprintf("accept(%s) for slot %d from acceptor %d", a.epoch,
+		a.slot, a.acceptorID)
+}
+
+// command is the message by which a Log asks a proposer to append a command.
+type command struct {
+	value string
+}
+
+// String returns the string form of a command message.
+func (c command) String() string {
+	return fmt.Sprintf("command(%s)", c.value)
+}
+
+// decision is the message by which a proposer tells a Log that an entry was
+// decided for a slot.
+type decision struct {
+	slot  int
+	entry LogEntry
+}
+
+// logAcceptor represents the acceptor role in Multi-Paxos. It keeps its state
+// in memory.
+type logAcceptor struct {
+	input channel   // input channel
+	net   *network  // for replying to proposers
+	id    int       // acceptor identifier
+	trace *tracer   // acceptor traces the messages it receives
+	out   io.Writer // acceptor prints its progress to out
+}
+
+// newLogAcceptor creates a logAcceptor with the given id, input channel,
+// network and tracer, and starts its goroutine using the scheduler s.
+func newLogAcceptor(s scheduler, id int, input channel, net *network,
+	trace *tracer, out io.Writer) *logAcceptor {
+
+	a := &logAcceptor{input: input, net: net, id: id, trace: trace, out: out}
+	s.spawn(a.run)
+	return a
+}
+
+// run is the acceptor algorithm for Classic Paxos, with one promisedEpoch for
+// the whole log, but an accepted proposal for each slot. It returns once its
+// input channel is closed.
+func (a *logAcceptor) run() {
+	var promisedEpoch Epoch
+	accepted := make(map[int]slotProposal) // keys are slots
+
+	for {
+		m := a.input.receive()
+		if m == nil {
+			return
+		}
+
+		fmt.Fprintf(a.out, "acceptor %d received message %s\n", a.id, m)
+		a.trace.arrival(EventReceived, AcceptorNode(a.id), m, "")
+
+		switch msg := m.(type) {
+		case logPrepare:
+			epoch := msg.epoch
+			if promisedEpoch.Nil() || epoch.Cmp(promisedEpoch) >= 0 {
+				promisedEpoch = epoch
+
+				var proposals []slotProposal
+				for slot, p := range accepted {
+					if slot >= msg.slot {
+						proposals = append(proposals, p)
+					}
+				}
+				sort.Slice(proposals, func(i, j int) bool {
+					return proposals[i].slot < proposals[j].slot
+				})
+
+				a.reply(msg.proposerID, logPromise{epoch: epoch,
+					accepted: proposals, acceptorID: a.id})
+			}
+		case logPropose:
+			epoch := msg.epoch
+			if promisedEpoch.Nil() || epoch.Cmp(promisedEpoch) >= 0 {
+				promisedEpoch = epoch
+				accepted[msg.slot] = slotProposal{slot: msg.slot, epoch: epoch,
+					value: msg.value, noop: msg.noop}
+				a.reply(msg.proposerID, logAccept{
+					accept: accept{acceptorID: a.id, epoch: epoch}, slot: msg.slot})
+			}
+		case Crash:
+			r, ok := a.awaitRestart()
+			if !ok {
+				return
+			}
+			if r.loseState {
+				promisedEpoch = Epoch{}
+				accepted = make(map[int]slotProposal)
+			}
+		}
+	}
+}
+
+// awaitRestart loses every message sent to the crashed acceptor until it
+// receives a restart message, and returns the message and true; or returns
+// false if its input channel is closed first.
+func (a *logAcceptor) awaitRestart() (Restart, bool) {
+	for {
+		m := a.input.receive()
+		if m == nil {
+			return Restart{}, false
+		}
+
+		if msg, ok := m.(Restart); ok {
+			fmt.Fprintf(a.out, "acceptor %d received message %s\n", a.id, msg)
+			a.trace.arrival(EventReceived, AcceptorNode(a.id), msg, "")
+			return msg, true
+		}
+
+		fmt.Fprintf(a.out, "acceptor %d is down, lost message %s\n", a.id, m)
+		a.trace.arrival(EventDropped, AcceptorNode(a.id), m, "acceptor down")
+	}
+}
+
+// reply sends m to the proposer numbered proposerID.
+func (a *logAcceptor) reply(proposerID int, m Message) {
+	a.net.Send(AcceptorNode(a.id), ProposerNode(proposerID), m)
+}
+
+// logProposer represents the proposer role in Multi-Paxos. It becomes the
+// leader when it receives its first command, and remains the leader until it
+// times out waiting for accept messages, which happens if another proposer
+// preempts it.
+type logProposer struct {
+	input      channel        // input channel
+	net        *network       // for sending to acceptors
+	id         int            // proposer identifier
+	nProposers int            // number of proposers
+	epochs     EpochScheme    // which epochs the proposer uses
+	nAcceptors int            // number of acceptors
+	quorums    QuorumSystem   // which acceptors form quorums in each phase
+	timeout    time.Duration  // time to wait for promise and accept messages
+	decisions  channel        // proposer places decided commands on this channel
+	fault      *ProposerFault // when proposer crashes, or nil if it never does
+	sched      scheduler      // scheduler whose clock determines when to crash
+	trace      *tracer        // proposer traces its progress
+	out        io.Writer      // proposer prints its progress to out
+
+	round     int                  // number of times proposer has started phase 1
+	epoch     Epoch                // epoch of the current round
+	leading   bool                 // whether phase 1 of the current round is done
+	queue     []string             // commands received before leading
+	proposals map[int]LogEntry     // undecided slots, and the proposed entries
+	accepted  map[int]map[int]bool // keys are slots, then acceptors that accepted
+	decided   map[int]bool         // keys are the slots known to be decided
+	nextSlot  int                  // slot for the next command
+	prepares  int                  // number of times phase 1 has succeeded
+	stopped   bool                 // whether the input channel is closed
+}
+
+// newLogProposer creates a logProposer with the given parameters and starts its
+// goroutine using the scheduler s.
+func newLogProposer(s scheduler, id, nProposers int,
+	epochs EpochScheme,
+	input channel,
+	net *network,
+	nAcceptors int,
+	quorums QuorumSystem,
+	timeout time.Duration,
+	decisions channel,
+	fault *ProposerFault,
+	trace *tracer,
+	out io.Writer) *logProposer {
+
+	p := &logProposer{
+		input:      input,
+		net:        net,
+		id:         id,
+		nProposers: nProposers,
+		epochs:     epochs,
+		nAcceptors: nAcceptors,
+		quorums:    quorums,
+		timeout:    timeout,
+		decisions:  decisions,
+		fault:      fault,
+		sched:      s,
+		trace:      trace,
+		out:        out,
+		proposals:  make(map[int]LogEntry),
+		decided:    make(map[int]bool),
+	}
+	s.spawn(p.run)
+	return p
+}
+
+// run runs the proposer: it waits for a command, runs phase 1 until it leads,
+// and then runs phase 2 for each command until it is preempted, at which point
+// it runs phase 1 again. run returns once the input channel is closed.
+func (p *logProposer) run() {
+	for {
+		for len(p.queue) == 0 && len(p.proposals) == 0 {
+			m := p.input.receive()
+			if m == nil {
+				return
+			}
+			fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, m)
+			p.trace.arrival(EventReceived, ProposerNode(p.id), m, "")
+			if c, ok := m.(command); ok {
+				p.queue = append(p.queue, c.value)
+			}
+		}
+
+		if !p.lead() {
+			if p.stopped {
+				return
+			}
+			continue
+		}
+
+		// start phase 2 for the proposals adopted in phase 1, and then for the
+		// commands received meanwhile
+		slots := make([]int, 0, len(p.proposals))
+		for slot := range p.proposals {
+			slots = append(slots, slot)
+		}
+		sort.Ints(slots)
+		for _, slot := range slots {
+			if !p.propose(slot) {
+				return
+			}
+		}
+		for len(p.queue) > 0 {
+			if !p.appendCommand() {
+				return
+			}
+		}
+
+		// skip phase 1 for later commands, while still the leader
+		for p.leading {
+			var m Message
+			if len(p.proposals) > 0 {
+				var ok bool
+				if m, ok = p.input.receiveTimeout(p.timeout); !ok {
+					p.trace.step(EventTimeout, ProposerNode(p.id), p.epoch, "")
+					p.leading = false // perhaps preempted
+					break
+				}
+			} else {
+				m = p.input.receive()
+			}
+			if m == nil {
+				return
+			}
+
+			fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, m)
+			p.trace.arrival(EventReceived, ProposerNode(p.id), m, "")
+
+			switch msg := m.(type) {
+			case command:
+				p.queue = append(p.queue, msg.value)
+				if !p.appendCommand() {
+					return
+				}
+			case logAccept:
+				p.handleAccept(msg)
+			}
+		}
+	}
+}
+
+// lead runs phase 1 for every slot from the first slot not known to be
+// decided. It adopts the proposal with the greatest epoch among the promises
+// for each slot, and returns true, once a phase 1 quorum has promised;
+// or returns false if it times out or its input channel is closed first.
+func (p *logProposer) lead() bool {
+	p.epoch = p.epochs.epoch(p.round, p.id, p.nProposers)
+	p.round++
+	p.leading = false
+	p.trace.step(EventEpoch, ProposerNode(p.id), p.epoch, "")
+
+	first := 0
+	for p.decided[first] {
+		first++
+	}
+
+	for a := 0; a < p.nAcceptors; a++ {
+		p.send(a, logPrepare{prepare: prepare{epoch: p.epoch, proposerID: p.id},
+			slot: first})
+	}
+
+	// keys are acceptors that have promised
+	promisedAcceptors := make(map[int]bool)
+	greatest := make(map[int]slotProposal) // greatest proposal for each slot
+
+	for !p.quorums.IsPhase1Quorum(promisedAcceptors) {
+		m, ok := p.input.receiveTimeout(p.timeout)
+		if !ok {
+			p.trace.step(EventTimeout, ProposerNode(p.id), p.epoch, "")
+			return false
+		}
+		if m == nil {
+			p.stopped = true
+			return false
+		}
+
+		fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, m)
+		p.trace.arrival(EventReceived, ProposerNode(p.id), m, "")
+
+		switch msg := m.(type) {
+		case command:
+			p.queue = append(p.queue, msg.value)
+		case logPromise:
+			if msg.epoch.Cmp(p.epoch) != 0 {
+				continue
+			}
+			promisedAcceptors[msg.acceptorID] = true
+			for _, proposal := range msg.accepted {
+				g, ok := greatest[proposal.slot]
+				if !ok || proposal.epoch.Cmp(g.epoch) > 0 {
+					greatest[proposal.slot] = proposal
+				}
+			}
+		}
+	}
+
+	for slot := range greatest {
+		if slot >= p.nextSlot {
+			p.nextSlot = slot + 1
+		}
+	}
+
+	for slot := first; slot < p.nextSlot; slot++ {
+		if p.decided[slot] {
+			continue
+		}
+		if g, ok := greatest[slot]; ok {
+			p.proposals[slot] = proposedEntry(g.value, g.noop)
+		} else if _, ok := p.proposals[slot]; !ok {
+			p.proposals[slot] = LogEntry{NoOp: true}
+		}
+	}
+
+	p.accepted = make(map[int]map[int]bool)
+	p.leading = true
+	p.prepares++
+
+	return true
+}
+
+// appendCommand proposes the first queued command for the next slot. It
+// returns false if the proposer crashes.
+func (p *logProposer) appendCommand() bool {
+	slot := p.nextSlot
+	p.nextSlot++
+	p.proposals[slot] = LogEntry{Command: p.queue[0]}
+	p.queue = p.queue[1:]
+	return p.propose(slot)
+}
+
+// propose sends propose messages for the slot to the acceptors. It returns
+// false if the proposer crashes midway.
+func (p *logProposer) propose(slot int) bool {
+	crashing := p.fault != nil && p.sched.now() >= p.fault.CrashAt
+	n := p.nAcceptors
+	if crashing {
+		n = (n + 1) / 2
+	}
+
+	value, noop := p.proposals[slot].proposal()
+	for a := 0; a < n; a++ {
+		p.send(a, logPropose{propose: propose{epoch: p.epoch, value: value,
+			proposerID: p.id}, slot: slot, noop: noop})
+	}
+
+	if crashing {
+		fmt.Fprintf(p.out,
+			"proposer %d crashed after proposing to %d acceptor/s\n", p.id, n)
+		return false
+	}
+	return true
+}
+
+// handleAccept records an accept message, and decides the slot once a phase 2
+// quorum has accepted the proposal for it.
+func (p *logProposer) handleAccept(msg logAccept) {
+	entry, ok := p.proposals[msg.slot]
+	if !ok || msg.epoch.Cmp(p.epoch) != 0 {
+		return
+	}
+
+	if p.accepted[msg.slot] == nil {
+		p.accepted[msg.slot] = make(map[int]bool)
+	}
+	p.accepted[msg.slot][msg.acceptorID] = true
+
+	if !p.quorums.IsPhase2Quorum(p.accepted[msg.slot]) {
+		return
+	}
+
+	fmt.Fprintf(p.out, "proposer %d believes entry %s is decided for slot %d\n",
+		p.id, entry, msg.slot)
+	p.trace.decidedSlot(ProposerNode(p.id), p.epoch, msg.slot, entry.String())
+
+	delete(p.proposals, msg.slot)
+	delete(p.accepted, msg.slot)
+	p.decided[msg.slot] = true
+	p.decisions.send(decision{slot: msg.slot, entry: entry})
+}
+
+// send sends m to the acceptor numbered acceptorID.
+func (p *logProposer) send(acceptorID int, m Message) {
+	p.net.Send(ProposerNode(p.id), AcceptorNode(acceptorID), m)
+}
+
+// Log is a replicated log of commands decided by Multi-Paxos.
+type Log struct {
+	proposers []channel        // input channels of the proposers
+	leader    int              // proposer to which commands are appended
+	decisions channel          // on which proposers place decided entries
+	entries   map[int]LogEntry // decided entries, by slot
+	err       error            // first disagreement about a slot, if any
+	out       io.Writer
+}
+
+// Append asks the leader to append the command value to the log. It does not
+// wait for the command to be decided. If the leader is preempted before a
+// majority of acceptors accept the command, the command may be lost.
+func (l *Log) Append(value string) {
+	l.proposers[l.leader].send(command{value: value})
+}
+
+// SetLeader makes the proposer numbered id the leader, to which Append appends
+// later commands.
+func (l *Log) SetLeader(id int) {
+	l.leader = id
+}
+
+// Read waits until an entry is decided for the slot, and returns it. If the
+// run stops first, Read returns the zero LogEntry.
+func (l *Log) Read(slot int) LogEntry {
+	for {
+		if entry, ok := l.entries[slot]; ok {
+			return entry
+		}
+
+		m := l.decisions.receive()
+		if m == nil {
+			return LogEntry{}
+		}
+		d := m.(decision)
+		if entry, ok := l.entries[d.slot]; !ok {
+			l.entries[d.slot] = d.entry
+		} else if entry != d.entry && l.err == nil {
+			fmt.Fprintf(l.out, "uh oh! slot %d was decided as both %s and %s\n",
+				d.slot, entry, d.entry)
+			l.err = fmt.Errorf("slot %d was decided as both %s and %s", d.slot,
+				entry, d.entry)
+		}
+	}
+}
+
+// RunLog runs Multi-Paxos for the scenario given by the configuration c. It
+// calls f with the replicated log, and returns when f returns. Since f runs on
+// c's scheduler, it must use only the log to wait. RunLog returns a non-nil
+// error if the proposers decided different commands for the same slot.
+//
+// Multi-Paxos acceptors keep their state in memory, so c.WALDir must be empty.
+func (c *Config) RunLog(f func(l *Log)) error {
+	if c.WALDir != "" {
+		return fmt.Errorf("Multi-Paxos acceptors do not support a WALDir")
+	}
+	if c.Fast {
+		return fmt.Errorf("Multi-Paxos does not support Fast Paxos")
+	}
+	if c.Byzantine || len(c.MaliciousAcceptors) > 0 {
+		return fmt.Errorf("Multi-Paxos does not support Byzantine Paxos")
+	}
+	if c.NAuxiliary > 0 {
+		return fmt.Errorf("Multi-Paxos does not support Cheap Paxos")
+	}
+	if c.reconfigures() {
+		return fmt.Errorf("Multi-Paxos does not support reconfigurations")
+	}
+	r, err := c.newRun()
+	if err != nil {
+		return err
+	}
+
+	l := &Log{
+		proposers: make([]channel, r.NProposers),
+		entries:   make(map[int]LogEntry),
+		out:       r.output(),
+	}
+
+	err = r.sched.run(context.Background(), func() {
+		// 1. create acceptors
+		for i := 0; i < r.NAcceptors; i++ {
+			lc := r.newLossyChannel(AcceptorNode(i))
+			r.net.connect(AcceptorNode(i), lc.input)
+			newLogAcceptor(r.sched, i, lc.output, r.net, r.trace, r.output())
+
+			for _, fault := range r.AcceptorFaults {
+				if fault.Acceptor == i {
+					r.injectAcceptorFault(fault, lc.output)
+				}
+			}
+		}
+
+		// 2. create proposers
+		l.decisions = r.sched.newChannel(r.NProposers)
+		faults := r.proposerFaults()
+		for i := 0; i < r.NProposers; i++ {
+			lc := r.newLossyChannel(ProposerNode(i))
+			r.net.connect(ProposerNode(i), lc.input)
+			newLogProposer(r.sched, i, r.NProposers, r.Epochs, lc.output,
+				r.net, r.NAcceptors, r.quorums(), r.ProposerTimeout,
+				l.decisions, faults[i], r.trace, r.output())
+			l.proposers[i] = lc.output
+		}
+
+		// 3. let f append to and read from the log
+		f(l)
+	})
+
+	if err != nil {
+		return err
+	}
+	if l.err != nil {
+		return l.err
+	}
+	return r.trace.err()
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestThatStableLeaderPreparesOnce tests that a leader decides a sequence of
+// commands, in order, after running phase 1 just once.
+func TestThatStableLeaderPreparesOnce(t *testing.T) {
+	var output bytes.Buffer
+
+	c := Config{NProposers: 3, NAcceptors: 5,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          1, Drop: 0, Seed: 1, Output: &output}
+
+	var got []LogEntry
+
+	err := c.RunLog(func(l *Log) {
+		for i := 0; i < 20; i++ {
+			l.Append(fmt.Sprintf("c%d", i))
+		}
+		for slot := 0; slot < 20; slot++ {
+			got = append(got, l.Read(slot))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for slot, value := range got {
+		want := LogEntry{Command: fmt.Sprintf("c%d", slot)}
+		if value != want {
+			t.Errorf("slot %d holds %s, want %s", slot, value, want)
+		}
+	}
+
+	prepares := strings.Count(output.String(), "received message prepare(")
+	if prepares != c.NAcceptors {
+		t.Errorf("acceptors received %d prepare messages, want %d", prepares,
+			c.NAcceptors)
+	}
+}
+
+// TestThatNewLeaderPreservesLog tests that when the leader changes, the new
+// leader preserves the commands already decided, under message loss and
+// reordering.
+func TestThatNewLeaderPreservesLog(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		c := Config{NProposers: 3, NAcceptors: 5,
+			ProposerTimeout: 100 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond,
+			Buffer:          2, Drop: 0.1, Seed: seed, Output: io.Discard}
+
+		var got []LogEntry
+
+		err := c.RunLog(func(l *Log) {
+			for leader := 0; leader < c.NProposers; leader++ {
+				l.SetLeader(leader)
+				for i := 0; i < 5; i++ {
+					l.Append(fmt.Sprintf("c%d", len(got)+i))
+				}
+				for i := 0; i < 5; i++ {
+					got = append(got, l.Read(len(got)))
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("with seed %d, got %v", seed, err)
+		}
+
+		for slot, value := range got {
+			want := LogEntry{Command: fmt.Sprintf("c%d", slot)}
+			if value != want {
+				t.Errorf("with seed %d, slot %d holds %s, want %s", seed, slot,
+					value, want)
+			}
+		}
+	}
+}
+
+// TestThatNoOpCommandsAreNotNoOps tests that a command that reads "no-op" is
+// decided as a command, not as a no-op filling a gap in the log.
+func TestThatNoOpCommandsAreNotNoOps(t *testing.T) {
+	for _, entry := range []LogEntry{{Command: "no-op"}, {NoOp: true}} {
+		if got := proposedEntry(entry.proposal()); got != entry {
+			t.Errorf("entry %+v was proposed as %+v", entry, got)
+		}
+	}
+
+	c := Config{NProposers: 1, NAcceptors: 3,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          1, Seed: 1, Output: io.Discard}
+
+	var got LogEntry
+	err := c.RunLog(func(l *Log) {
+		l.Append("no-op")
+		got = l.Read(0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LogEntry{Command: "no-op"}); got != want {
+		t.Errorf("slot 0 holds %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Role is the role of a participant in Classic Paxos.
+type Role int
+
+const (
+	RoleProposer Role = iota // proposer role
+	RoleAcceptor             // acceptor role
+	RoleLearner              // learner role
+	RoleReplica              // replica role in EPaxos, or server role in Raft
+)
+
+// Node identifies a participant in a run by its role and its identifier within
+// that role.
+type Node struct {
+	Role Role
+	ID   int
+}
+
+// ProposerNode returns the node for the proposer numbered id.
+func ProposerNode(id int) Node {
+	return Node{Role: RoleProposer, ID: id}
+}
+
+// AcceptorNode returns the node for the acceptor numbered id.
+func AcceptorNode(id int) Node {
+	return Node{Role: RoleAcceptor, ID: id}
+}
+
+// LearnerNode returns the node for the learner numbered id.
+func LearnerNode(id int) Node {
+	return Node{Role: RoleLearner, ID: id}
+}
+
+// ReplicaNode returns the node for the EPaxos replica or Raft server numbered
+// id.
+func ReplicaNode(id int) Node {
+	return Node{Role: RoleReplica, ID: id}
+}
+
+// String returns the string form of a node: "p" for a proposer, "a" for an
+// acceptor, "l" for a learner, or "r" for a replica, followed by its
+// identifier.
+func (n Node) String() string {
+	switch n.Role {
+	case RoleProposer:
+		return fmt.Sprintf("p%d", n.ID)
+	case RoleAcceptor:
+		return fmt.Sprintf("a%d", n.ID)
+	case RoleReplica:
+		return fmt.Sprintf("r%d", n.ID)
+	}
+	return fmt.Sprintf("l%d", n.ID)
+}
+
+// MarshalText returns the string form of n, so that n encodes as a string.
+func (n Node) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText sets n to the node whose string form is text.
+func (n *Node) UnmarshalText(text []byte) error {
+	node, err := ParseNode(string(text))
+	if err != nil {
+		return err
+	}
+	*n = node
+	return nil
+}
+
+// ParseNode returns the node whose string form is s.
+func ParseNode(s string) (Node, error) {
+	malformed := fmt.Errorf("node %q is not of the form pID, aID, lID or rID",
+		s)
+
+	if len(s) < 2 {
+		return Node{}, malformed
+	}
+
+	id, err := strconv.Atoi(s[1:])
+	if err != nil {
+		return Node{}, malformed
+	}
+
+	switch s[0] {
+	case 'p':
+		return ProposerNode(id), nil
+	case 'a':
+		return AcceptorNode(id), nil
+	case 'l':
+		return LearnerNode(id), nil
+	case 'r':
+		return ReplicaNode(id), nil
+	}
+	return Node{}, malformed
+}
+
+// Partition schedules a partition of the network into groups of nodes. While
+// the partition lasts, every message between nodes in different groups is
+// lost. The nodes that appear in no group together form one more group.
+type Partition struct {
+	// groups of nodes that can communicate only with each other
+	Groups [][]Node
+
+	// time since the start of the run at which the partition begins
+	Start time.Duration
+
+	// time since the start of the run at which the partition heals; if zero,
+	// the partition never heals
+	Heal time.Duration
+}
+
+// String returns the string form of a partition.
+func (p Partition) String() string {
+	s := fmt.Sprintf("partition %v from %s", p.Groups, p.Start)
+	if p.Heal > 0 {
+		s += fmt.Sprintf(" until %s", p.Heal)
+	}
+	return s
+}
+
+// group returns the index of the group of p that contains n, or len(p.Groups)
+// if no group contains n.
+func (p Partition) group(n Node) int {
+	for i, group := range p.Groups {
+		for _, m := range group {
+			if m == n {
+				return i
+			}
+		}
+	}
+	return len(p.Groups)
+}
+
+// LinkCut schedules the loss of every message sent from one node to another,
+// but not of the messages sent in the opposite direction.
+type LinkCut struct {
+	// sender and receiver of the lost messages
+	From, To Node
+
+	// time since the start of the run at which the link is cut
+	Start time.Duration
+
+	// time since the start of the run at which the link heals; if zero, the
+	// link never heals
+	Heal time.Duration
+}
+
+// String returns the string form of a link cut.
+func (l LinkCut) String() string {
+	s := fmt.Sprintf("link %s->%s cut from %s", l.From, l.To, l.Start)
+	if l.Heal > 0 {
+		s += fmt.Sprintf(" until %s", l.Heal)
+	}
+	return s
+}
+
+// during returns true if and only if the time t is within the interval that
+// starts at start and ends at heal, where a zero heal never ends.
+func during(t, start, heal time.Duration) bool {
+	return t >= start && (heal == 0 || t < heal)
+}
+
+// Transport carries messages between participants in Classic Paxos. A
+// Transport may lose, delay or reorder messages. On arrival, it delivers a
+// message to the participant at the receiving node by calling the
+// participant's Deliver method.
+type Transport interface {
+	// Send sends m from the node from to the node to.
+	Send(from, to Node, m Message)
+}
+
+// inboxSize is the number of messages that a participant created by a public
+// constructor buffers before Deliver loses messages.
+const inboxSize = 64
+
+// orDiscard returns out, or io.Discard if out is nil.
+func orDiscard(out io.Writer) io.Writer {
+	if out == nil {
+		return io.Discard
+	}
+	return out
+}
+
+// network is the Transport that carries messages between nodes in a run. It
+// sits in front of the nodes' lossy channels, and loses the messages that it
+// cannot deliver because of a partition or a cut link at the time they are
+// sent.
+type network struct {
+	inputs     map[Node]channel // input channels of the nodes' lossy channels
+	partitions []Partition
+	cuts       []LinkCut
+	sched      scheduler        // scheduler whose clock determines the partitions
+	trace      *tracer          // network traces sent and lost messages
+	monitor    *monitor         // network tells monitor the messages sent
+	metrics    *metricsRecorder // network counts the messages sent
+	out        io.Writer        // network prints lost messages to out
+}
+
+// newNetwork returns a network with no nodes connected to it.
+func newNetwork(s scheduler, partitions []Partition, cuts []LinkCut,
+	trace *tracer, monitor *monitor, metrics *metricsRecorder,
+	out io.Writer) *network {
+
+	return &network{
+		inputs:     make(map[Node]channel),
+		partitions: partitions,
+		cuts:       cuts,
+		sched:      s,
+		trace:      trace,
+		monitor:    monitor,
+		metrics:    metrics,
+		out:        out,
+	}
+}
+
+// connect connects node n to the network, so that messages sent to n are
+// placed on input.
+func (n *network) connect(node Node, input channel) {
+	n.inputs[node] = input
+}
+
+// Send sends m from the node from to the node to, unless the network cannot
+// currently carry messages between them, or the input channel of the node to
+// is full.
+func (n *network) Send(from, to Node, m Message) {
+	n.trace.message(EventSent, from, to, m, "")
+	n.monitor.sent(from, to, m)
+	n.metrics.sent()
+
+	var detail string
+	if !n.connected(from, to) {
+		detail = "network partitioned or link cut"
+	} else if !n.inputs[to].trySend(m) {
+		detail = "inbox full"
+	} else {
+		return
+	}
+
+	fmt.Fprintf(n.out, "network lost message %s from %s to %s\n", m, from, to)
+	n.trace.message(EventDropped, to, from, m, detail)
+}
+
+// connected returns true if and only if the network can currently carry
+// messages from the node from to the node to.
+func (n *network) connected(from, to Node) bool {
+	now := n.sched.now()
+
+	for _, p := range n.partitions {
+		if during(now, p.Start, p.Heal) && p.group(from) != p.group(to) {
+			return false
+		}
+	}
+
+	for _, l := range n.cuts {
+		if during(now, l.Start, l.Heal) && l.From == from && l.To == to {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestThatNetworkFaultsAffectProgress tests when a proposer can agree on a
+// value while it is partitioned from, or cannot hear from, some acceptors.
+func TestThatNetworkFaultsAffectProgress(t *testing.T) {
+	p0, a0, a1, a2 := ProposerNode(0), AcceptorNode(0), AcceptorNode(1),
+		AcceptorNode(2)
+
+	tests := []struct {
+		partitions []Partition
+		cuts       []LinkCut
+		stalls     bool // whether the proposer must wait for the network to heal
+	}{
+		// in a minority partition until it heals:
+		{partitions: []Partition{{Groups: [][]Node{{p0, a0}}, Heal: time.Second}},
+			stalls: true},
+		// in a majority partition that never heals:
+		{partitions: []Partition{{Groups: [][]Node{{p0, a0, a1}}}}},
+		// can send to but not hear from a majority until the links heal:
+		{cuts: []LinkCut{{From: a0, To: p0, Heal: time.Second},
+			{From: a1, To: p0, Heal: time.Second}}, stalls: true},
+		// can hear from but not send to a minority:
+		{cuts: []LinkCut{{From: p0, To: a2}}},
+	}
+
+	for i, test := range tests {
+		c := Config{NProposers: 1, NAcceptors: 3,
+			ProposerTimeout: 100 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond,
+			Buffer:          1, Drop: 0, Seed: 1, Output: io.Discard,
+			Partitions: test.partitions, LinkCuts: test.cuts}
+
+		cluster, err := c.Start(context.Background())
+		if err == nil {
+			err = cluster.Wait()
+		}
+		if err != nil {
+			t.Fatalf("test case %d: got %v", i, err)
+		}
+
+		agreed := cluster.Metrics().MaxLatency
+		if stalled := agreed >= time.Second; stalled != test.stalls {
+			t.Errorf("test case %d: agreed at %s, want stalled = %t", i,
+				agreed, test.stalls)
+		}
+	}
+}
+
+func TestThatNetworkFaultsForNonexistentNodesAreRejected(t *testing.T) {
+	configs := []Config{
+		{NProposers: 1, NAcceptors: 1,
+			Partitions: []Partition{{Groups: [][]Node{{AcceptorNode(1)}}}}},
+		{NProposers: 1, NAcceptors: 1,
+			LinkCuts: []LinkCut{{From: ProposerNode(0), To: ProposerNode(1)}}},
+	}
+
+	for i, c := range configs {
+		if err := c.Run(); err == nil {
+			t.Errorf("test case %d: got nil error", i)
+		}
+	}
+}
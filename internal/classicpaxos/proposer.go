@@ -15,123 +15,214 @@
 package classicpaxos
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math/rand"
 	"time"
 )
 
-// proposer represents the proposer role in Classic Paxos.
-type proposer struct {
-	input      <-chan message   // input channel
-	replyTo    chan<- message   // for acceptor replies
-	id         int              // proposer identifier
-	nProposers int              // number of proposers
-	acceptors  []chan<- message // input channels for acceptors
-	timeout    time.Duration    // time to wait for promise and accept messages
-	values     chan<- string    // proposer places agreed value on this channel
+// Proposer represents the proposer role in Classic Paxos.
+type Proposer struct {
+	proposerCore                // identifier, numbers of participants, quorums
+	input        channel        // input channel
+	transport    Transport      // for sending to acceptors
+	timeout      time.Duration  // time to wait for promise and accept messages
+	retry        RetryStrategy  // how long to wait before each new round
+	rand         *rand.Rand     // source of the retry strategy's jitter
+	fault        *ProposerFault // when proposer crashes, or nil if it never does
+	sched        scheduler      // scheduler whose clock determines when to crash
+	trace        *tracer        // proposer traces its progress
+	out          io.Writer      // proposer prints its progress to out
+
+	state   proposerState // state of the proposer algorithm
+	rejects int           // number of reject messages received
+	crashed bool          // whether the proposer's fault has fired
 }
 
-// newProposer creates a proposer with the given parameters and starts its
-// goroutine.
-func newProposer(id, nProposers int,
-	input <-chan message,
-	replyTo chan<- message,
-	acceptorChannels []chan<- message,
+// NewProposer returns a proposer numbered id, of nProposers proposers, which
+// sends to the acceptors numbered 0 to nAcceptors-1 over transport. It
+// completes each phase once the acceptors that reply form a quorum in quorums,
+// and starts a new round if it waits longer than timeout for them. It prints
+// its progress to out unless out is nil. If nProposers is zero, the proposer
+// uses PairEpochs, so that any number of proposers with distinct ids may
+// join or leave.
+func NewProposer(id, nProposers, nAcceptors int, quorums QuorumSystem,
+	timeout time.Duration, transport Transport, out io.Writer) *Proposer {
+
+	epochs := ModuloEpochs
+	if nProposers == 0 {
+		epochs = PairEpochs
+	}
+	s := newRealScheduler()
+	return newProposer(s, id, nProposers, epochs, s.newChannel(inboxSize),
+		transport, nAcceptors, quorums, timeout, FixedRetry{},
+		rand.New(rand.NewSource(time.Now().UnixNano())), nil, nil,
+		orDiscard(out))
+}
+
+// newProposer creates a proposer with the given parameters, using the
+// scheduler s.
+func newProposer(s scheduler, id, nProposers int,
+	epochs EpochScheme,
+	input channel,
+	transport Transport,
+	nAcceptors int,
+	quorums QuorumSystem,
 	timeout time.Duration,
-	values chan<- string) *proposer {
-
-	p := &proposer{
-		input:      input,
-		replyTo:    replyTo,
-		id:         id,
-		nProposers: nProposers,
-		acceptors:  acceptorChannels,
-		timeout:    timeout,
-		values:     values,
+	retry RetryStrategy,
+	r *rand.Rand,
+	fault *ProposerFault,
+	trace *tracer,
+	out io.Writer) *Proposer {
+
+	return &Proposer{
+		proposerCore: proposerCore{
+			id:         id,
+			nProposers: nProposers,
+			nAcceptors: nAcceptors,
+			quorums:    quorums,
+			epochs:     epochs,
+		},
+		input:     input,
+		transport: transport,
+		timeout:   timeout,
+		retry:     retry,
+		rand:      r,
+		fault:     fault,
+		sched:     s,
+		trace:     trace,
+		out:       out,
 	}
-	go p.run()
-	return p
 }
 
-// run is a translation of the proposer algorithm for Classic Paxos. It returns
-// the agreed value.
-func (p *proposer) run() string {
-	var epoch Epoch
+// Deliver delivers m, which was sent to the proposer, or loses m if the
+// proposer has yet to receive inboxSize messages delivered earlier.
+func (p *Proposer) Deliver(m Message) {
+	p.input.trySend(m)
+}
 
-	candidateValue := fmt.Sprintf("v%d", p.id)
+// Propose runs rounds of Classic Paxos until the proposer believes a value is
+// decided, and returns that value. The value is candidateValue, unless the
+// proposer finds that the acceptors may have chosen another value. Propose
+// returns ctx.Err() if ctx is done before then, which it notices within the
+// proposer's timeout. candidateValue must not be the nil value.
+//
+// Propose runs the proposer algorithm for Classic Paxos, which step
+// implements, on the messages that the proposer receives and its timeouts.
+func (p *Proposer) Propose(ctx context.Context,
+	candidateValue Value) (Value, error) {
+
+	if candidateValue.Nil() {
+		return Value{}, fmt.Errorf("proposer %d cannot propose the nil value",
+			p.id)
+	}
+	if err := ctx.Err(); err != nil {
+		return Value{}, err
+	}
+	outputs := p.apply(proposalStart{value: candidateValue})
+	first, round := p.state.round, p.state.round
 
 	for {
-		var value = ""                          // current proposal value
-		var maxEpoch Epoch                      // maximum epoch received in phase 1
-		promisedAcceptors := make(map[int]bool) // keys are acceptors that have promised
-		acceptedAcceptors := make(map[int]bool) // keys are acceptors that have accepted
-
-		// select and set the epoch
-		if epoch.Nil() {
-			epoch = newEpoch(p.id, p.nProposers)
-		} else {
-			epoch = epoch.Next()
+		if p.state.round > round {
+			// the last round failed, so back off before starting the next
+			round = p.state.round
+			p.backoff(round - first)
 		}
 
-		for _, a := range p.acceptors {
-			a <- prepare{epoch: epoch, replyTo: p.replyTo, proposerID: p.id}
+		if err := p.send(outputs); err != nil {
+			return Value{}, err
 		}
 
-		timedOut := false
-
-		for !timedOut && len(promisedAcceptors) < (len(p.acceptors)/2)+1 {
-			select {
-			case msg := <-p.input:
-				fmt.Printf("proposer %d received message %s\n", p.id, msg)
-
-				if promise, ok := msg.(promise); ok {
-					promisedAcceptors[promise.acceptorID] = true
-					if !promise.acceptedEpoch.Nil() &&
-						(maxEpoch.Nil() || promise.acceptedEpoch.Cmp(maxEpoch) > 0) {
-
-						// (maxEpoch, value) is the greatest proposal received
-						maxEpoch = promise.acceptedEpoch
-						value = promise.acceptedValue
-					}
-				}
-			case <-time.After(p.timeout):
-				timedOut = true
-			}
+		if p.state.phase == proposerDecided {
+			fmt.Fprintf(p.out, "proposer %d believes value %s is decided\n", p.id,
+				p.state.value)
+			p.reconfiguration().decide(p.state.epoch)
+			p.trace.step(EventDecided, ProposerNode(p.id), p.state.epoch,
+				p.state.value.String())
+			return p.state.value, nil
 		}
 
-		if timedOut {
+		msg, ok := p.input.receiveTimeout(p.timeout)
+		if !ok {
+			p.trace.step(EventTimeout, ProposerNode(p.id), p.state.epoch, "")
+			if err := ctx.Err(); err != nil {
+				return Value{}, err
+			}
+			engaged := p.state.auxiliary
+			outputs = p.apply(proposalTimeout{})
+			if !engaged && p.state.auxiliary {
+				fmt.Fprintf(p.out, "proposer %d engages the auxiliary acceptors\n",
+					p.id)
+			}
 			continue
 		}
-
-		if value == "" {
-			// no proposals were received thus propose candidate value
-			value = candidateValue
+		if msg == nil {
+			return Value{}, fmt.Errorf("proposer %d stopped", p.id)
 		}
-
-		// start phase 2 for proposal (epoch, value)
-		for _, a := range p.acceptors {
-			a <- propose{epoch: epoch, value: value, replyTo: p.replyTo,
-				proposerID: p.id}
+		if err := ctx.Err(); err != nil {
+			return Value{}, err
 		}
 
-		for !timedOut && len(acceptedAcceptors) < (len(p.acceptors)/2)+1 {
-			select {
-			case msg := <-p.input:
-				fmt.Printf("proposer %d received message %s\n", p.id, msg)
+		fmt.Fprintf(p.out, "proposer %d received message %s\n", p.id, msg)
+		p.trace.arrival(EventReceived, ProposerNode(p.id), msg, "")
 
-				if accept, ok := msg.(accept); ok && epoch.Cmp(accept.epoch) == 0 {
-					acceptedAcceptors[accept.acceptorID] = true
-				}
-			case <-time.After(p.timeout):
-				timedOut = true
-			}
-		}
+		outputs = p.apply(msg)
+	}
+}
 
-		if timedOut {
-			continue
-		}
+// apply takes a step of the proposer algorithm with the input in, and returns
+// the messages to send. It traces the start of each new round, and tells a
+// reconfiguration its epoch, and counts the reject messages received.
+func (p *Proposer) apply(in Message) []output {
+	round := p.state.round
+	if _, ok := in.(reject); ok {
+		p.rejects++
+	}
+
+	var outputs []output
+	p.state, outputs = p.step(p.state, in)
 
-		fmt.Printf("proposer %d believes value %s is decided\n", p.id, value)
+	if p.state.round != round {
+		p.trace.step(EventEpoch, ProposerNode(p.id), p.state.epoch, "")
+		p.reconfiguration().prepare(p.state.epoch)
+	}
+	return outputs
+}
+
+// backoff waits as long as the proposer's retry strategy says to after
+// failures consecutive failed rounds.
+func (p *Proposer) backoff(failures int) {
+	if d := p.retry.Backoff(failures, p.rand); d > 0 {
+		fmt.Fprintf(p.out, "proposer %d backs off for %s\n", p.id, d)
+		p.sched.sleep(d)
+	}
+}
+
+// send sends outputs to the acceptors, unless the proposer crashes midway
+// through starting phase 2, in which case it sends to only half of them and
+// returns an error.
+func (p *Proposer) send(outputs []output) error {
+	phase2 := false
+	if len(outputs) > 0 {
+		_, phase2 = outputs[0].msg.(propose)
+	}
+
+	crashing := phase2 && p.fault != nil && p.sched.now() >= p.fault.CrashAt
+	if crashing {
+		outputs = outputs[:(len(outputs)+1)/2]
+	}
+
+	for _, o := range outputs {
+		p.transport.Send(ProposerNode(p.id), o.to, o.msg)
+	}
 
-		p.values <- value
+	if crashing {
+		p.crashed = true
+		fmt.Fprintf(p.out,
+			"proposer %d crashed after proposing to %d acceptor/s\n", p.id,
+			len(outputs))
+		return fmt.Errorf("proposer %d crashed", p.id)
 	}
+	return nil
 }
@@ -0,0 +1,72 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// transportFunc is a Transport that calls itself to send each message.
+type transportFunc func(from, to Node, m Message)
+
+// Send calls f(from, to, m).
+func (f transportFunc) Send(from, to Node, m Message) {
+	f(from, to, m)
+}
+
+// The model checker found that a proposer which counts the promises left over
+// from its earlier rounds can propose without a quorum having promised it
+// anything, and so overwrite a value chosen in between.
+func TestThatProposerIgnoresPromisesForEarlierEpochs(t *testing.T) {
+	s := newSimScheduler()
+	input := s.newChannel(inboxSize)
+	proposed := false
+
+	// in the proposer's second round, deliver stale promises for its first
+	transport := transportFunc(func(from, to Node, m Message) {
+		switch m := m.(type) {
+		case prepare:
+			if m.epoch.Cmp(newEpoch(2, 2)) == 0 {
+				input.trySend(promise{epoch: newEpoch(0, 2), acceptorID: to.ID})
+			}
+		case propose:
+			proposed = true
+		}
+	})
+
+	p := newProposer(s, 0, 2, ModuloEpochs, input, transport, 3,
+		Majority{NAcceptors: 3}, time.Second, FixedRetry{}, nil, nil, nil,
+		io.Discard)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	s.run(ctx, func() {
+		ctx, cancel := context.WithCancel(ctx)
+		s.spawn(func() {
+			s.sleep(5 * time.Second)
+			cancel()
+		})
+		if _, err := p.Propose(ctx, v0); err == nil {
+			t.Errorf("proposer decided without promises for its epoch")
+		}
+	})
+
+	if proposed {
+		t.Errorf("proposer proposed on the strength of stale promises")
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import "fmt"
+
+// QuorumSystem determines which sets of acceptors are quorums in each phase of
+// Classic Paxos. A proposer completes phase 1 once a phase 1 quorum has
+// promised, and phase 2 once a phase 2 quorum has accepted. Classic Paxos is
+// safe provided every phase 1 quorum intersects every phase 2 quorum [1].
+//
+// [1] Heidi Howard, Dahlia Malkhi, and Alexander Spiegelman. 2016. Flexible
+// Paxos: Quorum intersection revisited. arXiv:1608.06696.
+type QuorumSystem interface {
+	// IsPhase1Quorum returns true if and only if the acceptors whose
+	// identifiers are the keys of acceptors form a phase 1 quorum.
+	IsPhase1Quorum(acceptors map[int]bool) bool
+
+	// IsPhase2Quorum returns true if and only if the acceptors whose
+	// identifiers are the keys of acceptors form a phase 2 quorum.
+	IsPhase2Quorum(acceptors map[int]bool) bool
+}
+
+// Majority is the quorum system of [1], in which a quorum in either phase is
+// any majority of the acceptors.
+type Majority struct {
+	NAcceptors int // number of acceptors
+}
+
+// IsPhase1Quorum implements QuorumSystem.
+func (q Majority) IsPhase1Quorum(acceptors map[int]bool) bool {
+	return len(acceptors) >= (q.NAcceptors/2)+1
+}
+
+// IsPhase2Quorum implements QuorumSystem.
+func (q Majority) IsPhase2Quorum(acceptors map[int]bool) bool {
+	return len(acceptors) >= (q.NAcceptors/2)+1
+}
+
+// String returns the string form of a majority quorum system.
+func (q Majority) String() string {
+	return "majority"
+}
+
+// Flexible is a Flexible Paxos quorum system, in which a quorum in each phase
+// is any set of acceptors of that phase's size. Phase 1 and phase 2 quorums
+// intersect if and only if Phase1 + Phase2 > NAcceptors, so a small phase 2
+// quorum may be traded for a large phase 1 quorum.
+type Flexible struct {
+	Phase1 int // size of a phase 1 quorum
+	Phase2 int // size of a phase 2 quorum
+}
+
+// IsPhase1Quorum implements QuorumSystem.
+func (q Flexible) IsPhase1Quorum(acceptors map[int]bool) bool {
+	return len(acceptors) >= q.Phase1
+}
+
+// IsPhase2Quorum implements QuorumSystem.
+func (q Flexible) IsPhase2Quorum(acceptors map[int]bool) bool {
+	return len(acceptors) >= q.Phase2
+}
+
+// String returns the string form of a flexible quorum system.
+func (q Flexible) String() string {
+	return fmt.Sprintf("flexible(%d, %d)", q.Phase1, q.Phase2)
+}
+
+// Weighted is a quorum system in which each acceptor has a weight, and a
+// quorum in either phase is any set of acceptors with more than half of the
+// total weight. Acceptors without a weight in Weights have weight zero. No
+// weight may be negative, and the total weight must be positive.
+type Weighted struct {
+	Weights []int // weight of each acceptor
+}
+
+// IsPhase1Quorum implements QuorumSystem.
+func (q Weighted) IsPhase1Quorum(acceptors map[int]bool) bool {
+	return q.isQuorum(acceptors)
+}
+
+// IsPhase2Quorum implements QuorumSystem.
+func (q Weighted) IsPhase2Quorum(acceptors map[int]bool) bool {
+	return q.isQuorum(acceptors)
+}
+
+// isQuorum returns true if and only if the weight of acceptors is more than
+// half of the total weight.
+func (q Weighted) isQuorum(acceptors map[int]bool) bool {
+	weight, total := 0, 0
+	for a, w := range q.Weights {
+		total += w
+		if acceptors[a] {
+			weight += w
+		}
+	}
+	return 2*weight > total
+}
+
+// check returns a non-nil error if a weight is negative, in which case a
+// superset of a quorum need not be a quorum, or if the total weight is not
+// positive.
+func (q Weighted) check() error {
+	total := 0
+	for a, w := range q.Weights {
+		if w < 0 {
+			return fmt.Errorf("quorum system %v gives acceptor %d negative "+
+				"weight %d", q, a, w)
+		}
+		total += w
+	}
+	if total <= 0 {
+		return fmt.Errorf("quorum system %v has total weight %d, want more "+
+			"than 0", q, total)
+	}
+	return nil
+}
+
+// String returns the string form of a weighted quorum system.
+func (q Weighted) String() string {
+	return fmt.Sprintf("weighted%v", q.Weights)
+}
+
+// Grid is a quorum system in which the acceptors are arranged in a grid, row by
+// row, so that acceptor a is in row a/Columns and column a%Columns. A phase 1
+// quorum is any set of acceptors that contains a whole row, and a phase 2
+// quorum is any set that contains a whole column. Every row intersects every
+// column, so Rows acceptors suffice for phase 2 rather than a majority.
+type Grid struct {
+	Rows, Columns int // dimensions of the grid
+}
+
+// IsPhase1Quorum implements QuorumSystem.
+func (q Grid) IsPhase1Quorum(acceptors map[int]bool) bool {
+	for r := 0; r < q.Rows; r++ {
+		whole := true
+		for c := 0; c < q.Columns; c++ {
+			whole = whole && acceptors[r*q.Columns+c]
+		}
+		if whole {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPhase2Quorum implements QuorumSystem.
+func (q Grid) IsPhase2Quorum(acceptors map[int]bool) bool {
+	for c := 0; c < q.Columns; c++ {
+		whole := true
+		for r := 0; r < q.Rows; r++ {
+			whole = whole && acceptors[r*q.Columns+c]
+		}
+		if whole {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the string form of a grid quorum system.
+func (q Grid) String() string {
+	return fmt.Sprintf("grid(%dx%d)", q.Rows, q.Columns)
+}
+
+// maxCheckedAcceptors is the largest number of acceptors for which
+// CheckQuorums can enumerate every set of acceptors.
+const maxCheckedAcceptors = 16
+
+// CheckQuorums returns a non-nil error unless, among nAcceptors acceptors,
+// every phase 1 quorum of q intersects every phase 2 quorum, and the set of
+// all acceptors is a quorum in both phases. It assumes that every superset of
+// a quorum is a quorum, which holds for every QuorumSystem in this package, so
+// it rejects a Weighted system with a negative weight.
+func CheckQuorums(q QuorumSystem, nAcceptors int) error {
+	if nAcceptors > maxCheckedAcceptors {
+		return fmt.Errorf("cannot check quorums of more than %d acceptors",
+			maxCheckedAcceptors)
+	}
+
+	if w, ok := q.(Weighted); ok {
+		if err := w.check(); err != nil {
+			return err
+		}
+	}
+
+	all := acceptorSet(1<<nAcceptors-1, nAcceptors)
+	if !q.IsPhase1Quorum(all) {
+		return fmt.Errorf("quorum system %v has no phase 1 quorum of %d acceptors",
+			q, nAcceptors)
+	}
+	if !q.IsPhase2Quorum(all) {
+		return fmt.Errorf("quorum system %v has no phase 2 quorum of %d acceptors",
+			q, nAcceptors)
+	}
+
+	// If a phase 1 quorum is disjoint from some phase 2 quorum, then it is
+	// disjoint from the acceptors not in it, which then form a phase 2 quorum.
+	for set := 0; set < 1<<nAcceptors; set++ {
+		phase1 := acceptorSet(set, nAcceptors)
+		rest := acceptorSet(^set, nAcceptors)
+		if q.IsPhase1Quorum(phase1) && q.IsPhase2Quorum(rest) {
+			return fmt.Errorf(
+				"quorum system %v has disjoint phase 1 quorum %v and phase 2 quorum %v",
+				q, sortedAcceptors(phase1), sortedAcceptors(rest))
+		}
+	}
+
+	return nil
+}
+
+// acceptorSet returns the set of those acceptors, of nAcceptors, whose bits
+// are set in set.
+func acceptorSet(set, nAcceptors int) map[int]bool {
+	acceptors := make(map[int]bool)
+	for a := 0; a < nAcceptors; a++ {
+		if set&(1<<a) != 0 {
+			acceptors[a] = true
+		}
+	}
+	return acceptors
+}
+
+// sortedAcceptors returns the identifiers of the set of acceptors in
+// increasing order.
+func sortedAcceptors(acceptors map[int]bool) []int {
+	var ks []int
+	for a := 0; a < maxCheckedAcceptors; a++ {
+		if acceptors[a] {
+			ks = append(ks, a)
+		}
+	}
+	return ks
+}
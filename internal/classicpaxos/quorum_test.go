@@ -0,0 +1,89 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThatCheckQuorumsRejectsNonIntersectingQuorums(t *testing.T) {
+	tests := []struct {
+		quorums    QuorumSystem
+		nAcceptors int
+		valid      bool
+	}{
+		{Majority{NAcceptors: 5}, 5, true},
+		{Majority{NAcceptors: 3}, 5, false},
+		{Flexible{Phase1: 4, Phase2: 2}, 5, true},
+		{Flexible{Phase1: 3, Phase2: 2}, 5, false},
+		{Flexible{Phase1: 6, Phase2: 1}, 5, false},
+		{Weighted{Weights: []int{3, 1, 1, 1}}, 4, true},
+		{Weighted{Weights: []int{1, 1, 4}}, 2, false},
+		{Weighted{Weights: []int{1, 1, -1}}, 3, false},
+		{Weighted{Weights: []int{0, 0}}, 2, false},
+		{Grid{Rows: 2, Columns: 3}, 6, true},
+		{Grid{Rows: 2, Columns: 3}, 5, true},
+		{Grid{Rows: 2, Columns: 3}, 2, false},
+	}
+
+	for _, test := range tests {
+		err := CheckQuorums(test.quorums, test.nAcceptors)
+		if test.valid && err != nil {
+			t.Errorf("for %v of %d acceptors, got %v", test.quorums,
+				test.nAcceptors, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("for %v of %d acceptors, got no error", test.quorums,
+				test.nAcceptors)
+		}
+	}
+}
+
+func TestThatAgreementHoldsWithIntersectingQuorums(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		quorums    QuorumSystem
+		nAcceptors int
+	}{
+		{Flexible{Phase1: 4, Phase2: 2}, 5},
+		{Weighted{Weights: []int{3, 1, 1, 1}}, 4},
+		{Grid{Rows: 2, Columns: 2}, 4},
+	}
+
+	for _, test := range tests {
+		for seed := int64(1); seed <= 20; seed++ {
+			c := Config{NProposers: 3, NAcceptors: test.nAcceptors, NLearners: 1,
+				Quorums: test.quorums, ProposerTimeout: 100 * ms,
+				ChannelTimeout: 10 * ms, Buffer: 2, Drop: 0.1, Seed: seed,
+				Output: io.Discard}
+
+			if err := c.Run(); err != nil {
+				t.Errorf("for %v with seed %d, got %v", test.quorums, seed, err)
+			}
+		}
+	}
+}
+
+func TestThatRunRejectsNonIntersectingQuorums(t *testing.T) {
+	c := Config{NProposers: 1, NAcceptors: 5,
+		Quorums: Flexible{Phase1: 2, Phase2: 2}}
+
+	if err := c.Run(); err == nil {
+		t.Errorf("got no error, want one")
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A run with reconfigurations changes its set of acceptors while the proposers
+// decide, in the manner of Vertical Paxos [1]. Each configuration is a set of
+// acceptors, whose majorities are its quorums, and it applies to a range of
+// epochs. A master, which the run trusts not to fail, installs each new
+// configuration for the epochs greater than every epoch prepared so far, so
+// that no epoch changes configuration once a proposer uses it.
+//
+// A value is chosen in an epoch once a majority of that epoch's configuration
+// accepts it. A proposer's phase 1 must therefore reach a majority of every
+// configuration in which a value may have been chosen in an earlier epoch:
+// the configuration of its own epoch, and each earlier one. Once a proposer
+// decides in an epoch, though, the configurations before that epoch's are
+// retired for every greater epoch, since the value that the decision fixes
+// lies in the later configuration, and so the acceptors that only the retired
+// configurations contain may leave.
+//
+// [1] Leslie Lamport, Dahlia Malkhi, and Lidong Zhou. 2009. Vertical Paxos and
+// primary-backup replication. In Proceedings of the 28th ACM Symposium on
+// Principles of Distributed Computing (PODC '09), 312-313.
+
+// Reconfiguration schedules the installation of a new configuration of
+// acceptors.
+type Reconfiguration struct {
+	// acceptors of the new configuration
+	Acceptors []int
+
+	// time since the start of the run at which the configuration is installed
+	At time.Duration
+}
+
+// String returns the string form of a reconfiguration.
+func (r Reconfiguration) String() string {
+	return fmt.Sprintf("configuration %v at %s", r.Acceptors, r.At)
+}
+
+// configuration is a set of acceptors, whose majorities are quorums in the
+// epochs greater than after.
+type configuration struct {
+	acceptors map[int]bool // members of the configuration
+	after     Epoch        // greatest epoch prepared before installation
+}
+
+// reconfiguration is the quorum system of a run with reconfigurations, and
+// plays the part of the master of Vertical Paxos. Its quorums depend on the
+// epoch, so the participants consult it through isPhase1Quorum, isChosen and
+// acceptors rather than through the QuorumSystem methods, which consider the
+// latest configuration only. It is safe for concurrent use.
+type reconfiguration struct {
+	mu       sync.Mutex      // guards the fields below
+	configs  []configuration // configurations installed so far, in order
+	prepared Epoch           // greatest epoch prepared so far
+	decided  []Epoch         // epochs in which proposers have decided
+}
+
+// newReconfiguration returns a reconfiguration whose first configuration
+// contains acceptors, and applies to every epoch.
+func newReconfiguration(acceptors []int) *reconfiguration {
+	return &reconfiguration{
+		configs: []configuration{{acceptors: acceptorsOf(acceptors)}},
+	}
+}
+
+// acceptorsOf returns the set of the acceptors in list.
+func acceptorsOf(list []int) map[int]bool {
+	acceptors := make(map[int]bool)
+	for _, a := range list {
+		acceptors[a] = true
+	}
+	return acceptors
+}
+
+// IsPhase1Quorum implements QuorumSystem for the latest configuration.
+func (r *reconfiguration) IsPhase1Quorum(acceptors map[int]bool) bool {
+	return r.IsPhase2Quorum(acceptors)
+}
+
+// IsPhase2Quorum implements QuorumSystem for the latest configuration.
+func (r *reconfiguration) IsPhase2Quorum(acceptors map[int]bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.configs[len(r.configs)-1].isQuorum(acceptors)
+}
+
+// String returns the string form of a reconfiguration.
+func (r *reconfiguration) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprintf("majorities of %d configuration/s", len(r.configs))
+}
+
+// install installs a new configuration of acceptors for the epochs greater
+// than every epoch prepared so far, and returns its index and that epoch.
+func (r *reconfiguration) install(acceptors []int) (int, Epoch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.configs = append(r.configs, configuration{
+		acceptors: acceptorsOf(acceptors),
+		after:     r.prepared,
+	})
+	return len(r.configs) - 1, r.prepared
+}
+
+// prepare records that a proposer is about to prepare epoch e, which fixes
+// the configuration of e. A nil reconfiguration records nothing.
+func (r *reconfiguration) prepare(e Epoch) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if less(r.prepared, e) {
+		r.prepared = e
+	}
+}
+
+// decide records that a proposer decided in epoch e, which retires the
+// configurations before e's for the greater epochs. A nil reconfiguration
+// records nothing.
+func (r *reconfiguration) decide(e Epoch) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decided = append(r.decided, e)
+}
+
+// index returns the index of the configuration of epoch e. r.mu must be held.
+func (r *reconfiguration) index(e Epoch) int {
+	i := 0
+	for j, c := range r.configs {
+		if less(c.after, e) {
+			i = j
+		}
+	}
+	return i
+}
+
+// first returns the index of the earliest configuration that is not retired
+// for epoch e. r.mu must be held.
+func (r *reconfiguration) first(e Epoch) int {
+	first := 0
+	for _, d := range r.decided {
+		if i := r.index(d); less(d, e) && i > first {
+			first = i
+		}
+	}
+	return first
+}
+
+// isPhase1Quorum returns true if and only if acceptors contains a majority of
+// every configuration that is not retired for epoch e, up to e's.
+func (r *reconfiguration) isPhase1Quorum(e Epoch, acceptors map[int]bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := r.first(e); i <= r.index(e); i++ {
+		if !r.configs[i].isQuorum(acceptors) {
+			return false
+		}
+	}
+	return true
+}
+
+// isChosen returns true if and only if acceptors contains a majority of the
+// configuration of epoch e.
+func (r *reconfiguration) isChosen(e Epoch, acceptors map[int]bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.configs[r.index(e)].isQuorum(acceptors)
+}
+
+// acceptors returns, in increasing order, the acceptors to which a proposer
+// sends in epoch e: in phase 1, the members of every configuration that
+// isPhase1Quorum consults, and in phase 2, those of e's configuration.
+func (r *reconfiguration) acceptors(e Epoch, phase1 bool) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	first, last := r.index(e), r.index(e)
+	if phase1 {
+		first = r.first(e)
+	}
+
+	members := make(map[int]bool)
+	var sorted []int
+	for i := first; i <= last; i++ {
+		for a := range r.configs[i].acceptors {
+			if !members[a] {
+				members[a] = true
+				sorted = append(sorted, a)
+			}
+		}
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+// isQuorum returns true if and only if acceptors contains a majority of c.
+func (c configuration) isQuorum(acceptors map[int]bool) bool {
+	n := 0
+	for a := range acceptors {
+		if c.acceptors[a] {
+			n++
+		}
+	}
+	return 2*n > len(c.acceptors)
+}
+
+// isPhase1Quorum returns true if and only if the acceptors whose identifiers
+// are the keys of acceptors form a phase 1 quorum for epoch e, given the
+// quorum system q.
+func isPhase1Quorum(q QuorumSystem, e Epoch, acceptors map[int]bool) bool {
+	if r, ok := q.(*reconfiguration); ok {
+		return r.isPhase1Quorum(e, acceptors)
+	}
+	return q.IsPhase1Quorum(acceptors)
+}
+
+// reconfigures returns true if and only if c asks for a run with
+// reconfigurations.
+func (c *Config) reconfigures() bool {
+	return c.InitialAcceptors != nil || len(c.Reconfigurations) > 0
+}
+
+// checkReconfigurations returns a non-nil error if c.InitialAcceptors or
+// c.Reconfigurations gives an empty configuration or one with an acceptor
+// that does not exist, if the reconfigurations are out of order, or if c asks
+// for reconfigurations with an option that they do not support.
+func (c *Config) checkReconfigurations() error {
+	if !c.reconfigures() {
+		return nil
+	}
+	switch {
+	case c.Quorums != nil:
+		return fmt.Errorf("reconfigurations support majority quorums only")
+	case c.Fast:
+		return fmt.Errorf("reconfigurations do not support Fast Paxos")
+	case c.Byzantine:
+		return fmt.Errorf("reconfigurations do not support Byzantine Paxos")
+	case c.NAuxiliary > 0:
+		return fmt.Errorf("reconfigurations do not support Cheap Paxos")
+	}
+
+	configs := [][]int{c.initialAcceptors()}
+	for i, r := range c.Reconfigurations {
+		if i > 0 && r.At < c.Reconfigurations[i-1].At {
+			return fmt.Errorf("%v is scheduled before %v", r,
+				c.Reconfigurations[i-1])
+		}
+		configs = append(configs, r.Acceptors)
+	}
+
+	for _, config := range configs {
+		if len(config) == 0 {
+			return fmt.Errorf("configuration with no acceptors")
+		}
+		for _, a := range config {
+			if a < 0 || a >= c.NAcceptors {
+				return fmt.Errorf("configuration %v has nonexistent acceptor %d",
+					config, a)
+			}
+		}
+	}
+	return nil
+}
+
+// initialAcceptors returns the acceptors of the first configuration of a run:
+// c.InitialAcceptors, or every acceptor if it is nil.
+func (c *Config) initialAcceptors() []int {
+	if c.InitialAcceptors != nil {
+		return c.InitialAcceptors
+	}
+	acceptors := make([]int, c.NAcceptors)
+	for a := range acceptors {
+		acceptors[a] = a
+	}
+	return acceptors
+}
+
+// reconfigure installs each configuration of r.Reconfigurations at its time,
+// in its own goroutine.
+func (r *run) reconfigure() {
+	if len(r.Reconfigurations) == 0 {
+		return
+	}
+	r.sched.spawn(func() {
+		for _, rc := range r.Reconfigurations {
+			r.sched.sleep(rc.At - r.sched.now())
+			acceptors := append([]int(nil), rc.Acceptors...)
+			sort.Ints(acceptors)
+			i, after := r.reconfig.install(acceptors)
+			fmt.Fprintf(r.output(),
+				"configuration %d of acceptors %v is installed for epochs "+
+					"greater than %s\n", i, acceptors, after)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// stepper returns a function that takes a step of p from the state s with
+// the input in, checks that p sends outputs, and returns the new state.
+func stepper(t *testing.T, p proposerCore) func(s proposerState, in Message,
+	outputs string) proposerState {
+
+	return func(s proposerState, in Message, outputs string) proposerState {
+		t.Helper()
+		s, got := p.step(s, in)
+		if fmt.Sprint(got) != outputs {
+			t.Errorf("proposer %d on %s: got outputs %v, want %s", p.id, in, got,
+				outputs)
+		}
+		return s
+	}
+}
+
+func TestThatReconfiguringDuringPhase1PreservesAgreement(t *testing.T) {
+	r := newReconfiguration([]int{0, 1, 2})
+	e0, e1 := newEpoch(0, 2), newEpoch(1, 2)
+	step0 := stepper(t, proposerCore{id: 0, nProposers: 2, nAcceptors: 5,
+		quorums: r})
+	step1 := stepper(t, proposerCore{id: 1, nProposers: 2, nAcceptors: 5,
+		quorums: r})
+
+	s0 := step0(proposerState{}, proposalStart{value: v0},
+		"[prepare(0) from proposer 0 to a0 prepare(0) from proposer 0 to a1 "+
+			"prepare(0) from proposer 0 to a2]")
+	r.prepare(s0.epoch)
+	s0 = step0(s0, promise{epoch: e0, acceptorID: 0}, "[]")
+
+	// proposer 0 is in phase 1 when acceptors 0 and 1 give way to 3 and 4, but
+	// epoch 0 keeps the first configuration
+	if i, after := r.install([]int{2, 3, 4}); i != 1 || after.Cmp(e0) != 0 {
+		t.Fatalf("got configuration %d after epoch %s, want 1 after 0", i, after)
+	}
+	s0 = step0(s0, promise{epoch: e0, acceptorID: 1},
+		"[propose(0, v0) from proposer 0 to a0 propose(0, v0) from proposer 0 to a1 "+
+			"propose(0, v0) from proposer 0 to a2]")
+
+	// epoch 1 has the second configuration, but may find a value chosen in
+	// the first, so it must hear from a majority of both
+	s1 := step1(proposerState{}, proposalStart{value: v1},
+		"[prepare(1) from proposer 1 to a0 prepare(1) from proposer 1 to a1 "+
+			"prepare(1) from proposer 1 to a2 prepare(1) from proposer 1 to a3 "+
+			"prepare(1) from proposer 1 to a4]")
+	r.prepare(s1.epoch)
+	for _, a := range []int{2, 3, 4} {
+		s1 = step1(s1, promise{epoch: e1, acceptorID: a}, "[]")
+	}
+	if s1.phase != proposerPhase1 {
+		t.Errorf("proposer 1 left phase 1 with promises from the second "+
+			"configuration only: %s", s1)
+	}
+	step1(s1, promise{epoch: e1, acceptedEpoch: e0, acceptedValue: v0,
+		acceptorID: 0},
+		"[propose(1, v0) from proposer 1 to a2 propose(1, v0) from proposer 1 to a3 "+
+			"propose(1, v0) from proposer 1 to a4]")
+}
+
+func TestThatReconfiguringDuringPhase2PreservesAgreement(t *testing.T) {
+	r := newReconfiguration([]int{0, 1, 2})
+	e0, e1, e2 := newEpoch(0, 2), newEpoch(1, 2), newEpoch(2, 2)
+	step0 := stepper(t, proposerCore{id: 0, nProposers: 2, nAcceptors: 5,
+		quorums: r})
+	step1 := stepper(t, proposerCore{id: 1, nProposers: 2, nAcceptors: 5,
+		quorums: r})
+
+	s0 := step0(proposerState{}, proposalStart{value: v0},
+		"[prepare(0) from proposer 0 to a0 prepare(0) from proposer 0 to a1 "+
+			"prepare(0) from proposer 0 to a2]")
+	r.prepare(s0.epoch)
+	s0 = step0(s0, promise{epoch: e0, acceptorID: 0}, "[]")
+	s0 = step0(s0, promise{epoch: e0, acceptorID: 1},
+		"[propose(0, v0) from proposer 0 to a0 propose(0, v0) from proposer 0 to a1 "+
+			"propose(0, v0) from proposer 0 to a2]")
+
+	// proposer 0 is in phase 2 when the configuration changes, and acceptors 0
+	// and 1 then choose v0 in epoch 0
+	r.install([]int{2, 3, 4})
+	s0 = step0(s0, accept{epoch: e0, acceptorID: 0}, "[]")
+	s0 = step0(s0, accept{epoch: e0, acceptorID: 1}, "[]")
+	if s0.phase != proposerDecided {
+		t.Fatalf("proposer 0 did not decide: %s", s0)
+	}
+	r.decide(s0.epoch)
+
+	// acceptor 1 reports the chosen value, so epoch 1 proposes it to the
+	// second configuration
+	s1 := step1(proposerState{}, proposalStart{value: v1},
+		"[prepare(1) from proposer 1 to a0 prepare(1) from proposer 1 to a1 "+
+			"prepare(1) from proposer 1 to a2 prepare(1) from proposer 1 to a3 "+
+			"prepare(1) from proposer 1 to a4]")
+	r.prepare(s1.epoch)
+	for _, a := range []int{2, 3, 4} {
+		s1 = step1(s1, promise{epoch: e1, acceptorID: a}, "[]")
+	}
+	s1 = step1(s1, promise{epoch: e1, acceptedEpoch: e0, acceptedValue: v0,
+		acceptorID: 1},
+		"[propose(1, v0) from proposer 1 to a2 propose(1, v0) from proposer 1 to a3 "+
+			"propose(1, v0) from proposer 1 to a4]")
+	s1 = step1(s1, accept{epoch: e1, acceptorID: 3}, "[]")
+	s1 = step1(s1, accept{epoch: e1, acceptorID: 4}, "[]")
+	if s1.phase != proposerDecided || s1.value != v0 {
+		t.Fatalf("proposer 1 did not decide v0: %s", s1)
+	}
+	r.decide(s1.epoch)
+
+	// the decision in the second configuration retires the first, so a later
+	// epoch need not hear from acceptors 0 and 1
+	s0 = step0(s0, proposalStart{value: v0},
+		"[prepare(2) from proposer 0 to a2 prepare(2) from proposer 0 to a3 "+
+			"prepare(2) from proposer 0 to a4]")
+	r.prepare(s0.epoch)
+	s0 = step0(s0, promise{epoch: e2, acceptedEpoch: e1, acceptedValue: v0,
+		acceptorID: 3}, "[]")
+	step0(s0, promise{epoch: e2, acceptorID: 2},
+		"[propose(2, v0) from proposer 0 to a2 propose(2, v0) from proposer 0 to a3 "+
+			"propose(2, v0) from proposer 0 to a4]")
+}
+
+func TestThatRunsAgreeWhileReconfiguring(t *testing.T) {
+	for _, at := range []time.Duration{0, 5 * time.Millisecond,
+		50 * time.Millisecond} {
+
+		for seed := int64(1); seed <= 20; seed++ {
+			c := Config{
+				NProposers:       3,
+				NAcceptors:       5,
+				InitialAcceptors: []int{0, 1, 2},
+				Reconfigurations: []Reconfiguration{
+					{Acceptors: []int{2, 3, 4}, At: at},
+					{Acceptors: []int{3, 4}, At: at + 10*time.Millisecond},
+				},
+				ProposerTimeout: 100 * time.Millisecond,
+				ChannelTimeout:  10 * time.Millisecond,
+				Buffer:          2,
+				Drop:            0.1,
+				Seed:            seed,
+				Output:          io.Discard,
+			}
+			if err := c.Run(); err != nil {
+				t.Errorf("reconfiguring at %s with seed %d: %v", at, seed, err)
+			}
+		}
+	}
+}
+
+func TestThatBadReconfigurationsAreRefused(t *testing.T) {
+	for _, c := range []Config{
+		{NProposers: 1, NAcceptors: 3, InitialAcceptors: []int{}},
+		{NProposers: 1, NAcceptors: 3, InitialAcceptors: []int{0, 3}},
+		{NProposers: 1, NAcceptors: 3,
+			Reconfigurations: []Reconfiguration{{Acceptors: []int{-1}}}},
+		{NProposers: 1, NAcceptors: 3,
+			Reconfigurations: []Reconfiguration{
+				{Acceptors: []int{0}, At: time.Second},
+				{Acceptors: []int{1}, At: time.Millisecond},
+			}},
+		{NProposers: 1, NAcceptors: 3, Quorums: Flexible{Phase1: 2, Phase2: 2},
+			Reconfigurations: []Reconfiguration{{Acceptors: []int{1, 2}}}},
+		{NProposers: 2, NAcceptors: 3, Fast: true,
+			Reconfigurations: []Reconfiguration{{Acceptors: []int{1, 2}}}},
+	} {
+		c.Seed, c.Output = 1, io.Discard
+		if err := c.Run(); err == nil {
+			t.Errorf("run with initial acceptors %v and reconfigurations %v "+
+				"succeeded", c.InitialAcceptors, c.Reconfigurations)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryStrategy determines how proposers avoid preempting one another forever.
+// When proposers start new rounds as soon as their rounds fail, each may
+// prepare a greater epoch just before the others propose, so that none of them
+// ever decides. Classic Paxos guarantees progress only once a single
+// distinguished proposer runs rounds [1].
+//
+// [1] Leslie Lamport. 2001. Paxos made simple. ACM SIGACT News 32, 4
+// (December 2001), 51-58.
+type RetryStrategy interface {
+	// Backoff returns how long a proposer waits before it starts another
+	// round, after failures consecutive rounds have failed, drawing any
+	// pseudo-random choice from r.
+	Backoff(failures int, r *rand.Rand) time.Duration
+}
+
+// FixedRetry is the retry strategy in which a proposer waits for the same
+// delay before each new round. With a zero delay, which is the default, a
+// proposer starts its next round at once.
+type FixedRetry struct {
+	Delay time.Duration // delay before each new round
+}
+
+// Backoff implements RetryStrategy.
+func (f FixedRetry) Backoff(failures int, r *rand.Rand) time.Duration {
+	return f.Delay
+}
+
+// String returns the string form of a fixed retry strategy.
+func (f FixedRetry) String() string {
+	return fmt.Sprintf("fixed(%s)", f.Delay)
+}
+
+// ExponentialRetry is the retry strategy in which a proposer waits for a
+// delay drawn uniformly at random up to a limit that doubles with each failed
+// round, from Base up to at most Max. The randomness, or jitter, keeps
+// proposers that fail together from retrying together.
+type ExponentialRetry struct {
+	Base time.Duration // limit on the delay after one failed round
+	Max  time.Duration // limit on the delay after any number of failed rounds
+}
+
+// Backoff implements RetryStrategy.
+func (e ExponentialRetry) Backoff(failures int, r *rand.Rand) time.Duration {
+	limit := e.Base
+	for i := 1; i < failures && limit < e.Max; i++ {
+		limit *= 2
+	}
+	if limit > e.Max {
+		limit = e.Max
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(r.Int63n(int64(limit)))
+}
+
+// String returns the string form of an exponential retry strategy.
+func (e ExponentialRetry) String() string {
+	return fmt.Sprintf("exponential(%s, %s)", e.Base, e.Max)
+}
+
+// DistinguishedProposer is the retry strategy in which only one proposer, the
+// leader, runs rounds, and the other proposers forward their values to it, as
+// suggested in [1]. The leader is the lowest-numbered proposer that the others
+// do not suspect of having crashed: a proposer suspects the leader once it has
+// waited for a decision for SuspectAfter proposer timeouts, and turns to the
+// next proposer, taking over as leader itself once it has suspected every
+// lower-numbered proposer. Since the leader competes with no one, it starts
+// each new round at once.
+//
+// The leader proposes only its own candidate value, and answers forwarded
+// values with the value decided, never proposing them. So, unless the run is
+// of Fast Paxos, in which the followers send their values to the acceptors
+// too, a follower's value is decided only if the follower takes over as
+// leader, or if its value equals the leader's.
+type DistinguishedProposer struct {
+	// number of proposer timeouts after which to suspect the leader (3 if
+	// zero)
+	SuspectAfter int
+}
+
+// Backoff implements RetryStrategy.
+func (d DistinguishedProposer) Backoff(failures int,
+	r *rand.Rand) time.Duration {
+
+	return 0
+}
+
+// String returns the string form of a distinguished proposer strategy.
+func (d DistinguishedProposer) String() string {
+	return "leader"
+}
+
+// suspectAfter returns the number of proposer timeouts after which to suspect
+// the leader.
+func (d DistinguishedProposer) suspectAfter() int {
+	if d.SuspectAfter <= 0 {
+		return 3
+	}
+	return d.SuspectAfter
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThatExponentialRetryDoublesItsLimit(t *testing.T) {
+	e := ExponentialRetry{Base: 10 * time.Millisecond, Max: time.Second}
+	r := rand.New(rand.NewSource(1))
+
+	limit := e.Base
+	for failures := 1; failures <= 10; failures++ {
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			d := e.Backoff(failures, r)
+			if d < 0 || d >= limit {
+				t.Fatalf("after %d failures, backed off for %s, want [0, %s)",
+					failures, d, limit)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		if longest < limit/2 {
+			t.Errorf("after %d failures, backed off for at most %s, want "+
+				"delays up to %s", failures, longest, limit)
+		}
+
+		if limit *= 2; limit > e.Max {
+			limit = e.Max
+		}
+	}
+}
+
+func TestThatProposersBackOffBetweenRounds(t *testing.T) {
+	var output bytes.Buffer
+	c := Config{NProposers: 3, NAcceptors: 3,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          2, Drop: 0.1, Seed: 1,
+		Retry:  FixedRetry{Delay: 30 * time.Millisecond},
+		Output: &output}
+
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "backs off for 30ms") {
+		t.Errorf("no proposer backed off for 30ms")
+	}
+}
+
+func TestThatOnlyTheDistinguishedProposerRunsRounds(t *testing.T) {
+	c := Config{NProposers: 10, NAcceptors: 5,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          2, Drop: 0.1, Seed: 1,
+		Retry: DistinguishedProposer{}, Output: io.Discard}
+
+	cluster, err := c.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := cluster.Metrics(); m.Decided != 10 || m.Rounds != 1 {
+		t.Errorf("got %s, want 10 proposers to decide after 1 round", m)
+	}
+}
+
+func TestThatFollowersTakeOverFromACrashedLeader(t *testing.T) {
+	var output bytes.Buffer
+	c := Config{NProposers: 3, NAcceptors: 3,
+		ProposerTimeout: 100 * time.Millisecond,
+		ChannelTimeout:  10 * time.Millisecond,
+		Buffer:          2, Drop: 0.1, Seed: 1,
+		Retry:          DistinguishedProposer{},
+		ProposerFaults: []ProposerFault{{Proposer: 0}},
+		Output:         &output}
+
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"proposer 1 suspects leader 0",
+		"proposer 1 takes over as leader"} {
+
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+}
+
+// BenchmarkRetryStrategies runs 10 proposers, the most prone to livelock of
+// the CLI defaults, with each retry strategy, with and without rejects, and
+// reports the mean number of rounds per run, and the mean virtual time until
+// the last proposer decided.
+func BenchmarkRetryStrategies(b *testing.B) {
+	strategies := []RetryStrategy{
+		FixedRetry{},
+		FixedRetry{Delay: 50 * time.Millisecond},
+		ExponentialRetry{Base: 10 * time.Millisecond, Max: time.Second},
+		DistinguishedProposer{},
+	}
+
+	for _, retry := range strategies {
+		for _, noRejects := range []bool{false, true} {
+			name := fmt.Sprintf("%s/rejects=%t", retry, !noRejects)
+			b.Run(name, func(b *testing.B) {
+				var rounds int
+				var latency time.Duration
+
+				for i := 0; i < b.N; i++ {
+					c := Config{NProposers: 10, NAcceptors: 5,
+						ProposerTimeout: 100 * time.Millisecond,
+						ChannelTimeout:  10 * time.Millisecond,
+						Buffer:          2, Drop: 0.1, Seed: int64(i + 1),
+						Retry: retry, NoRejects: noRejects,
+						Output: io.Discard}
+
+					cluster, err := c.Start(context.Background())
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := cluster.Wait(); err != nil {
+						b.Fatal(err)
+					}
+					m := cluster.Metrics()
+					rounds += m.Rounds
+					latency += m.MaxLatency
+				}
+
+				b.ReportMetric(float64(rounds)/float64(b.N), "rounds/op")
+				b.ReportMetric(float64(latency.Milliseconds())/float64(b.N),
+					"virtual-ms/op")
+			})
+		}
+	}
+}
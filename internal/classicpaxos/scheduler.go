@@ -0,0 +1,437 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// scheduler determines how the participants in a run execute: when each
+// goroutine runs, how messages travel between goroutines, and how time passes.
+//
+// A realScheduler runs participants as ordinary goroutines that communicate
+// over Go channels in real time. A simScheduler runs them one at a time against
+// a virtual clock, so that a run is determined entirely by its pseudo-random
+// choices and can be reproduced exactly.
+type scheduler interface {
+	// run runs f, along with any goroutines it spawns, until f returns or ctx
+	// is done. It then stops the scheduler, and waits until every goroutine
+	// spawned has returned. It returns a non-nil error if the scheduler had to
+	// stop because every goroutine was blocked forever.
+	run(ctx context.Context, f func()) error
+
+	// spawn starts f in a new goroutine.
+	spawn(f func())
+
+	// now returns the time elapsed since the scheduler was created.
+	now() time.Duration
+
+	// sleep pauses the calling goroutine for the duration d.
+	sleep(d time.Duration)
+
+	// newChannel returns a new channel that buffers size messages.
+	newChannel(size int) channel
+
+	// stop stops the scheduler. From then on, every channel of the scheduler
+	// behaves as if it were closed, and loses every message sent to it, so
+	// that goroutines waiting on the channels can return.
+	stop()
+}
+
+// channel is a chan-like FIFO queue of messages belonging to a scheduler.
+type channel interface {
+	// send places m on the channel.
+	send(m Message)
+
+	// trySend places m on the channel and returns true, or returns false
+	// without waiting if the channel is full.
+	trySend(m Message) bool
+
+	// receive waits for a message and returns it, or returns nil if the
+	// channel is closed.
+	receive() Message
+
+	// receiveTimeout waits up to timeout for a message. It returns the message
+	// and true, or nil and false if the timeout expires first, or nil and true
+	// if the channel is closed.
+	receiveTimeout(timeout time.Duration) (Message, bool)
+
+	// close closes the channel.
+	close()
+}
+
+// realScheduler is a scheduler that uses goroutines, Go channels and the wall
+// clock.
+type realScheduler struct {
+	start    time.Time      // time at which the scheduler was created
+	done     chan struct{}  // closed when the scheduler stops
+	stopOnce sync.Once      // closes done
+	running  sync.WaitGroup // goroutines spawned that have not returned
+}
+
+// newRealScheduler returns a new realScheduler.
+func newRealScheduler() *realScheduler {
+	return &realScheduler{start: time.Now(), done: make(chan struct{})}
+}
+
+func (s *realScheduler) run(ctx context.Context, f func()) error {
+	s.stopWhenDone(ctx)
+	f()
+	s.stop()
+	s.running.Wait()
+	return nil
+}
+
+func (s *realScheduler) spawn(f func()) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		f()
+	}()
+}
+
+func (s *realScheduler) now() time.Duration {
+	return time.Since(s.start)
+}
+
+func (s *realScheduler) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-s.done:
+	}
+}
+
+func (s *realScheduler) newChannel(size int) channel {
+	return realChannel{messages: make(chan Message, size), done: s.done}
+}
+
+func (s *realScheduler) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// stopWhenDone starts a goroutine that stops s when ctx is done. The goroutine
+// returns once s stops.
+func (s *realScheduler) stopWhenDone(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop()
+		case <-s.done:
+		}
+	}()
+}
+
+// realChannel is the channel type of a realScheduler.
+type realChannel struct {
+	messages chan Message
+	done     chan struct{} // closed when the scheduler stops
+}
+
+func (c realChannel) send(m Message) {
+	select {
+	case c.messages <- m:
+	case <-c.done:
+	}
+}
+
+func (c realChannel) trySend(m Message) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.messages <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c realChannel) receive() Message {
+	select {
+	case m := <-c.messages:
+		return m
+	case <-c.done:
+		return nil
+	}
+}
+
+func (c realChannel) receiveTimeout(timeout time.Duration) (Message, bool) {
+	select {
+	case m := <-c.messages:
+		return m, true
+	case <-c.done:
+		return nil, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+func (c realChannel) close() {
+	close(c.messages)
+}
+
+// simScheduler is a deterministic scheduler with a virtual clock.
+//
+// Each goroutine spawned by a simScheduler is a coroutine: only one of them
+// runs at any moment, and it runs until it blocks receiving from a channel or
+// returns, at which point it yields to the scheduler. The scheduler then
+// resumes the coroutine that became runnable first. If no coroutine is
+// runnable, the virtual clock jumps ahead to the earliest pending timeout and
+// the coroutine waiting on it resumes. Since the scheduling order depends only
+// on the order in which messages are sent and timeouts expire, two runs that
+// make the same pseudo-random choices are identical.
+type simScheduler struct {
+	clock      time.Duration // virtual time
+	current    *coroutine    // coroutine that is running
+	coroutines []*coroutine  // every coroutine spawned
+	runnable   []*coroutine  // coroutines ready to run, in FIFO order
+	sleeping   []*coroutine  // coroutines blocked with a timeout
+	yield      chan struct{} // signaled when the running coroutine yields
+	live       int           // number of coroutines that have not returned
+	stopped    bool          // whether the scheduler has stopped
+}
+
+// coroutine is a goroutine spawned by a simScheduler.
+type coroutine struct {
+	resume   chan struct{} // signaled when the scheduler resumes the coroutine
+	deadline time.Duration // virtual time at which its timeout expires
+	timedOut bool          // whether it was resumed because its timeout expired
+	channel  *simChannel   // channel it is blocked on, or nil
+}
+
+// newSimScheduler returns a new simScheduler whose virtual clock reads zero.
+func newSimScheduler() *simScheduler {
+	return &simScheduler{yield: make(chan struct{})}
+}
+
+// run runs f and the coroutines it spawns until f returns or ctx is done, and
+// then stops the scheduler and runs the coroutines until they return. If every
+// coroutine is blocked forever before then, run stops the scheduler just the
+// same, so that the coroutines find their channels closed, and returns an
+// error.
+func (s *simScheduler) run(ctx context.Context, f func()) error {
+	done := false
+	s.spawn(func() {
+		f()
+		done = true
+	})
+
+	var err error
+	for s.live > 0 {
+		if done || ctx.Err() != nil {
+			s.stop()
+		}
+
+		if len(s.runnable) == 0 && !s.wake() {
+			err = fmt.Errorf("the run deadlocked at %s: all coroutines are "+
+				"blocked without a timeout", s.clock)
+			s.stop()
+			continue
+		}
+
+		s.current, s.runnable = s.runnable[0], s.runnable[1:]
+		s.current.resume <- struct{}{}
+		<-s.yield
+	}
+	return err
+}
+
+func (s *simScheduler) spawn(f func()) {
+	co := &coroutine{resume: make(chan struct{})}
+	s.coroutines = append(s.coroutines, co)
+	s.runnable = append(s.runnable, co)
+	s.live++
+
+	go func() {
+		<-co.resume
+		f()
+		s.live--
+		s.yield <- struct{}{}
+	}()
+}
+
+func (s *simScheduler) now() time.Duration {
+	return s.clock
+}
+
+func (s *simScheduler) sleep(d time.Duration) {
+	s.newChannel(0).receiveTimeout(d)
+}
+
+// newChannel returns a new simChannel. Since a blocked sender could never
+// yield, simChannels are unbounded and size is ignored.
+func (s *simScheduler) newChannel(size int) channel {
+	return &simChannel{s: s}
+}
+
+// stop stops the scheduler, and makes every blocked coroutine runnable, so that
+// it finds its channel closed.
+func (s *simScheduler) stop() {
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+
+	for _, co := range s.coroutines {
+		if co.channel != nil {
+			co.channel.removeWaiter(co)
+			s.ready(co)
+		}
+	}
+}
+
+// wake advances the virtual clock to the earliest deadline of the sleeping
+// coroutines and makes the coroutine with that deadline runnable. Of several
+// coroutines with the same deadline, the one that went to sleep first wakes.
+// wake returns false if no coroutine is sleeping.
+func (s *simScheduler) wake() bool {
+	if len(s.sleeping) == 0 {
+		return false
+	}
+
+	earliest := s.sleeping[0]
+	for _, co := range s.sleeping[1:] {
+		if co.deadline < earliest.deadline {
+			earliest = co
+		}
+	}
+
+	if earliest.deadline > s.clock {
+		s.clock = earliest.deadline
+	}
+	earliest.timedOut = true
+	earliest.channel.removeWaiter(earliest)
+	s.ready(earliest)
+
+	return true
+}
+
+// ready makes the blocked coroutine co runnable.
+func (s *simScheduler) ready(co *coroutine) {
+	for i, sleeper := range s.sleeping {
+		if sleeper == co {
+			s.sleeping = append(s.sleeping[:i], s.sleeping[i+1:]...)
+			break
+		}
+	}
+	co.channel = nil
+	s.runnable = append(s.runnable, co)
+}
+
+// block yields from the running coroutine co to the scheduler, and waits until
+// the scheduler resumes co.
+func (s *simScheduler) block(co *coroutine) {
+	s.yield <- struct{}{}
+	<-co.resume
+}
+
+// simChannel is the channel type of a simScheduler.
+type simChannel struct {
+	s       *simScheduler
+	buf     []Message    // messages sent but not yet received
+	waiters []*coroutine // coroutines blocked receiving, in FIFO order
+	closed  bool
+}
+
+func (c *simChannel) send(m Message) {
+	c.trySend(m)
+}
+
+func (c *simChannel) trySend(m Message) bool {
+	if c.s.stopped {
+		return false
+	}
+	c.buf = append(c.buf, m)
+	c.wakeWaiter()
+	return true
+}
+
+func (c *simChannel) receive() Message {
+	m, _ := c.await(false, 0)
+	return m
+}
+
+func (c *simChannel) receiveTimeout(timeout time.Duration) (Message, bool) {
+	return c.await(true, c.s.clock+timeout)
+}
+
+func (c *simChannel) close() {
+	c.closed = true
+	for len(c.waiters) > 0 {
+		c.wakeWaiter()
+	}
+}
+
+// await blocks the running coroutine until c contains a message or is closed,
+// or, if timed is true, until the virtual clock reaches deadline. Once the
+// scheduler stops, c is closed.
+func (c *simChannel) await(timed bool, deadline time.Duration) (Message, bool) {
+	s := c.s
+	co := s.current
+
+	for len(c.buf) == 0 && !c.closed && !s.stopped {
+		if timed && deadline <= s.clock {
+			return nil, false
+		}
+
+		co.timedOut = false
+		co.channel = c
+		c.waiters = append(c.waiters, co)
+		if timed {
+			co.deadline = deadline
+			s.sleeping = append(s.sleeping, co)
+		}
+
+		s.block(co)
+
+		if co.timedOut {
+			return nil, false
+		}
+	}
+
+	if len(c.buf) == 0 || s.stopped {
+		return nil, true // closed
+	}
+
+	m := c.buf[0]
+	c.buf = c.buf[1:]
+	return m, true
+}
+
+// wakeWaiter makes the coroutine that has waited longest on c runnable.
+func (c *simChannel) wakeWaiter() {
+	if len(c.waiters) == 0 {
+		return
+	}
+	co := c.waiters[0]
+	c.waiters = c.waiters[1:]
+	c.s.ready(co)
+}
+
+// removeWaiter removes co from the coroutines waiting on c.
+func (c *simChannel) removeWaiter(co *coroutine) {
+	for i, waiter := range c.waiters {
+		if waiter == co {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
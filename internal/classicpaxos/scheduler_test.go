@@ -0,0 +1,116 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThatSimulatedRunsWithSameSeedAreIdentical(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		var outputs [2]bytes.Buffer
+
+		for i := range outputs {
+			c := Config{NProposers: 10, NAcceptors: 5,
+				ProposerTimeout: 100 * time.Millisecond,
+				ChannelTimeout:  10 * time.Millisecond,
+				Buffer:          2, Drop: 0.1, Seed: seed, Output: &outputs[i]}
+
+			if err := c.Run(); err != nil {
+				t.Fatalf("with seed %d, got %v", seed, err)
+			}
+		}
+
+		if outputs[0].String() != outputs[1].String() {
+			t.Errorf("with seed %d, two runs printed different output", seed)
+		}
+	}
+}
+
+func TestThatSimulatedRunsUseVirtualTime(t *testing.T) {
+	var output bytes.Buffer
+
+	c := Config{NProposers: 3, NAcceptors: 3, ProposerTimeout: time.Hour,
+		ChannelTimeout: time.Minute, Buffer: 2, Drop: 0.3, Seed: 1,
+		Output: &output}
+
+	start := time.Now()
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("run took %s, want virtual timeouts to take no real time", elapsed)
+	}
+}
+
+func TestThatSimChannelTimesOut(t *testing.T) {
+	s := newSimScheduler()
+	c := s.newChannel(1)
+	never := s.newChannel(0) // channel on which nothing is sent
+
+	var received, timedOut time.Duration
+
+	s.run(context.Background(), func() {
+		s.spawn(func() {
+			never.receiveTimeout(time.Hour) // sleep for an hour
+			c.send(testMessage{})
+		})
+
+		if _, ok := c.receiveTimeout(time.Minute); ok {
+			t.Errorf("received message before timeout")
+		}
+		timedOut = s.now()
+
+		c.receive()
+		received = s.now()
+	})
+
+	if timedOut != time.Minute {
+		t.Errorf("timed out at %s, want %s", timedOut, time.Minute)
+	}
+	if received != time.Hour {
+		t.Errorf("received message at %s, want %s", received, time.Hour)
+	}
+}
+
+func TestThatSimulatedDeadlocksAreReportedRatherThanPanicking(t *testing.T) {
+	s := newSimScheduler()
+	never := s.newChannel(0) // channel on which nothing is sent
+	stopped := 0
+
+	err := s.run(context.Background(), func() {
+		s.spawn(func() {
+			if never.receive() == nil {
+				stopped++
+			}
+		})
+		s.sleep(time.Minute)
+		if never.receive() == nil {
+			stopped++
+		}
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "deadlocked at 1m0s") {
+		t.Errorf("got %v, want an error about a deadlock after a minute", err)
+	}
+	if stopped != 2 {
+		t.Errorf("%d coroutine/s found the channel closed, want 2", stopped)
+	}
+}
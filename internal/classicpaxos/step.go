@@ -0,0 +1,472 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// output is a message that a step of the proposer or acceptor algorithm sends.
+type output struct {
+	to  Node    // receiver
+	msg Message // message sent
+}
+
+// String returns the string form of an output.
+func (o output) String() string {
+	return fmt.Sprintf("%s to %s", o.msg, o.to)
+}
+
+// acceptorCore holds what the acceptor algorithm knows besides its state.
+// Acceptors and the model checker both take its steps.
+type acceptorCore struct {
+	id        int  // acceptor identifier
+	nLearners int  // number of learners
+	noRejects bool // whether to ignore, rather than reject, earlier epochs
+}
+
+// step is the acceptor algorithm for Classic Paxos as a pure function. It
+// returns the state of the acceptor after receiving m in state s, and the
+// messages that the acceptor sends in reply. Unless it replies with a reject
+// message, the acceptor must save the returned state before sending them. A
+// message that the acceptor ignores or rejects leaves its state alone. In Fast
+// Paxos, step also handles the coordinator's any message, after which the
+// acceptor need not save its state, as it sends nothing, and the clients'
+// values. A learner's query leaves the state alone, so the acceptor need not
+// save it before answering with the proposal that it accepted last, if any.
+func (a acceptorCore) step(s AcceptorState, m Message) (AcceptorState,
+	[]output) {
+
+	switch msg := m.(type) {
+	case prepare:
+		if s.PromisedEpoch.Nil() || msg.epoch.Cmp(s.PromisedEpoch) >= 0 {
+			s.PromisedEpoch = msg.epoch
+			return s, []output{{to: ProposerNode(msg.proposerID),
+				msg: promise{acceptorID: a.id, epoch: msg.epoch,
+					acceptedEpoch: s.AcceptedEpoch,
+					acceptedValue: s.AcceptedValue}}}
+		}
+		return s, a.reject(s, msg.epoch, msg.proposerID)
+	case propose:
+		if s.PromisedEpoch.Nil() || msg.epoch.Cmp(s.PromisedEpoch) >= 0 {
+			s.PromisedEpoch = msg.epoch
+			s.AcceptedEpoch, s.AcceptedValue = msg.epoch, msg.value
+			outputs := []output{{to: ProposerNode(msg.proposerID),
+				msg: accept{acceptorID: a.id, epoch: msg.epoch}}}
+			for l := 0; l < a.nLearners; l++ {
+				outputs = append(outputs, output{to: LearnerNode(l),
+					msg: accepted{epoch: msg.epoch, value: msg.value,
+						acceptorID: a.id}})
+			}
+			return s, outputs
+		}
+		return s, a.reject(s, msg.epoch, msg.proposerID)
+	case anyValue:
+		if s.PromisedEpoch.Nil() || msg.epoch.Cmp(s.PromisedEpoch) >= 0 {
+			s.PromisedEpoch = msg.epoch
+			return s, nil
+		}
+		return s, a.reject(s, msg.epoch, msg.proposerID)
+	case clientValue:
+		// only the coordinator's any message promises the fast epoch, and the
+		// acceptor accepts just the first value sent in it
+		if !isFastEpoch(s.PromisedEpoch) || isFastEpoch(s.AcceptedEpoch) {
+			break
+		}
+		s.AcceptedEpoch, s.AcceptedValue = s.PromisedEpoch, msg.value
+		vote := accepted{epoch: s.AcceptedEpoch, value: msg.value,
+			acceptorID: a.id}
+		outputs := []output{{to: ProposerNode(fastCoordinator), msg: vote}}
+		if msg.proposerID != fastCoordinator {
+			outputs = append(outputs, output{to: ProposerNode(msg.proposerID),
+				msg: vote})
+		}
+		for l := 0; l < a.nLearners; l++ {
+			outputs = append(outputs, output{to: LearnerNode(l), msg: vote})
+		}
+		return s, outputs
+
+	case query:
+		if s.AcceptedEpoch.Nil() {
+			break
+		}
+		return s, []output{{to: LearnerNode(msg.learnerID),
+			msg: accepted{epoch: s.AcceptedEpoch, value: s.AcceptedValue,
+				acceptorID: a.id}}}
+	}
+	return s, nil
+}
+
+// reject returns the reply of an acceptor in state s to a message for epoch
+// from the proposer numbered proposerID, which epoch is less than the
+// promised epoch: a reject message, unless a.noRejects.
+func (a acceptorCore) reject(s AcceptorState, epoch Epoch,
+	proposerID int) []output {
+
+	if a.noRejects {
+		return nil
+	}
+	return []output{{to: ProposerNode(proposerID), msg: reject{epoch: epoch,
+		promisedEpoch: s.PromisedEpoch, acceptorID: a.id}}}
+}
+
+// proposalStart is the input that starts a proposer proposing a candidate
+// value.
+type proposalStart struct {
+	value Value // candidate value
+}
+
+// String returns the string form of a proposal start.
+func (p proposalStart) String() string {
+	return fmt.Sprintf("start proposing %s", p.value)
+}
+
+// proposalTimeout is the input that tells a proposer it has waited longer
+// than its timeout for the replies to its current phase.
+type proposalTimeout struct{}
+
+// String returns the string form of a proposal timeout.
+func (proposalTimeout) String() string {
+	return "timeout"
+}
+
+// Phases of a proposer.
+const (
+	proposerIdle    = iota // proposer has yet to start proposing
+	proposerPhase1         // proposer awaits promises
+	proposerPhase2         // proposer awaits accepts
+	proposerDecided        // proposer believes its value is decided
+	proposerFast           // coordinator awaits votes in the fast epoch
+)
+
+// proposerCore holds what the proposer algorithm knows besides its state.
+// Proposers and the model checker both take its steps.
+type proposerCore struct {
+	id         int          // proposer identifier
+	nProposers int          // number of proposers
+	nAcceptors int          // number of acceptors
+	quorums    QuorumSystem // which acceptors form quorums in each phase
+	epochs     EpochScheme  // which epochs the proposer uses
+}
+
+// proposerState holds the variables of the proposer algorithm. Steps never
+// modify the maps of a state, but replace them, so that a state may be kept
+// and compared with later ones.
+type proposerState struct {
+	round     int          // number of rounds the proposer has started
+	phase     int          // one of the proposer phases
+	epoch     Epoch        // epoch of the current round
+	candidate Value        // candidate value
+	value     Value        // current proposal value
+	maxEpoch  Epoch        // maximum epoch received in phase 1
+	promised  map[int]bool // keys are acceptors that have promised
+	accepted  map[int]bool // keys are acceptors that have accepted
+
+	// in Fast Paxos, the acceptors that have accepted each value in the fast
+	// epoch, or in maxEpoch in phase 1; keys are values, then acceptors
+	votes map[Value]map[int]bool
+
+	// in Cheap Paxos, whether the proposer sends to the auxiliary acceptors
+	// as well as the main ones
+	auxiliary bool
+}
+
+// String returns the string form of a proposer state.
+func (s proposerState) String() string {
+	str := fmt.Sprintf("round %d phase %d epoch %s value %s max epoch %s "+
+		"promised %v accepted %v", s.round, s.phase, s.epoch, s.value, s.maxEpoch,
+		s.promised, s.accepted)
+	if s.votes != nil {
+		str += fmt.Sprintf(" votes %v", s.votes)
+	}
+	if s.auxiliary {
+		str += " auxiliary"
+	}
+	return str
+}
+
+// step is the proposer algorithm for Classic Paxos as a pure function. It
+// returns the state of the proposer after the input in in state s, and the
+// messages that the proposer sends. The input is a proposalStart, a
+// proposalTimeout, or a message from an acceptor. A reject message for the
+// current epoch ends the round at once, and the next round skips to an epoch
+// greater than the one that the rejecting acceptor has promised. The proposer
+// has decided s.value once s.phase is proposerDecided, after which it ignores
+// every input but a proposalStart.
+//
+// With fastQuorums, the coordinator of Fast Paxos starts in the fast epoch
+// rather than phase 1, and counts the acceptors' votes. Once it finds that no
+// value can gain a fast quorum, or times out, it recovers in a classic epoch,
+// in which it proposes the value with the most votes in the greatest epoch
+// that the promises report.
+//
+// With cheapQuorums, the proposer sends only to the main acceptors, until a
+// round times out, after which it sends to the auxiliary acceptors too.
+//
+// With a reconfiguration, the quorums and the acceptors to which the proposer
+// sends depend on the configurations of the current epoch and those before it.
+func (p proposerCore) step(s proposerState, in Message) (proposerState,
+	[]output) {
+
+	switch msg := in.(type) {
+	case proposalStart:
+		s.candidate = msg.value
+		if p.fast() > 0 && p.id == fastCoordinator && s.epoch.Nil() {
+			return p.openFastEpoch(s)
+		}
+		return p.startRound(s, s.epoch)
+
+	case proposalTimeout:
+		if s.phase == proposerPhase1 || s.phase == proposerPhase2 ||
+			s.phase == proposerFast {
+
+			s.auxiliary = p.auxiliary() > 0 // a main acceptor may be down
+			return p.startRound(s, s.epoch)
+		}
+
+	case reject:
+		if (s.phase == proposerPhase1 || s.phase == proposerPhase2 ||
+			s.phase == proposerFast) && msg.epoch.Cmp(s.epoch) == 0 {
+
+			return p.startRound(s, msg.promisedEpoch)
+		}
+
+	case accepted:
+		if s.phase != proposerFast || msg.epoch.Cmp(s.epoch) != 0 {
+			break
+		}
+		s.votes = withVote(s.votes, msg.value, msg.acceptorID)
+		if len(s.votes[msg.value]) >= p.fast() {
+			s.phase, s.value = proposerDecided, msg.value
+		} else if p.collided(s.votes) {
+			return p.startRound(s, s.epoch)
+		}
+
+	case promise:
+		// a promise for an earlier epoch does not bind its acceptor to this
+		// epoch, so it must not count towards the quorum
+		if s.phase != proposerPhase1 || msg.epoch.Cmp(s.epoch) != 0 {
+			break
+		}
+		s.promised = with(s.promised, msg.acceptorID)
+		if !msg.acceptedEpoch.Nil() &&
+			(s.maxEpoch.Nil() || msg.acceptedEpoch.Cmp(s.maxEpoch) > 0) {
+
+			// (maxEpoch, value) is the greatest proposal received
+			s.maxEpoch = msg.acceptedEpoch
+			s.value = msg.acceptedValue
+			s.votes = nil
+		}
+		if p.fast() > 0 && !msg.acceptedEpoch.Nil() &&
+			msg.acceptedEpoch.Cmp(s.maxEpoch) == 0 {
+
+			s.votes = withVote(s.votes, msg.acceptedValue, msg.acceptorID)
+		}
+		if !isPhase1Quorum(p.quorums, s.epoch, s.promised) {
+			break
+		}
+		if s.votes != nil {
+			// a value chosen in the fast epoch has most of the votes
+			s.value = mostVoted(s.votes)
+		}
+
+		if s.value.Nil() {
+			// no proposals were received thus propose candidate value
+			s.value = s.candidate
+		}
+
+		// start phase 2 for proposal (epoch, value)
+		s.phase = proposerPhase2
+		return s, p.toAcceptors(s, propose{epoch: s.epoch, value: s.value,
+			proposerID: p.id})
+
+	case accept:
+		if s.phase != proposerPhase2 || msg.epoch.Cmp(s.epoch) != 0 {
+			break
+		}
+		s.accepted = with(s.accepted, msg.acceptorID)
+		if isChosen(p.quorums, s.epoch, s.accepted) {
+			s.phase = proposerDecided
+		}
+	}
+	return s, nil
+}
+
+// startRound returns the state of the proposer once it starts a new round in
+// state s, in its least epoch greater than after, and the prepare messages
+// that it sends.
+func (p proposerCore) startRound(s proposerState,
+	after Epoch) (proposerState, []output) {
+
+	s = proposerState{
+		round:     s.round + 1,
+		phase:     proposerPhase1,
+		epoch:     p.nextEpoch(after),
+		candidate: s.candidate,
+		promised:  map[int]bool{},
+		accepted:  map[int]bool{},
+		auxiliary: s.auxiliary,
+	}
+	return s, p.toAcceptors(s, prepare{epoch: s.epoch, proposerID: p.id})
+}
+
+// nextEpoch returns the least epoch of the proposer that is greater than e,
+// or its first epoch if e is nil. Unlike Epoch.Next, it may skip rounds, as e
+// need not be an epoch of the proposer.
+func (p proposerCore) nextEpoch(e Epoch) Epoch {
+	if e.Nil() {
+		return p.epochs.epoch(0, p.id, p.nProposers)
+	}
+
+	if p.epochs == PairEpochs {
+		// the least (r, p.id) > (round, proposer)
+		round, proposer := e.pair()
+		r := new(big.Int).Set(round)
+		if proposer >= p.id {
+			r.Add(r, big.NewInt(1))
+		}
+		return Epoch{i: r, proposer: p.id}
+	}
+
+	// the least i > e.i such that i = p.id modulo p.nProposers
+	n := big.NewInt(int64(p.nProposers))
+	i := new(big.Int).Sub(e.i, big.NewInt(int64(p.id)))
+	i.Div(i, n) // rounds down, even if i is negative
+	i.Add(i, big.NewInt(1))
+	i.Mul(i, n)
+	i.Add(i, big.NewInt(int64(p.id)))
+	return Epoch{i: i, nProposers: p.nProposers}
+}
+
+// fast returns the size of a fast quorum, or 0 in Classic Paxos.
+func (p proposerCore) fast() int {
+	if q, ok := p.quorums.(fastQuorums); ok {
+		return q.Fast
+	}
+	return 0
+}
+
+// auxiliary returns the number of auxiliary acceptors in Cheap Paxos, or zero
+// if the run is not of Cheap Paxos.
+func (p proposerCore) auxiliary() int {
+	if q, ok := p.quorums.(cheapQuorums); ok {
+		return q.Auxiliary
+	}
+	return 0
+}
+
+// reconfiguration returns the configurations of a run with reconfigurations,
+// or nil if the run does not reconfigure.
+func (p proposerCore) reconfiguration() *reconfiguration {
+	r, _ := p.quorums.(*reconfiguration)
+	return r
+}
+
+// openFastEpoch returns the state of the coordinator once it opens the fast
+// epoch in state s, and the any messages that it sends. The coordinator
+// proposes its candidate value only if it recovers in a classic epoch and
+// finds no votes.
+func (p proposerCore) openFastEpoch(s proposerState) (proposerState,
+	[]output) {
+
+	s = proposerState{
+		round:     s.round + 1,
+		phase:     proposerFast,
+		epoch:     p.nextEpoch(Epoch{}),
+		candidate: s.candidate,
+		promised:  map[int]bool{},
+		accepted:  map[int]bool{},
+		votes:     map[Value]map[int]bool{},
+	}
+	return s, p.toAcceptors(s, anyValue{epoch: s.epoch, proposerID: p.id})
+}
+
+// collided returns true if and only if, given the votes in the fast epoch, no
+// value can gain a fast quorum, even if every acceptor yet to vote votes for
+// it.
+func (p proposerCore) collided(votes map[Value]map[int]bool) bool {
+	voted := 0
+	for _, acceptors := range votes {
+		voted += len(acceptors)
+	}
+	for _, acceptors := range votes {
+		if len(acceptors)+p.nAcceptors-voted >= p.fast() {
+			return false
+		}
+	}
+	return true
+}
+
+// toAcceptors returns the outputs that send m to every acceptor, but for the
+// auxiliary acceptors of Cheap Paxos unless s.auxiliary. With a
+// reconfiguration, it sends m to the acceptors of the configurations that
+// matter to m's phase in s.epoch instead.
+func (p proposerCore) toAcceptors(s proposerState, m Message) []output {
+	if r := p.reconfiguration(); r != nil {
+		_, phase1 := m.(prepare)
+		var outputs []output
+		for _, a := range r.acceptors(s.epoch, phase1) {
+			outputs = append(outputs, output{to: AcceptorNode(a), msg: m})
+		}
+		return outputs
+	}
+	n := p.nAcceptors
+	if !s.auxiliary {
+		n -= p.auxiliary()
+	}
+	outputs := make([]output, n)
+	for a := range outputs {
+		outputs[a] = output{to: AcceptorNode(a), msg: m}
+	}
+	return outputs
+}
+
+// with returns a copy of the set s with k added.
+func with(s map[int]bool, k int) map[int]bool {
+	t := make(map[int]bool, len(s)+1)
+	for i := range s {
+		t[i] = true
+	}
+	t[k] = true
+	return t
+}
+
+// withVote returns a copy of votes in which the acceptor k has voted for
+// value.
+func withVote(votes map[Value]map[int]bool, value Value,
+	k int) map[Value]map[int]bool {
+
+	w := make(map[Value]map[int]bool, len(votes)+1)
+	for v, acceptors := range votes {
+		w[v] = acceptors
+	}
+	w[value] = with(votes[value], k)
+	return w
+}
+
+// mostVoted returns the value with the most votes, or the least such value if
+// several tie.
+func mostVoted(votes map[Value]map[int]bool) Value {
+	var best Value
+	for v, acceptors := range votes {
+		if best.Nil() || len(acceptors) > len(votes[best]) ||
+			len(acceptors) == len(votes[best]) && v.data < best.data {
+
+			best = v
+		}
+	}
+	return best
+}
@@ -0,0 +1,292 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestThatAcceptorStepFollowsTheAcceptorAlgorithm(t *testing.T) {
+	e1, e2, e3 := newEpoch(1, 2), newEpoch(2, 2), newEpoch(3, 2)
+	a := acceptorCore{id: 4, nLearners: 2}
+
+	tests := []struct {
+		name    string
+		state   AcceptorState
+		in      Message
+		want    AcceptorState
+		outputs string
+	}{
+		{"prepare with no promise is promised",
+			AcceptorState{}, prepare{epoch: e1, proposerID: 1},
+			AcceptorState{PromisedEpoch: e1},
+			"[promise(1, nil, nil) from acceptor 4 to p1]"},
+		{"prepare in a later epoch is promised",
+			AcceptorState{PromisedEpoch: e1}, prepare{epoch: e2, proposerID: 0},
+			AcceptorState{PromisedEpoch: e2},
+			"[promise(2, nil, nil) from acceptor 4 to p0]"},
+		{"prepare in the promised epoch is promised again",
+			AcceptorState{PromisedEpoch: e2}, prepare{epoch: e2, proposerID: 0},
+			AcceptorState{PromisedEpoch: e2},
+			"[promise(2, nil, nil) from acceptor 4 to p0]"},
+		{"prepare in an earlier epoch is rejected",
+			AcceptorState{PromisedEpoch: e2}, prepare{epoch: e1, proposerID: 1},
+			AcceptorState{PromisedEpoch: e2},
+			"[reject(1, 2) from acceptor 4 to p1]"},
+		{"promise carries the accepted proposal",
+			AcceptorState{PromisedEpoch: e1, AcceptedEpoch: e1, AcceptedValue: v1},
+			prepare{epoch: e3, proposerID: 1},
+			AcceptorState{PromisedEpoch: e3, AcceptedEpoch: e1, AcceptedValue: v1},
+			"[promise(3, 1, v1) from acceptor 4 to p1]"},
+		{"propose in the promised epoch is accepted and announced to learners",
+			AcceptorState{PromisedEpoch: e2}, propose{epoch: e2, value: v0},
+			AcceptorState{PromisedEpoch: e2, AcceptedEpoch: e2, AcceptedValue: v0},
+			"[accept(2) from acceptor 4 to p0 " +
+				"accepted(2, v0) from acceptor 4 to l0 " +
+				"accepted(2, v0) from acceptor 4 to l1]"},
+		{"propose in a later epoch is accepted and promised",
+			AcceptorState{PromisedEpoch: e1, AcceptedEpoch: e1, AcceptedValue: v1},
+			propose{epoch: e3, value: v1, proposerID: 1},
+			AcceptorState{PromisedEpoch: e3, AcceptedEpoch: e3, AcceptedValue: v1},
+			"[accept(3) from acceptor 4 to p1 " +
+				"accepted(3, v1) from acceptor 4 to l0 " +
+				"accepted(3, v1) from acceptor 4 to l1]"},
+		{"propose with no promise is accepted",
+			AcceptorState{}, propose{epoch: e1, value: v1, proposerID: 1},
+			AcceptorState{PromisedEpoch: e1, AcceptedEpoch: e1, AcceptedValue: v1},
+			"[accept(1) from acceptor 4 to p1 " +
+				"accepted(1, v1) from acceptor 4 to l0 " +
+				"accepted(1, v1) from acceptor 4 to l1]"},
+		{"propose in an earlier epoch is rejected",
+			AcceptorState{PromisedEpoch: e3}, propose{epoch: e2, value: v0},
+			AcceptorState{PromisedEpoch: e3},
+			"[reject(2, 3) from acceptor 4 to p0]"},
+		{"query is answered with the accepted proposal",
+			AcceptorState{PromisedEpoch: e3, AcceptedEpoch: e2, AcceptedValue: v0},
+			query{learnerID: 1},
+			AcceptorState{PromisedEpoch: e3, AcceptedEpoch: e2, AcceptedValue: v0},
+			"[accepted(2, v0) from acceptor 4 to l1]"},
+		{"query with no accepted proposal is ignored",
+			AcceptorState{PromisedEpoch: e1}, query{learnerID: 0},
+			AcceptorState{PromisedEpoch: e1},
+			"[]"},
+		{"other messages are ignored",
+			AcceptorState{PromisedEpoch: e1}, accept{epoch: e1, acceptorID: 0},
+			AcceptorState{PromisedEpoch: e1},
+			"[]"},
+	}
+
+	// without rejects, messages in earlier epochs are ignored
+	silent := acceptorCore{id: 4, nLearners: 2, noRejects: true}
+	for _, m := range []Message{prepare{epoch: e1}, propose{epoch: e1}} {
+		got, outputs := silent.step(AcceptorState{PromisedEpoch: e2}, m)
+		if formatRecord(got) != formatRecord(AcceptorState{PromisedEpoch: e2}) ||
+			len(outputs) != 0 {
+			t.Errorf("acceptor without rejects replied to %s with %v", m, outputs)
+		}
+	}
+
+	for _, test := range tests {
+		got, outputs := a.step(test.state, test.in)
+		if formatRecord(got) != formatRecord(test.want) {
+			t.Errorf("%s: got state %q, want %q", test.name, formatRecord(got),
+				formatRecord(test.want))
+		}
+		if fmt.Sprint(outputs) != test.outputs {
+			t.Errorf("%s: got outputs %v, want %s", test.name, outputs,
+				test.outputs)
+		}
+	}
+}
+
+func TestThatProposerStepFollowsTheProposerAlgorithm(t *testing.T) {
+	e0, e2, e3, e5, e7 := newEpoch(0, 2), newEpoch(2, 2), newEpoch(3, 2),
+		newEpoch(5, 2), newEpoch(7, 2)
+
+	// every acceptor must promise, but any 2 may accept
+	p := proposerCore{id: 1, nProposers: 2, nAcceptors: 3,
+		quorums: Flexible{Phase1: 3, Phase2: 2}}
+
+	set := func(acceptors ...int) map[int]bool {
+		s := map[int]bool{}
+		for _, a := range acceptors {
+			s[a] = true
+		}
+		return s
+	}
+
+	// states of the proposer in its third round, in epoch 5
+	phase1 := func(promised map[int]bool, maxEpoch Epoch,
+		value Value) proposerState {
+
+		return proposerState{round: 3, phase: proposerPhase1, epoch: e5,
+			candidate: v1, value: value, maxEpoch: maxEpoch,
+			promised: promised, accepted: set()}
+	}
+	phase2 := func(accepted map[int]bool) proposerState {
+		return proposerState{round: 3, phase: proposerPhase2, epoch: e5,
+			candidate: v1, value: v0, maxEpoch: e2,
+			promised: set(0, 1, 2), accepted: accepted}
+	}
+	decided := phase2(set(0, 2))
+	decided.phase = proposerDecided
+	round4 := proposerState{round: 4, phase: proposerPhase1, epoch: e7,
+		candidate: v1, promised: set(), accepted: set()}
+	prepare7 := "[prepare(7) from proposer 1 to a0 " +
+		"prepare(7) from proposer 1 to a1 prepare(7) from proposer 1 to a2]"
+
+	tests := []struct {
+		name    string
+		state   proposerState
+		in      Message
+		want    proposerState
+		outputs string
+	}{
+		{"start selects the proposer's first epoch and prepares it",
+			proposerState{}, proposalStart{value: v1},
+			proposerState{round: 1, phase: proposerPhase1,
+				epoch: newEpoch(1, 2), candidate: v1, promised: set(),
+				accepted: set()},
+			"[prepare(1) from proposer 1 to a0 " +
+				"prepare(1) from proposer 1 to a1 prepare(1) from proposer 1 to a2]"},
+		{"start after earlier proposals selects a later epoch",
+			decided, proposalStart{value: v2},
+			proposerState{round: 4, phase: proposerPhase1, epoch: e7,
+				candidate: v2, promised: set(), accepted: set()},
+			prepare7},
+		{"promise is counted",
+			phase1(set(), Epoch{}, Value{}),
+			promise{epoch: e5, acceptorID: 2},
+			phase1(set(2), Epoch{}, Value{}),
+			"[]"},
+		{"promise carrying a proposal sets the proposal value",
+			phase1(set(2), Epoch{}, Value{}),
+			promise{epoch: e5, acceptedEpoch: e2, acceptedValue: v0,
+				acceptorID: 0},
+			phase1(set(0, 2), e2, v0),
+			"[]"},
+		{"promise carrying a later proposal replaces the proposal value",
+			phase1(set(0), e0, v0),
+			promise{epoch: e5, acceptedEpoch: e3, acceptedValue: v1,
+				acceptorID: 2},
+			phase1(set(0, 2), e3, v1),
+			"[]"},
+		{"promise carrying an earlier proposal keeps the proposal value",
+			phase1(set(0), e3, v1),
+			promise{epoch: e5, acceptedEpoch: e2, acceptedValue: v0,
+				acceptorID: 2},
+			phase1(set(0, 2), e3, v1),
+			"[]"},
+		{"repeated promise from an acceptor is counted once",
+			phase1(set(2), Epoch{}, Value{}),
+			promise{epoch: e5, acceptorID: 2},
+			phase1(set(2), Epoch{}, Value{}),
+			"[]"},
+		{"promise for an earlier epoch is ignored",
+			phase1(set(2), Epoch{}, Value{}),
+			promise{epoch: e3, acceptorID: 0},
+			phase1(set(2), Epoch{}, Value{}),
+			"[]"},
+		{"quorum of promises without proposals proposes the candidate value",
+			phase1(set(1, 2), Epoch{}, Value{}),
+			promise{epoch: e5, acceptorID: 0},
+			proposerState{round: 3, phase: proposerPhase2, epoch: e5,
+				candidate: v1, value: v1, promised: set(0, 1, 2),
+				accepted: set()},
+			"[propose(5, v1) from proposer 1 to a0 " +
+				"propose(5, v1) from proposer 1 to a1 " +
+				"propose(5, v1) from proposer 1 to a2]"},
+		{"quorum of promises with proposals proposes the greatest",
+			phase1(set(1, 2), e2, v0),
+			promise{epoch: e5, acceptorID: 0},
+			phase2(set()),
+			"[propose(5, v0) from proposer 1 to a0 " +
+				"propose(5, v0) from proposer 1 to a1 " +
+				"propose(5, v0) from proposer 1 to a2]"},
+		{"promise in phase 2 is ignored",
+			phase2(set()),
+			promise{epoch: e5, acceptorID: 2},
+			phase2(set()),
+			"[]"},
+		{"accept is counted",
+			phase2(set()),
+			accept{epoch: e5, acceptorID: 0},
+			phase2(set(0)),
+			"[]"},
+		{"accept for another epoch is ignored",
+			phase2(set(0)),
+			accept{epoch: e3, acceptorID: 2},
+			phase2(set(0)),
+			"[]"},
+		{"accept in phase 1 is ignored",
+			phase1(set(0), Epoch{}, Value{}),
+			accept{epoch: e5, acceptorID: 0},
+			phase1(set(0), Epoch{}, Value{}),
+			"[]"},
+		{"quorum of accepts decides the value",
+			phase2(set(0)),
+			accept{epoch: e5, acceptorID: 2},
+			decided,
+			"[]"},
+		{"timeout in phase 1 starts a new round",
+			phase1(set(0), e2, v0), proposalTimeout{}, round4, prepare7},
+		{"timeout in phase 2 starts a new round",
+			phase2(set(2)), proposalTimeout{}, round4, prepare7},
+		{"reject in phase 1 skips to an epoch greater than the promised one",
+			phase1(set(0), e2, v0),
+			reject{epoch: e5, promisedEpoch: newEpoch(10, 2), acceptorID: 1},
+			proposerState{round: 4, phase: proposerPhase1,
+				epoch: newEpoch(11, 2), candidate: v1, promised: set(),
+				accepted: set()},
+			"[prepare(11) from proposer 1 to a0 " +
+				"prepare(11) from proposer 1 to a1 " +
+				"prepare(11) from proposer 1 to a2]"},
+		{"reject in phase 2 skips to an epoch greater than the promised one",
+			phase2(set(0)),
+			reject{epoch: e5, promisedEpoch: newEpoch(6, 2), acceptorID: 1},
+			round4, prepare7},
+		{"reject for an earlier epoch is ignored",
+			phase1(set(0), e2, v0),
+			reject{epoch: e3, promisedEpoch: newEpoch(4, 2), acceptorID: 1},
+			phase1(set(0), e2, v0),
+			"[]"},
+		{"reject once decided is ignored",
+			decided, reject{epoch: e5, promisedEpoch: e7, acceptorID: 1},
+			decided, "[]"},
+		{"timeout once decided is ignored",
+			decided, proposalTimeout{}, decided, "[]"},
+		{"other messages are ignored",
+			phase1(set(), Epoch{}, Value{}),
+			prepare{epoch: e7, proposerID: 0},
+			phase1(set(), Epoch{}, Value{}),
+			"[]"},
+	}
+
+	for _, test := range tests {
+		before := test.state.String()
+		got, outputs := p.step(test.state, test.in)
+		if got.String() != test.want.String() {
+			t.Errorf("%s: got state %s, want %s", test.name, got, test.want)
+		}
+		if fmt.Sprint(outputs) != test.outputs {
+			t.Errorf("%s: got outputs %v, want %s", test.name, outputs,
+				test.outputs)
+		}
+		if test.state.String() != before {
+			t.Errorf("%s: step modified the state it was given", test.name)
+		}
+	}
+}
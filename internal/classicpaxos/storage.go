@@ -0,0 +1,225 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AcceptorState holds the variables that an acceptor must remember across
+// restarts.
+type AcceptorState struct {
+	PromisedEpoch Epoch // last promised epoch
+	AcceptedEpoch Epoch // last accepted epoch
+	AcceptedValue Value // last accepted value
+}
+
+// Storage is where an acceptor keeps its state.
+type Storage interface {
+	// Load returns the state most recently saved, or the zero state if no
+	// state has been saved.
+	Load() (AcceptorState, error)
+
+	// Save saves the state s. It returns only once s would survive a crash of
+	// the acceptor.
+	Save(s AcceptorState) error
+
+	// Close releases any resources held by the storage.
+	Close() error
+}
+
+// memoryStorage is a Storage that keeps state in memory. It survives a restart
+// of the acceptor's goroutine, but not of the process.
+type memoryStorage struct {
+	state AcceptorState
+}
+
+// NewMemoryStorage returns a Storage that keeps state in memory.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{}
+}
+
+func (m *memoryStorage) Load() (AcceptorState, error) {
+	return m.state, nil
+}
+
+func (m *memoryStorage) Save(s AcceptorState) error {
+	m.state = s
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
+
+// fileStorage is a Storage that appends each saved state as a record to a
+// log file, and syncs the file before returning from save. A record is a line
+// of the form
+//
+//   <promisedEpoch> <acceptedEpoch> <quoted acceptedValue>
+//
+// where the accepted value is nil if and only if the accepted epoch is, and
+// the bytes of any other value are quoted as a Go string. The state is that
+// of the last complete record. A final record without a newline was torn by a
+// crash during save, and is discarded on load.
+type fileStorage struct {
+	file *os.File
+}
+
+// OpenFileStorage returns a Storage that logs state to the file at path,
+// creating the file if necessary.
+func OpenFileStorage(path string) (Storage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStorage{file: file}, nil
+}
+
+func (f *fileStorage) Load() (AcceptorState, error) {
+	var s AcceptorState
+
+	data, err := os.ReadFile(f.file.Name())
+	if err != nil {
+		return s, err
+	}
+
+	// discard a torn record, if any
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		if err := f.file.Truncate(int64(complete)); err != nil {
+			return s, err
+		}
+		data = data[:complete]
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 2 {
+		return s, nil // no complete records
+	}
+
+	last := lines[len(lines)-2]
+	if s, err = parseRecord(last); err != nil {
+		return s, fmt.Errorf("%s: %v", f.file.Name(), err)
+	}
+
+	return s, nil
+}
+
+func (f *fileStorage) Save(s AcceptorState) error {
+	if _, err := f.file.WriteString(formatRecord(s)); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *fileStorage) Close() error {
+	return f.file.Close()
+}
+
+// formatRecord returns the log record for the state s.
+func formatRecord(s AcceptorState) string {
+	return fmt.Sprintf("%s %s %s\n", formatEpoch(s.PromisedEpoch),
+		formatEpoch(s.AcceptedEpoch), strconv.Quote(s.AcceptedValue.data))
+}
+
+// parseRecord returns the state in the log record line.
+func parseRecord(line string) (AcceptorState, error) {
+	var s AcceptorState
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return s, fmt.Errorf("malformed record %q", line)
+	}
+
+	var err error
+	if s.PromisedEpoch, err = parseEpoch(fields[0]); err != nil {
+		return s, err
+	}
+	if s.AcceptedEpoch, err = parseEpoch(fields[1]); err != nil {
+		return s, err
+	}
+	value, err := strconv.Unquote(fields[2])
+	if err != nil {
+		return s, fmt.Errorf("malformed value %s", fields[2])
+	}
+	if !s.AcceptedEpoch.Nil() {
+		s.AcceptedValue = stringValue(value)
+	}
+
+	return s, nil
+}
+
+// formatEpoch returns the form of e in a log record: "nil" for the nil epoch,
+// the round and the proposer separated by a dot for a pair epoch, and
+// otherwise the integer and the number of proposers separated by a slash.
+func formatEpoch(e Epoch) string {
+	if e.Nil() || e.isPair() {
+		return e.String()
+	}
+	return fmt.Sprintf("%s/%d", e.i, e.nProposers)
+}
+
+// parseEpoch returns the epoch whose log record form is s.
+func parseEpoch(s string) (Epoch, error) {
+	if s == "nil" {
+		return Epoch{}, nil
+	}
+	if strings.Contains(s, ".") {
+		return parsePairEpoch(s)
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Epoch{}, fmt.Errorf("malformed epoch %q", s)
+	}
+
+	i, ok := new(big.Int).SetString(parts[0], 10)
+	if !ok || i.Sign() < 0 {
+		return Epoch{}, fmt.Errorf("malformed epoch %q", s)
+	}
+
+	nProposers, err := strconv.Atoi(parts[1])
+	if err != nil || nProposers <= 0 {
+		return Epoch{}, fmt.Errorf("malformed epoch %q", s)
+	}
+
+	return Epoch{i: i, nProposers: nProposers}, nil
+}
+
+// parsePairEpoch returns the pair epoch whose log record form is s.
+func parsePairEpoch(s string) (Epoch, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return Epoch{}, fmt.Errorf("malformed epoch %q", s)
+	}
+
+	round, ok := new(big.Int).SetString(parts[0], 10)
+	if !ok || round.Sign() < 0 {
+		return Epoch{}, fmt.Errorf("malformed epoch %q", s)
+	}
+
+	proposer, err := strconv.Atoi(parts[1])
+	if err != nil || proposer < 0 {
+		return Epoch{}, fmt.Errorf("malformed epoch %q", s)
+	}
+
+	return Epoch{i: round, proposer: proposer}, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestThatFileStorageRecoversLastSavedState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acceptor.log")
+
+	f, err := OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := []AcceptorState{
+		{PromisedEpoch: newEpoch(1, 3)},
+		{PromisedEpoch: newEpoch(2, 3), AcceptedEpoch: newEpoch(2, 3),
+			AcceptedValue: v2},
+		{PromisedEpoch: newEpoch(4, 3), AcceptedEpoch: newEpoch(2, 3),
+			AcceptedValue: stringValue("a value with spaces\n")},
+		{PromisedEpoch: newEpoch(5, 3), AcceptedEpoch: newEpoch(5, 3),
+			AcceptedValue: NewValue([]byte{0, 0xff, ' ', '\n'})},
+	}
+	for _, s := range states {
+		if err := f.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	// a torn record, as if the acceptor crashed during save
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("7/3 7/3")
+	file.Close()
+
+	f, err = OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := f.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := states[len(states)-1]
+	if formatRecord(got) != formatRecord(want) {
+		t.Errorf("recovered %q, want %q", formatRecord(got), formatRecord(want))
+	}
+
+	// the torn record must not corrupt later records
+	if err := f.Save(states[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = f.Load(); err != nil {
+		t.Fatal(err)
+	} else if formatRecord(got) != formatRecord(states[0]) {
+		t.Errorf("recovered %q, want %q", formatRecord(got),
+			formatRecord(states[0]))
+	}
+}
+
+// TestThatRecoveredAcceptorsPreserveAgreement tests that when every
+// participant restarts, acceptors that recover from their logs ensure that the
+// value agreed before the restart is agreed again.
+func TestThatRecoveredAcceptorsPreserveAgreement(t *testing.T) {
+	dir := t.TempDir()
+	agreed := regexp.MustCompile(`all the same \((.*)\)`)
+
+	var values []string
+
+	for seed := int64(1); seed <= 3; seed++ {
+		var output bytes.Buffer
+
+		// without the logs, these seeds lead to different values
+		c := Config{NProposers: 5, NAcceptors: 3,
+			ProposerTimeout: 100 * time.Millisecond,
+			ChannelTimeout:  10 * time.Millisecond,
+			Buffer:          3, Drop: 0.3, Seed: seed, WALDir: dir,
+			Output: &output}
+
+		if err := c.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		match := agreed.FindStringSubmatch(output.String())
+		if match == nil {
+			t.Fatalf("with seed %d, found no agreed value", seed)
+		}
+		values = append(values, match[1])
+	}
+
+	for i := 1; i < len(values); i++ {
+		if values[i] != values[0] {
+			t.Errorf("run %d agreed on %s, but run 0 agreed on %s", i,
+				values[i], values[0])
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpTimeout is how long a TCPTransport waits to connect to another node, or
+// to write a message to it, before losing the message.
+const tcpTimeout = time.Second
+
+// maxLineSize is the length of the longest line, and so of the longest
+// message in wire form, that a TCPTransport sends or reads. The buffer for
+// reading starts small, and grows only as longer messages arrive.
+const maxLineSize = 64 << 20
+
+// TCPTransport is a Transport that carries messages between processes over
+// TCP, one message per line in the wire form of EncodeMessage. It delivers the
+// messages that it receives to the nodes connected to it, and sends messages
+// for other nodes to the addresses added with AddPeer, or to the addresses from
+// which those nodes have sent messages. If it cannot send a message, for
+// example because the receiving process is down or the message is longer than
+// maxLineSize, the message is lost.
+type TCPTransport struct {
+	listener net.Listener
+	running  sync.WaitGroup // goroutines that have not returned
+
+	mu       sync.Mutex             // guards the fields below
+	local    map[Node]func(Message) // delivers to the nodes in this process
+	peers    map[Node]string        // addresses of the nodes in other processes
+	incoming map[net.Conn]bool      // connections on which messages arrive
+	closed   bool                   // whether the transport has closed
+	err      error                  // first error reading from a connection
+
+	sendMu   sync.Mutex          // guards outgoing, and serializes writes
+	outgoing map[string]net.Conn // connections on which to send, by address
+}
+
+// ListenTCP returns a TCPTransport that listens at addr until ctx is done.
+func ListenTCP(ctx context.Context, addr string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TCPTransport{
+		listener: listener,
+		local:    make(map[Node]func(Message)),
+		peers:    make(map[Node]string),
+		incoming: make(map[net.Conn]bool),
+		outgoing: make(map[string]net.Conn),
+	}
+
+	t.running.Add(2)
+	go func() {
+		defer t.running.Done()
+		<-ctx.Done()
+		t.close()
+	}()
+	go func() {
+		defer t.running.Done()
+		t.accept()
+	}()
+
+	return t, nil
+}
+
+// Addr returns the address at which t listens.
+func (t *TCPTransport) Addr() string {
+	return t.listener.Addr().String()
+}
+
+// Connect connects the node n in this process to t, so that t passes the
+// messages sent to n to deliver, which is usually n's Deliver method.
+func (t *TCPTransport) Connect(n Node, deliver func(Message)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.local[n] = deliver
+}
+
+// AddPeer tells t that the node n in another process listens at addr.
+func (t *TCPTransport) AddPeer(n Node, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers[n] = addr
+}
+
+// Wait waits until t has closed, once its context is done, and every goroutine
+// it started has returned.
+func (t *TCPTransport) Wait() {
+	t.running.Wait()
+}
+
+// Err returns the first error, other than t closing, that stopped t reading
+// from a connection, such as a message longer than maxLineSize, or nil if
+// there is none.
+func (t *TCPTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Send sends m from the node from to the node to, or loses m if it cannot.
+func (t *TCPTransport) Send(from, to Node, m Message) {
+	t.mu.Lock()
+	deliver, isLocal := t.local[to]
+	addr, isPeer := t.peers[to]
+	closed := t.closed
+	t.mu.Unlock()
+
+	if closed {
+		return
+	}
+	if isLocal {
+		deliver(m)
+		return
+	}
+	if !isPeer {
+		return
+	}
+
+	line, err := EncodeMessage(from, t.Addr(), to, m)
+	if err != nil || len(line) > maxLineSize {
+		return
+	}
+
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+
+	conn, ok := t.outgoing[addr]
+	if !ok {
+		if conn, err = net.DialTimeout("tcp", addr, tcpTimeout); err != nil {
+			return
+		}
+		t.outgoing[addr] = conn
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(tcpTimeout))
+	if _, err := conn.Write(line); err != nil {
+		conn.Close()
+		delete(t.outgoing, addr)
+	}
+}
+
+// accept accepts connections until t closes, and starts a goroutine to serve
+// each one.
+func (t *TCPTransport) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.incoming[conn] = true
+		t.running.Add(1)
+		t.mu.Unlock()
+
+		go func() {
+			defer t.running.Done()
+			t.serve(conn)
+		}()
+	}
+}
+
+// serve reads messages from conn until it closes, and delivers each one to
+// its receiving node. It remembers the address of each sending node, so that
+// the receiver can reply. It loses messages that it cannot decode, and those
+// for nodes not connected to t. If reading fails before t closes, for example
+// because a message is too long, serve records the error for Err.
+func (t *TCPTransport) serve(conn net.Conn) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.incoming, conn)
+		t.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		from, fromAddr, to, m, err := DecodeMessage(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+
+		t.mu.Lock()
+		if fromAddr != "" {
+			t.peers[from] = fromAddr
+		}
+		deliver := t.local[to]
+		t.mu.Unlock()
+
+		if deliver != nil {
+			deliver(m)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.mu.Lock()
+		if !t.closed && t.err == nil {
+			t.err = fmt.Errorf("reading from %s: %v", conn.RemoteAddr(), err)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// close stops t listening, and closes its connections.
+func (t *TCPTransport) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.listener.Close()
+	for conn := range t.incoming {
+		conn.Close()
+	}
+	t.mu.Unlock()
+
+	t.sendMu.Lock()
+	for addr, conn := range t.outgoing {
+		conn.Close()
+		delete(t.outgoing, addr)
+	}
+	t.sendMu.Unlock()
+}
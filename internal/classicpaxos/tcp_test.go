@@ -0,0 +1,179 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThatProposersAgreeOverTCP(t *testing.T) {
+	const nAcceptors, nProposers = 3, 2
+	quorums := Majority{NAcceptors: nAcceptors}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// each acceptor listens in a transport of its own, as if in its own process
+	var transports []*TCPTransport
+	var acceptors []*Acceptor
+	addrs := make([]string, nAcceptors)
+	for i := 0; i < nAcceptors; i++ {
+		transport, err := ListenTCP(ctx, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := NewAcceptor(ctx, i, transport, 0, NewMemoryStorage(), nil)
+		transport.Connect(AcceptorNode(i), a.Deliver)
+		transports = append(transports, transport)
+		acceptors = append(acceptors, a)
+		addrs[i] = transport.Addr()
+	}
+
+	candidates := []Value{stringValue("x"), stringValue("y")}
+	values := make([]Value, nProposers)
+	errs := make([]error, nProposers)
+	var wg sync.WaitGroup
+	for i := 0; i < nProposers; i++ {
+		transport, err := ListenTCP(ctx, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, addr := range addrs {
+			transport.AddPeer(AcceptorNode(j), addr)
+		}
+		p := NewProposer(i, nProposers, nAcceptors, quorums,
+			50*time.Millisecond, transport, nil)
+		transport.Connect(ProposerNode(i), p.Deliver)
+		transports = append(transports, transport)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = p.Propose(ctx, candidates[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("proposer %d got %v", i, err)
+		}
+	}
+	if values[0] != values[1] {
+		t.Errorf("proposers decided %s and %s, want the same value", values[0],
+			values[1])
+	}
+
+	cancel()
+	for _, a := range acceptors {
+		a.Wait()
+	}
+	for _, transport := range transports {
+		transport.Wait()
+	}
+}
+
+func TestThatValuesLargerThan64KBCrossTheWire(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	receiver, err := ListenTCP(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan Message, 1)
+	receiver.Connect(AcceptorNode(0), func(m Message) { received <- m })
+
+	sender, err := ListenTCP(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.AddPeer(AcceptorNode(0), receiver.Addr())
+
+	value := NewValue(bytes.Repeat([]byte("x"), 100<<10))
+	sent := propose{epoch: newEpoch(1, 2), value: value, proposerID: 1}
+	sender.Send(ProposerNode(1), AcceptorNode(0), sent)
+
+	select {
+	case m := <-received:
+		got, ok := m.(propose)
+		if !ok || got.value != value || got.epoch.Cmp(sent.epoch) != 0 ||
+			got.proposerID != sent.proposerID {
+
+			t.Errorf("got %.80s, want the proposal sent", m)
+		}
+	case <-ctx.Done():
+		t.Fatalf("no message arrived; transport error %v", receiver.Err())
+	}
+
+	cancel()
+	sender.Wait()
+	receiver.Wait()
+}
+
+func TestThatMessagesToUnknownNodesAreLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	transport, err := ListenTCP(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither sending to a node without an address, nor to an address at which
+	// nothing listens, blocks or panics
+	transport.Send(ProposerNode(0), AcceptorNode(0),
+		prepare{epoch: newEpoch(0, 1)})
+	transport.AddPeer(AcceptorNode(1), "127.0.0.1:1")
+	transport.Send(ProposerNode(0), AcceptorNode(1),
+		prepare{epoch: newEpoch(0, 1)})
+
+	cancel()
+	transport.Wait()
+}
+
+func TestThatLinesTooLongToReadAreReported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	transport, err := ListenTCP(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", transport.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn.Write(bytes.Repeat([]byte("x"), maxLineSize+1))
+		conn.Close()
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for transport.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := transport.Err(); err == nil ||
+		!strings.Contains(err.Error(), "too long") {
+
+		t.Errorf("got error %v, want one about a line too long", err)
+	}
+
+	cancel()
+	transport.Wait()
+}
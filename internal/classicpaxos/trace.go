@@ -0,0 +1,311 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of a step in a run.
+type EventKind string
+
+const (
+	EventSent      EventKind = "sent"      // a node sent a message
+	EventReceived  EventKind = "received"  // a node received a message
+	EventDropped   EventKind = "dropped"   // a message was lost
+	EventReordered EventKind = "reordered" // a message overtook another
+	EventTimeout   EventKind = "timeout"   // a proposer gave up on a round
+	EventEpoch     EventKind = "epoch"     // a proposer started a new round
+	EventDecided   EventKind = "decided"   // a node believes a value is decided
+)
+
+// Event is a step in a run, emitted to an EventSink. Events are numbered in
+// the order in which they happen, which is consistent with causality: if one
+// event could have caused another, it has a smaller number.
+type Event struct {
+	Seq     int64         `json:"seq"`               // logical timestamp
+	Time    time.Duration `json:"time_ns"`           // time since the start
+	Kind    EventKind     `json:"kind"`              // kind of step
+	Node    Node          `json:"node"`              // node taking the step
+	Peer    *Node         `json:"peer,omitempty"`    // other end of a message
+	Type    string        `json:"type,omitempty"`    // type of message
+	Message string        `json:"message,omitempty"` // string form of message
+	Epoch   string        `json:"epoch,omitempty"`   // epoch of step
+	Value   string        `json:"value,omitempty"`   // value of step
+	Slot    *int          `json:"slot,omitempty"`    // log slot or instance
+	Detail  string        `json:"detail,omitempty"`  // why lost or reordered
+}
+
+// EventSink receives the events of a run. Unless the run is simulated, events
+// may come from many goroutines, but never from two at once. If a sink also
+// has an Err method, as a JSONSink does, a run that otherwise succeeds returns
+// the error that it reports once the run has stopped.
+type EventSink interface {
+	// Event receives the event e.
+	Event(e Event)
+}
+
+// JSONSink is an EventSink that writes each event to a writer as a line of
+// JSON. Once a write fails, it drops every later event, so that the trace is
+// a prefix of the run, and Err reports the failure.
+type JSONSink struct {
+	enc *json.Encoder
+	err error // first error writing an event
+}
+
+// NewJSONSink returns a JSONSink that writes events to w in JSON Lines
+// format, one JSON object per event.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+// Event writes e to the sink's writer, unless an earlier write failed.
+func (s *JSONSink) Event(e Event) {
+	if s.err == nil {
+		s.err = s.enc.Encode(e)
+	}
+}
+
+// Err returns the first error writing an event, or nil if there is none.
+func (s *JSONSink) Err() error {
+	return s.err
+}
+
+// DescribedMessage is a message of a protocol outside this package, such as
+// EPaxos or Raft, that describes itself in the events of a run.
+type DescribedMessage interface {
+	// Describe sets the type of e, and its epoch, value and slot if the
+	// message has them.
+	Describe(e *Event)
+
+	// Sender returns the node that sent the message, if the message says.
+	Sender() (Node, bool)
+}
+
+// tracer numbers and timestamps the events of a run, and passes them to its
+// sink. A nil *tracer discards events, so that participants may trace
+// unconditionally.
+type tracer struct {
+	sink  EventSink
+	sched scheduler // scheduler whose clock timestamps events
+
+	mu  sync.Mutex // guards seq, and serializes calls to sink
+	seq int64      // number of the last event
+}
+
+// newTracer returns a tracer that passes events to sink, or nil if sink is
+// nil.
+func newTracer(s scheduler, sink EventSink) *tracer {
+	if sink == nil {
+		return nil
+	}
+	return &tracer{sink: sink, sched: s}
+}
+
+// err returns the error that t's sink reports, if it has an Err method, or
+// nil.
+func (t *tracer) err() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.sink.(interface{ Err() error }); ok {
+		if err := s.Err(); err != nil {
+			return fmt.Errorf("trace: %v", err)
+		}
+	}
+	return nil
+}
+
+// emit numbers and timestamps e, and passes it to t's sink.
+func (t *tracer) emit(e Event) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	e.Seq = t.seq
+	e.Time = t.sched.now()
+	t.sink.Event(e)
+}
+
+// message emits an event of the given kind for the message m, at the node n,
+// sent to or from the node peer. detail says why a dropped message was lost.
+func (t *tracer) message(kind EventKind, n, peer Node, m Message,
+	detail string) {
+
+	if t == nil {
+		return
+	}
+
+	e := Event{Kind: kind, Node: n, Peer: &peer, Message: fmt.Sprint(m),
+		Detail: detail}
+	describe(m, &e)
+	t.emit(e)
+}
+
+// arrival emits an event of the given kind for the message m, which arrived at
+// the node n, where it was received, lost or reordered as detail says. The
+// sender is known from m, unless m is not a protocol message.
+func (t *tracer) arrival(kind EventKind, n Node, m Message, detail string) {
+	if t == nil {
+		return
+	}
+
+	e := Event{Kind: kind, Node: n, Message: fmt.Sprint(m), Detail: detail}
+	if from, ok := sender(m); ok {
+		e.Peer = &from
+	}
+	describe(m, &e)
+	t.emit(e)
+}
+
+// step emits an event of the given kind, which involves no message, at the
+// node n.
+func (t *tracer) step(kind EventKind, n Node, epoch Epoch, value string) {
+	if t == nil {
+		return
+	}
+
+	e := Event{Kind: kind, Node: n, Value: value}
+	if !epoch.Nil() {
+		e.Epoch = epoch.String()
+	}
+	t.emit(e)
+}
+
+// decidedSlot emits a decided event for the command value in the slot of a
+// Multi-Paxos log, at the node n.
+func (t *tracer) decidedSlot(n Node, epoch Epoch, slot int, value string) {
+	if t == nil {
+		return
+	}
+
+	t.emit(Event{Kind: EventDecided, Node: n, Epoch: epoch.String(),
+		Value: value, Slot: &slot})
+}
+
+// traceValue returns the form of the value v in an event: its string form, or
+// the empty string, which the event omits, if v is the nil value.
+func traceValue(v Value) string {
+	if v.Nil() {
+		return ""
+	}
+	return v.String()
+}
+
+// describe sets the type, epoch, value and slot of e from the message m.
+func describe(m Message, e *Event) {
+	switch msg := m.(type) {
+	case prepare:
+		e.Type, e.Epoch = "prepare", msg.epoch.String()
+	case promise:
+		e.Type, e.Epoch, e.Value = "promise", msg.epoch.String(),
+			traceValue(msg.acceptedValue)
+	case provenPromise:
+		describe(msg.promise, e)
+	case signed:
+		describe(msg.msg, e)
+	case propose:
+		e.Type, e.Epoch, e.Value = "propose", msg.epoch.String(),
+			traceValue(msg.value)
+	case accept:
+		e.Type, e.Epoch = "accept", msg.epoch.String()
+	case accepted:
+		e.Type, e.Epoch, e.Value = "accepted", msg.epoch.String(),
+			traceValue(msg.value)
+	case reject:
+		e.Type, e.Epoch = "reject", msg.epoch.String()
+	case anyValue:
+		e.Type, e.Epoch = "any", msg.epoch.String()
+	case clientValue:
+		e.Type, e.Value = "value", traceValue(msg.value)
+	case forward:
+		e.Type, e.Value = "forward", traceValue(msg.value)
+	case outcome:
+		e.Type, e.Value = "outcome", traceValue(msg.value)
+	case query:
+		e.Type = "query"
+	case logPrepare:
+		e.Type, e.Epoch, e.Slot = "prepare", msg.epoch.String(), &msg.slot
+	case logPromise:
+		e.Type, e.Epoch = "promise", msg.epoch.String()
+	case logPropose:
+		e.Type, e.Epoch, e.Value, e.Slot = "propose", msg.epoch.String(),
+			proposedEntry(msg.value, msg.noop).String(), &msg.slot
+	case logAccept:
+		e.Type, e.Epoch, e.Slot = "accept", msg.epoch.String(), &msg.slot
+	case command:
+		e.Type, e.Value = "command", msg.value
+	case Crash:
+		e.Type = "crash"
+	case Restart:
+		e.Type = "restart"
+	case DescribedMessage:
+		msg.Describe(e)
+	}
+}
+
+// sender returns the node that sent the message m, if m says.
+func sender(m Message) (Node, bool) {
+	switch msg := m.(type) {
+	case prepare:
+		return ProposerNode(msg.proposerID), true
+	case promise:
+		return AcceptorNode(msg.acceptorID), true
+	case provenPromise:
+		return AcceptorNode(msg.acceptorID), true
+	case signed:
+		return msg.from, true
+	case propose:
+		return ProposerNode(msg.proposerID), true
+	case accept:
+		return AcceptorNode(msg.acceptorID), true
+	case accepted:
+		return AcceptorNode(msg.acceptorID), true
+	case reject:
+		return AcceptorNode(msg.acceptorID), true
+	case anyValue:
+		return ProposerNode(msg.proposerID), true
+	case clientValue:
+		return ProposerNode(msg.proposerID), true
+	case forward:
+		return ProposerNode(msg.proposerID), true
+	case outcome:
+		return ProposerNode(msg.proposerID), true
+	case query:
+		return LearnerNode(msg.learnerID), true
+	case logPrepare:
+		return ProposerNode(msg.proposerID), true
+	case logPromise:
+		return AcceptorNode(msg.acceptorID), true
+	case logPropose:
+		return ProposerNode(msg.proposerID), true
+	case logAccept:
+		return AcceptorNode(msg.acceptorID), true
+	case DescribedMessage:
+		return msg.Sender()
+	}
+	return Node{}, false
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventLog is an EventSink that records events.
+type eventLog []Event
+
+func (l *eventLog) Event(e Event) {
+	*l = append(*l, e)
+}
+
+// tracedConfig returns the configuration of a run with the given seed, in
+// which messages are dropped and reordered, whose events go to sink.
+func tracedConfig(seed int64, sink EventSink) Config {
+	ms := time.Millisecond
+	return Config{NProposers: 3, NAcceptors: 5,
+		ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 3,
+		Drop: 0.2, Seed: seed, Output: io.Discard, Trace: sink}
+}
+
+func TestThatTraceRecordsEveryKindOfStep(t *testing.T) {
+	kinds := make(map[EventKind]int)
+	for seed := int64(1); seed <= 20; seed++ {
+		var events eventLog
+		c := tracedConfig(seed, &events)
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+
+		for i, e := range events {
+			if e.Seq != int64(i+1) {
+				t.Fatalf("with seed %d, event %d is numbered %d", seed, i+1, e.Seq)
+			}
+			if i > 0 && e.Time < events[i-1].Time {
+				t.Errorf("with seed %d, event %d at %s precedes event %d at %s",
+					seed, e.Seq, e.Time, events[i-1].Seq, events[i-1].Time)
+			}
+			kinds[e.Kind]++
+		}
+	}
+
+	for _, kind := range []EventKind{EventSent, EventReceived, EventDropped,
+		EventReordered, EventTimeout, EventEpoch, EventDecided} {
+		if kinds[kind] == 0 {
+			t.Errorf("no %s events were traced", kind)
+		}
+	}
+}
+
+func TestThatSimulatedTracesAreRepeatable(t *testing.T) {
+	var first, second bytes.Buffer
+	c := tracedConfig(7, NewJSONSink(&first))
+	c.Run()
+	c = tracedConfig(7, NewJSONSink(&second))
+	c.Run()
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("two runs with the same seed traced different events")
+	}
+}
+
+func TestThatJSONSinkWritesOneEventPerLine(t *testing.T) {
+	var events eventLog
+	var buf bytes.Buffer
+	c := tracedConfig(1, NewJSONSink(&buf))
+	c.Run()
+	c = tracedConfig(1, &events)
+	c.Run()
+
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")),
+		[]byte("\n"))
+	if len(lines) != len(events) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(events))
+	}
+	for i, line := range lines {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if e.Seq != events[i].Seq || e.Kind != events[i].Kind ||
+			e.Node != events[i].Node || e.Message != events[i].Message {
+			t.Errorf("line %d decodes to %+v, want %+v", i+1, e, events[i])
+		}
+	}
+}
+
+// fullWriter is a writer that fails once it has written n bytes, as a writer
+// to a full disk does.
+type fullWriter struct {
+	n int
+}
+
+func (w *fullWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		written := w.n
+		w.n = 0
+		return written, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestThatRunsReportTracesThatFailToWrite(t *testing.T) {
+	c := tracedConfig(1, NewJSONSink(&fullWriter{n: 1000}))
+	err := c.Run()
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("got %v, want the trace's write error", err)
+	}
+
+	sink := NewJSONSink(&fullWriter{n: 1 << 30})
+	c = tracedConfig(1, sink)
+	if err := c.Run(); err != nil || sink.Err() != nil {
+		t.Errorf("got %v and %v, want no errors", err, sink.Err())
+	}
+}
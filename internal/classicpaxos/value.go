@@ -0,0 +1,158 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Value is a value that Classic Paxos agrees on: either the nil value, which no
+// proposer may propose, or a sequence of bytes, which may be empty. A Value
+// holds its own copy of its bytes and never changes, so values may be shared
+// between messages and compared with ==.
+type Value struct {
+	data  string // bytes of the value
+	isSet bool   // false only for the nil value
+}
+
+// NewValue returns the value whose bytes are a copy of data. It is not the nil
+// value, even if data is nil or empty.
+func NewValue(data []byte) Value {
+	return Value{data: string(data), isSet: true}
+}
+
+// stringValue returns the value whose bytes are those of s.
+func stringValue(s string) Value {
+	return Value{data: s, isSet: true}
+}
+
+// Nil returns true if and only if v is the nil value, which is the zero Value.
+func (v Value) Nil() bool {
+	return v == Value{}
+}
+
+// Bytes returns a copy of the bytes of v, or nil if v is the nil value.
+func (v Value) Bytes() []byte {
+	if v.Nil() {
+		return nil
+	}
+	return []byte(v.data)
+}
+
+// Decode decodes the bytes of v into out using the codec c. It returns a
+// non-nil error if v is the nil value.
+func (v Value) Decode(c Codec, out interface{}) error {
+	if v.Nil() {
+		return fmt.Errorf("cannot decode the nil value")
+	}
+	return c.Decode([]byte(v.data), out)
+}
+
+// String returns the string form of a value: "nil" for the nil value, the
+// bytes of the value if they are printable and need no quoting, or else the
+// bytes quoted as a Go string. Distinct values have distinct string forms.
+func (v Value) String() string {
+	if v.Nil() {
+		return "nil"
+	}
+	quoted := strconv.Quote(v.data)
+	if v.data == "" || v.data == "nil" || quoted[1:len(quoted)-1] != v.data {
+		return quoted
+	}
+	return v.data
+}
+
+// Codec converts the values of an application, such as the commands of a
+// replicated state machine, to and from the bytes of a Value.
+type Codec interface {
+	// Encode returns the bytes that encode v.
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode decodes data into the value to which out points.
+	Decode(data []byte, out interface{}) error
+}
+
+// Encode returns the value whose bytes encode v using the codec c.
+func Encode(c Codec, v interface{}) (Value, error) {
+	data, err := c.Encode(v)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewValue(data), nil
+}
+
+// StringCodec is the codec that encodes a string as its bytes.
+type StringCodec struct{}
+
+// Encode returns the bytes of v, which must be a string.
+func (StringCodec) Encode(v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T as a string", v)
+	}
+	return []byte(s), nil
+}
+
+// Decode sets the string to which out points to data.
+func (StringCodec) Decode(data []byte, out interface{}) error {
+	s, ok := out.(*string)
+	if !ok {
+		return fmt.Errorf("cannot decode a string into %T", out)
+	}
+	*s = string(data)
+	return nil
+}
+
+// JSONCodec is the codec that encodes a value as JSON, using the encoding/json
+// package.
+type JSONCodec struct{}
+
+// Encode returns the JSON encoding of v.
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode decodes the JSON in data into the value to which out points.
+func (JSONCodec) Decode(data []byte, out interface{}) error {
+	return json.Unmarshal(data, out)
+}
+
+// checkCandidateValues returns a non-nil error unless c.CandidateValues is
+// nil, or holds a value other than the nil value for each proposer.
+func (c *Config) checkCandidateValues() error {
+	if c.CandidateValues == nil {
+		return nil
+	}
+	if len(c.CandidateValues) != c.NProposers {
+		return fmt.Errorf("%d candidate values for %d proposers",
+			len(c.CandidateValues), c.NProposers)
+	}
+	for i, v := range c.CandidateValues {
+		if v.Nil() {
+			return fmt.Errorf("candidate value of proposer %d is nil", i)
+		}
+	}
+	return nil
+}
+
+// candidateValue returns the candidate value of the proposer numbered id.
+func (c *Config) candidateValue(id int) Value {
+	if c.CandidateValues == nil {
+		return stringValue(fmt.Sprintf("v%d", id))
+	}
+	return c.CandidateValues[id]
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// candidate values of the proposers numbered 0, 1 and 2 in a Config's run
+var v0, v1, v2 = stringValue("v0"), stringValue("v1"), stringValue("v2")
+
+func TestThatValuesHaveDistinctStringForms(t *testing.T) {
+	cases := []struct {
+		v    Value
+		want string
+	}{
+		{Value{}, "nil"},
+		{NewValue(nil), `""`},
+		{NewValue([]byte{}), `""`},
+		{stringValue("nil"), `"nil"`},
+		{v0, "v0"},
+		{stringValue("a value"), "a value"},
+		{stringValue(`"v0"`), `"\"v0\""`},
+		{NewValue([]byte{0, 0xff}), `"\x00\xff"`},
+	}
+	for _, c := range cases {
+		if got := c.v.String(); got != c.want {
+			t.Errorf("got %s, want %s", got, c.want)
+		}
+	}
+
+	if !(Value{}).Nil() || NewValue(nil).Nil() || (Value{}).Bytes() != nil ||
+		NewValue(nil).Bytes() == nil {
+
+		t.Errorf("the empty value is confused with the nil value")
+	}
+}
+
+func TestThatValuesNeverChange(t *testing.T) {
+	data := []byte("v0")
+	v := NewValue(data)
+	data[0] = 'x'
+	v.Bytes()[1] = 'x'
+	if v != v0 {
+		t.Errorf("got %s, want v0", v)
+	}
+}
+
+func TestThatCodecsRoundTrip(t *testing.T) {
+	type command struct {
+		Op  string
+		Key string
+		Arg []byte
+	}
+	want := command{Op: "put", Key: "k", Arg: []byte{0, 1, 2}}
+
+	v, err := Encode(JSONCodec{}, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got command
+	if err := v.Decode(JSONCodec{}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if v, err = Encode(StringCodec{}, ""); err != nil || v.Nil() {
+		t.Errorf("got %s and %v, want the empty value", v, err)
+	}
+	var s string
+	if err := v0.Decode(StringCodec{}, &s); err != nil || s != "v0" {
+		t.Errorf("got %q and %v, want v0", s, err)
+	}
+	if _, err := Encode(StringCodec{}, 7); err == nil {
+		t.Errorf("encoded 7 as a string, want an error")
+	}
+	if err := (Value{}).Decode(StringCodec{}, &s); err == nil {
+		t.Errorf("decoded the nil value, want an error")
+	}
+}
+
+func TestThatRunsAgreeOnEmptyAndBinaryValues(t *testing.T) {
+	ms := time.Millisecond
+	for seed := int64(1); seed <= 20; seed++ {
+		var learned []Value
+		c := Config{NProposers: 3, NAcceptors: 3, NLearners: 1,
+			CandidateValues: []Value{NewValue(nil), NewValue([]byte{0, 0xff}),
+				NewValue([]byte("nil"))},
+			ProposerTimeout: 100 * ms, ChannelTimeout: 10 * ms, Buffer: 2,
+			Seed: seed, Output: io.Discard,
+			OnLearn: func(learner int, value Value) {
+				learned = append(learned, value)
+			}}
+
+		if err := c.Run(); err != nil {
+			t.Errorf("with seed %d, got %v", seed, err)
+		}
+		if len(learned) != 1 || learned[0].Nil() {
+			t.Errorf("with seed %d, learned %v, want one candidate value", seed,
+				learned)
+		}
+	}
+}
+
+func TestThatTheNilValueCannotBeProposed(t *testing.T) {
+	c := Config{NProposers: 2, NAcceptors: 3,
+		CandidateValues: []Value{v0, {}}, Seed: 1, Output: io.Discard}
+	if err := c.Run(); err == nil {
+		t.Errorf("ran with a nil candidate value, want an error")
+	}
+
+	c.CandidateValues = []Value{v0}
+	if err := c.Run(); err == nil {
+		t.Errorf("ran with 1 candidate value for 2 proposers, want an error")
+	}
+
+	p := NewProposer(0, 1, 3, Majority{NAcceptors: 3}, time.Millisecond,
+		nil, nil)
+	if _, err := p.Propose(context.Background(), Value{}); err == nil {
+		t.Errorf("proposed the nil value, want an error")
+	}
+}
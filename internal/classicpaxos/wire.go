@@ -0,0 +1,142 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// envelope is the wire form of a message sent between processes: one JSON
+// object per line. The sender of a message is identified by its node, rather
+// than by a channel on which to reply, and its address tells the receiver
+// where to send replies. The bytes of a value are encoded in base64, as
+// encoding/json encodes a byte slice, and a promise holds the nil value if and
+// only if its accepted epoch is nil.
+type envelope struct {
+	Type          string `json:"type"`                     // message type
+	From          string `json:"from"`                     // sending node
+	FromAddr      string `json:"from_addr"`                // where sender listens
+	To            string `json:"to"`                       // receiving node
+	Epoch         string `json:"epoch"`                    // epoch of message
+	AcceptedEpoch string `json:"accepted_epoch,omitempty"` // in promise only
+	PromisedEpoch string `json:"promised_epoch,omitempty"` // in reject only
+	Value         []byte `json:"value,omitempty"`          // accepted or proposed
+}
+
+// EncodeMessage returns the wire form of the message m, sent from the node
+// from, which listens at fromAddr, to the node to. The wire form ends with a
+// newline. fromAddr may be empty if the receiver has another way to reply.
+func EncodeMessage(from Node, fromAddr string, to Node, m Message) ([]byte,
+	error) {
+
+	e := envelope{From: from.String(), FromAddr: fromAddr, To: to.String()}
+
+	switch msg := m.(type) {
+	case prepare:
+		e.Type = "prepare"
+		e.Epoch = formatEpoch(msg.epoch)
+	case promise:
+		e.Type = "promise"
+		e.Epoch = formatEpoch(msg.epoch)
+		e.AcceptedEpoch = formatEpoch(msg.acceptedEpoch)
+		e.Value = msg.acceptedValue.Bytes()
+	case propose:
+		e.Type = "propose"
+		e.Epoch = formatEpoch(msg.epoch)
+		e.Value = msg.value.Bytes()
+	case accept:
+		e.Type = "accept"
+		e.Epoch = formatEpoch(msg.epoch)
+	case accepted:
+		e.Type = "accepted"
+		e.Epoch = formatEpoch(msg.epoch)
+		e.Value = msg.value.Bytes()
+	case reject:
+		e.Type = "reject"
+		e.Epoch = formatEpoch(msg.epoch)
+		e.PromisedEpoch = formatEpoch(msg.promisedEpoch)
+	case query:
+		e.Type = "query"
+		e.Epoch = formatEpoch(Epoch{})
+	default:
+		return nil, fmt.Errorf("cannot encode message %v", m)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// DecodeMessage returns the message in the wire form line, along with its
+// sending node, the sender's address and its receiving node.
+func DecodeMessage(line []byte) (Node, string, Node, Message, error) {
+	var e envelope
+	if err := json.Unmarshal(line, &e); err != nil {
+		return Node{}, "", Node{}, nil, err
+	}
+
+	from, err := ParseNode(e.From)
+	if err != nil {
+		return Node{}, "", Node{}, nil, err
+	}
+	to, err := ParseNode(e.To)
+	if err != nil {
+		return Node{}, "", Node{}, nil, err
+	}
+	epoch, err := parseEpoch(e.Epoch)
+	if err != nil {
+		return Node{}, "", Node{}, nil, err
+	}
+
+	var m Message
+	switch e.Type {
+	case "prepare":
+		m = prepare{epoch: epoch, proposerID: from.ID}
+	case "promise":
+		acceptedEpoch, err := parseEpoch(e.AcceptedEpoch)
+		if err != nil {
+			return Node{}, "", Node{}, nil, err
+		}
+		var acceptedValue Value
+		if !acceptedEpoch.Nil() {
+			acceptedValue = NewValue(e.Value)
+		}
+		m = promise{epoch: epoch, acceptedEpoch: acceptedEpoch,
+			acceptedValue: acceptedValue, acceptorID: from.ID}
+	case "propose":
+		m = propose{epoch: epoch, value: NewValue(e.Value), proposerID: from.ID}
+	case "accept":
+		m = accept{epoch: epoch, acceptorID: from.ID}
+	case "accepted":
+		m = accepted{epoch: epoch, value: NewValue(e.Value), acceptorID: from.ID}
+	case "reject":
+		promisedEpoch, err := parseEpoch(e.PromisedEpoch)
+		if err != nil {
+			return Node{}, "", Node{}, nil, err
+		}
+		m = reject{epoch: epoch, promisedEpoch: promisedEpoch,
+			acceptorID: from.ID}
+	case "query":
+		m = query{learnerID: from.ID}
+	default:
+		return Node{}, "", Node{}, nil, fmt.Errorf("unknown message type %q",
+			e.Type)
+	}
+
+	return from, e.FromAddr, to, m, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package classicpaxos
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestThatMessagesSurviveTheWire(t *testing.T) {
+	e := newEpoch(4, 3)
+	f := newEpoch(2, 3)
+
+	cases := []struct {
+		from, to Node
+		m        Message
+	}{
+		{ProposerNode(1), AcceptorNode(0), prepare{epoch: e, proposerID: 1}},
+		{AcceptorNode(0), ProposerNode(1),
+			promise{epoch: e, acceptorID: 0}},
+		{AcceptorNode(2), ProposerNode(1), promise{epoch: e, acceptedEpoch: f,
+			acceptedValue: v2, acceptorID: 2}},
+		{AcceptorNode(2), ProposerNode(1), promise{epoch: e, acceptedEpoch: f,
+			acceptedValue: NewValue(nil), acceptorID: 2}},
+		{ProposerNode(1), AcceptorNode(2),
+			propose{epoch: e, value: stringValue("v\"1\n"), proposerID: 1}},
+		{ProposerNode(1), AcceptorNode(2),
+			propose{epoch: e, value: NewValue([]byte{0, 0xff}), proposerID: 1}},
+		{AcceptorNode(2), ProposerNode(1), accept{epoch: e, acceptorID: 2}},
+		{AcceptorNode(2), LearnerNode(0),
+			accepted{epoch: e, value: v1, acceptorID: 2}},
+		{AcceptorNode(0), ProposerNode(2),
+			reject{epoch: f, promisedEpoch: e, acceptorID: 0}},
+		{LearnerNode(1), AcceptorNode(2), query{learnerID: 1}},
+	}
+
+	for _, c := range cases {
+		line, err := EncodeMessage(c.from, "127.0.0.1:7000", c.to, c.m)
+		if err != nil {
+			t.Fatalf("encoding %s: %v", c.m, err)
+		}
+		if bytes.IndexByte(line, '\n') != len(line)-1 {
+			t.Errorf("wire form of %s is not one line: %q", c.m, line)
+		}
+
+		from, fromAddr, to, m, err := DecodeMessage(line)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", line, err)
+		}
+		if from != c.from || fromAddr != "127.0.0.1:7000" || to != c.to ||
+			fmt.Sprint(m) != fmt.Sprint(c.m) {
+			t.Errorf("%s from %s to %s came back as %s from %s (%s) to %s",
+				c.m, c.from, c.to, m, from, fromAddr, to)
+		}
+	}
+}
+
+func TestThatUnknownMessagesAreNotDecoded(t *testing.T) {
+	lines := []string{
+		`not json`,
+		`{"type":"nack","from":"a0","to":"p0","epoch":"1/2"}`,
+		`{"type":"prepare","from":"x0","to":"a0","epoch":"1/2"}`,
+		`{"type":"prepare","from":"p1","to":"a0","epoch":"one"}`,
+	}
+	for _, line := range lines {
+		if _, _, _, _, err := DecodeMessage([]byte(line)); err == nil {
+			t.Errorf("decoded %s, want an error", line)
+		}
+	}
+
+	if _, err := EncodeMessage(ProposerNode(0), "", AcceptorNode(0),
+		Crash{}); err == nil {
+		t.Errorf("encoded %s, want an error", Crash{})
+	}
+}
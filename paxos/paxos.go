@@ -0,0 +1,288 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package paxos is the public interface to the Classic Paxos implementation
+// in this module. It lets a program embed proposers, acceptors and learners,
+// and connect them with its own Transport and Storage.
+//
+// A program creates one participant per process or goroutine, and implements
+// a Transport that carries each Message to the participant at the receiving
+// Node and passes it to that participant's Deliver method. A Transport between
+// processes may carry messages in the wire form that EncodeMessage returns and
+// DecodeMessage reads. A proposer then calls Propose, which returns once it
+// believes a value is decided:
+//
+//	p := paxos.NewProposer(0, 1, 3, paxos.Majority{NAcceptors: 3},
+//	    100*time.Millisecond, transport, nil)
+//	value, err := p.Propose(ctx, paxos.NewValue([]byte("v0")))
+//
+// A value is a sequence of bytes, so a program may agree on values of any type
+// by converting them with a Codec, such as JSONCodec.
+package paxos
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+type (
+	// Proposer represents the proposer role in Classic Paxos.
+	Proposer = classicpaxos.Proposer
+
+	// Acceptor represents the acceptor role in Classic Paxos.
+	Acceptor = classicpaxos.Acceptor
+
+	// Learner represents the learner role in Classic Paxos.
+	Learner = classicpaxos.Learner
+
+	// Message is a message that a participant sends to another.
+	Message = classicpaxos.Message
+
+	// Transport carries messages between participants.
+	Transport = classicpaxos.Transport
+
+	// TCPTransport is a Transport that carries messages between processes
+	// over TCP.
+	TCPTransport = classicpaxos.TCPTransport
+
+	// Node identifies a participant by its role and identifier.
+	Node = classicpaxos.Node
+
+	// Role is the role of a participant.
+	Role = classicpaxos.Role
+
+	// Storage is where an acceptor keeps its state.
+	Storage = classicpaxos.Storage
+
+	// AcceptorState holds the variables that an acceptor must remember across
+	// restarts.
+	AcceptorState = classicpaxos.AcceptorState
+
+	// Epoch is used to order proposals.
+	Epoch = classicpaxos.Epoch
+
+	// EpochScheme determines which epochs each proposer uses.
+	EpochScheme = classicpaxos.EpochScheme
+
+	// Value is a value that Classic Paxos agrees on: a sequence of bytes, or
+	// the nil value.
+	Value = classicpaxos.Value
+
+	// Codec converts the values of an application to and from the bytes of a
+	// Value.
+	Codec = classicpaxos.Codec
+
+	// StringCodec is the codec that encodes a string as its bytes.
+	StringCodec = classicpaxos.StringCodec
+
+	// JSONCodec is the codec that encodes a value as JSON.
+	JSONCodec = classicpaxos.JSONCodec
+
+	// QuorumSystem determines which sets of acceptors are quorums in each
+	// phase.
+	QuorumSystem = classicpaxos.QuorumSystem
+
+	// Majority is the quorum system in which a quorum is any majority.
+	Majority = classicpaxos.Majority
+
+	// Flexible is a Flexible Paxos quorum system.
+	Flexible = classicpaxos.Flexible
+
+	// Weighted is a quorum system of weighted acceptors.
+	Weighted = classicpaxos.Weighted
+
+	// Grid is a quorum system of acceptors arranged in a grid.
+	Grid = classicpaxos.Grid
+
+	// Config represents configuration for a simulated run of Classic Paxos.
+	Config = classicpaxos.Config
+
+	// Cluster is a run of Classic Paxos in progress, started by Config.Start.
+	Cluster = classicpaxos.Cluster
+
+	// Metrics measures how much work the proposers in a run did to decide,
+	// and how long they took.
+	Metrics = classicpaxos.Metrics
+
+	// RetryStrategy determines how proposers avoid preempting one another
+	// forever.
+	RetryStrategy = classicpaxos.RetryStrategy
+
+	// FixedRetry is the retry strategy in which a proposer waits for the
+	// same delay before each new round.
+	FixedRetry = classicpaxos.FixedRetry
+
+	// ExponentialRetry is the retry strategy in which a proposer waits for a
+	// random delay up to a limit that doubles with each failed round.
+	ExponentialRetry = classicpaxos.ExponentialRetry
+
+	// DistinguishedProposer is the retry strategy in which only the leader
+	// runs rounds, and the other proposers forward their values to it.
+	DistinguishedProposer = classicpaxos.DistinguishedProposer
+
+	// Event is a step in a run.
+	Event = classicpaxos.Event
+
+	// EventKind is the kind of a step in a run.
+	EventKind = classicpaxos.EventKind
+
+	// EventSink receives the events of a run.
+	EventSink = classicpaxos.EventSink
+
+	// JSONSink is an EventSink that writes each event to a writer as a line
+	// of JSON.
+	JSONSink = classicpaxos.JSONSink
+
+	// ModelCheck represents configuration for exhaustively checking Classic
+	// Paxos with a small number of participants.
+	ModelCheck = classicpaxos.ModelCheck
+
+	// ModelCheckResult is the result of a model check.
+	ModelCheckResult = classicpaxos.ModelCheckResult
+)
+
+// Roles of participants.
+const (
+	RoleProposer = classicpaxos.RoleProposer
+	RoleAcceptor = classicpaxos.RoleAcceptor
+	RoleLearner  = classicpaxos.RoleLearner
+	RoleReplica  = classicpaxos.RoleReplica
+)
+
+// Schemes of epochs.
+const (
+	ModuloEpochs = classicpaxos.ModuloEpochs
+	PairEpochs   = classicpaxos.PairEpochs
+)
+
+// Kinds of events.
+const (
+	EventSent      = classicpaxos.EventSent
+	EventReceived  = classicpaxos.EventReceived
+	EventDropped   = classicpaxos.EventDropped
+	EventReordered = classicpaxos.EventReordered
+	EventTimeout   = classicpaxos.EventTimeout
+	EventEpoch     = classicpaxos.EventEpoch
+	EventDecided   = classicpaxos.EventDecided
+)
+
+// NewProposer returns a proposer numbered id, of nProposers proposers, which
+// sends to the acceptors numbered 0 to nAcceptors-1 over transport. It
+// completes each phase once the acceptors that reply form a quorum in quorums,
+// and starts a new round if it waits longer than timeout for them. It prints
+// its progress to out unless out is nil. If nProposers is zero, the proposer
+// uses PairEpochs, so that any number of proposers with distinct ids may
+// join or leave.
+func NewProposer(id, nProposers, nAcceptors int, quorums QuorumSystem,
+	timeout time.Duration, transport Transport, out io.Writer) *Proposer {
+
+	return classicpaxos.NewProposer(id, nProposers, nAcceptors, quorums,
+		timeout, transport, out)
+}
+
+// NewAcceptor returns an acceptor numbered id, which recovers its state from
+// storage, replies to proposers over transport, and notifies the learners
+// numbered 0 to nLearners-1 of each proposal it accepts. The acceptor runs in
+// its own goroutine until ctx is done, and prints its progress to out unless
+// out is nil.
+func NewAcceptor(ctx context.Context, id int, transport Transport,
+	nLearners int, storage Storage, out io.Writer) *Acceptor {
+
+	return classicpaxos.NewAcceptor(ctx, id, transport, nLearners, storage, out)
+}
+
+// NewLearner returns a learner numbered id, which calls onLearn with its id
+// and each value that it learns is chosen by a phase 2 quorum in quorums. The
+// learner runs in its own goroutine until ctx is done, calls onLearn from that
+// goroutine, and prints its progress to out unless out is nil.
+func NewLearner(ctx context.Context, id int, quorums QuorumSystem,
+	onLearn func(id int, value Value), out io.Writer) *Learner {
+
+	return classicpaxos.NewLearner(ctx, id, quorums, onLearn, out)
+}
+
+// NewValue returns the value whose bytes are a copy of data. It is not the nil
+// value, even if data is nil or empty.
+func NewValue(data []byte) Value {
+	return classicpaxos.NewValue(data)
+}
+
+// Encode returns the value whose bytes encode v using the codec c.
+func Encode(c Codec, v interface{}) (Value, error) {
+	return classicpaxos.Encode(c, v)
+}
+
+// ListenTCP returns a TCPTransport that listens at addr until ctx is done.
+func ListenTCP(ctx context.Context, addr string) (*TCPTransport, error) {
+	return classicpaxos.ListenTCP(ctx, addr)
+}
+
+// EncodeMessage returns the wire form of the message m, sent from the node
+// from, which listens at fromAddr, to the node to: a line of JSON, ending with
+// a newline. A Transport that carries messages between processes may send
+// this form, and fromAddr may be empty if the receiver has another way to
+// reply.
+func EncodeMessage(from Node, fromAddr string, to Node, m Message) ([]byte,
+	error) {
+
+	return classicpaxos.EncodeMessage(from, fromAddr, to, m)
+}
+
+// DecodeMessage returns the message in the wire form line, along with its
+// sending node, the sender's address and its receiving node.
+func DecodeMessage(line []byte) (Node, string, Node, Message, error) {
+	return classicpaxos.DecodeMessage(line)
+}
+
+// NewJSONSink returns a JSONSink that writes events to w in JSON Lines
+// format, one JSON object per event.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return classicpaxos.NewJSONSink(w)
+}
+
+// NewMemoryStorage returns a Storage that keeps state in memory.
+func NewMemoryStorage() Storage {
+	return classicpaxos.NewMemoryStorage()
+}
+
+// OpenFileStorage returns a Storage that logs state to the file at path,
+// creating the file if necessary.
+func OpenFileStorage(path string) (Storage, error) {
+	return classicpaxos.OpenFileStorage(path)
+}
+
+// CheckQuorums returns a non-nil error unless, among nAcceptors acceptors,
+// every phase 1 quorum of q intersects every phase 2 quorum, and the set of
+// all acceptors is a quorum in both phases.
+func CheckQuorums(q QuorumSystem, nAcceptors int) error {
+	return classicpaxos.CheckQuorums(q, nAcceptors)
+}
+
+// ProposerNode returns the node for the proposer numbered id.
+func ProposerNode(id int) Node {
+	return classicpaxos.ProposerNode(id)
+}
+
+// AcceptorNode returns the node for the acceptor numbered id.
+func AcceptorNode(id int) Node {
+	return classicpaxos.AcceptorNode(id)
+}
+
+// LearnerNode returns the node for the learner numbered id.
+func LearnerNode(id int) Node {
+	return classicpaxos.LearnerNode(id)
+}
@@ -0,0 +1,226 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paxos_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/b9r5/learn-paxos/paxos"
+)
+
+// memoryTransport is a Transport that delivers every message immediately to a
+// participant in the same process. If encode is true, it passes each message
+// through its wire form on the way, as a Transport between processes would.
+type memoryTransport struct {
+	mu      sync.Mutex
+	deliver map[paxos.Node]func(paxos.Message)
+	encode  bool
+	err     error // first error encoding or decoding a message
+}
+
+func (t *memoryTransport) connect(n paxos.Node, deliver func(paxos.Message)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deliver[n] = deliver
+}
+
+func (t *memoryTransport) Send(from, to paxos.Node, m paxos.Message) {
+	if t.encode {
+		var err error
+		if m, err = t.wire(from, to, m); err != nil {
+			t.mu.Lock()
+			if t.err == nil {
+				t.err = err
+			}
+			t.mu.Unlock()
+			return
+		}
+	}
+
+	t.mu.Lock()
+	deliver := t.deliver[to]
+	t.mu.Unlock()
+
+	if deliver != nil {
+		deliver(m)
+	}
+}
+
+// wire returns the message m sent from the node from to the node to, after
+// encoding it and decoding it again.
+func (t *memoryTransport) wire(from, to paxos.Node, m paxos.Message) (
+	paxos.Message, error) {
+
+	line, err := paxos.EncodeMessage(from, "", to, m)
+	if err != nil {
+		return nil, err
+	}
+	gotFrom, _, gotTo, m, err := paxos.DecodeMessage(line)
+	if err != nil {
+		return nil, err
+	}
+	if gotFrom != from || gotTo != to {
+		return nil, fmt.Errorf("decoded %s from %s to %s, want from %s to %s",
+			m, gotFrom, gotTo, from, to)
+	}
+	return m, nil
+}
+
+func TestThatEmbeddedParticipantsAgree(t *testing.T) {
+	checkAgreement(t, &memoryTransport{
+		deliver: make(map[paxos.Node]func(paxos.Message)),
+	})
+}
+
+func TestThatParticipantsAgreeOverTheWireForm(t *testing.T) {
+	transport := &memoryTransport{
+		deliver: make(map[paxos.Node]func(paxos.Message)),
+		encode:  true,
+	}
+	checkAgreement(t, transport)
+	if transport.err != nil {
+		t.Error(transport.err)
+	}
+}
+
+// checkAgreement checks that two proposers, three acceptors and a learner
+// connected by transport agree on a value.
+func checkAgreement(t *testing.T, transport *memoryTransport) {
+	quorums := paxos.Majority{NAcceptors: 3}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	learned := make(chan paxos.Value, 1)
+	l := paxos.NewLearner(ctx, 0, quorums, func(id int, value paxos.Value) {
+		learned <- value
+	}, nil)
+	transport.connect(paxos.LearnerNode(0), l.Deliver)
+
+	acceptors := make([]*paxos.Acceptor, 3)
+	for i := range acceptors {
+		acceptors[i] = paxos.NewAcceptor(ctx, i, transport, 1,
+			paxos.NewMemoryStorage(), nil)
+		transport.connect(paxos.AcceptorNode(i), acceptors[i].Deliver)
+	}
+
+	proposers := make([]*paxos.Proposer, 2)
+	for i := range proposers {
+		proposers[i] = paxos.NewProposer(i, len(proposers), 3, quorums,
+			50*time.Millisecond, transport, nil)
+		transport.connect(paxos.ProposerNode(i), proposers[i].Deliver)
+	}
+
+	// the empty value is a value like any other
+	candidates := []paxos.Value{paxos.NewValue(nil), paxos.NewValue([]byte("y"))}
+	values := make([]paxos.Value, len(proposers))
+	errs := make([]error, len(proposers))
+	var wg sync.WaitGroup
+	for i, p := range proposers {
+		wg.Add(1)
+		go func(i int, p *paxos.Proposer) {
+			defer wg.Done()
+			values[i], errs[i] = p.Propose(ctx, candidates[i])
+		}(i, p)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("proposer %d got %v", i, err)
+		}
+	}
+	if values[0] != values[1] {
+		t.Errorf("proposers decided %s and %s, want the same value", values[0],
+			values[1])
+	}
+
+	select {
+	case value := <-learned:
+		if value != values[0] {
+			t.Errorf("learner learned %s, want %s", value, values[0])
+		}
+	case <-ctx.Done():
+		t.Errorf("learner learned no value")
+	}
+
+	cancel()
+	l.Wait()
+	for _, a := range acceptors {
+		a.Wait()
+	}
+}
+
+func TestThatProposeStopsWhenContextIsDone(t *testing.T) {
+	transport := &memoryTransport{
+		deliver: make(map[paxos.Node]func(paxos.Message)),
+	}
+
+	// with no acceptors, the proposer can never decide
+	p := paxos.NewProposer(0, 1, 3, paxos.Majority{NAcceptors: 3},
+		10*time.Millisecond, transport, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := p.Propose(ctx, paxos.NewValue([]byte("x")))
+	if err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestThatProposersWithPairEpochsMayJoinLater(t *testing.T) {
+	transport := &memoryTransport{
+		deliver: make(map[paxos.Node]func(paxos.Message)),
+	}
+	quorums := paxos.Majority{NAcceptors: 3}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	acceptors := make([]*paxos.Acceptor, 3)
+	for i := range acceptors {
+		acceptors[i] = paxos.NewAcceptor(ctx, i, transport, 0,
+			paxos.NewMemoryStorage(), nil)
+		transport.connect(paxos.AcceptorNode(i), acceptors[i].Deliver)
+	}
+
+	// neither proposer knows how many proposers there are
+	var values []paxos.Value
+	for _, id := range []int{0, 7} {
+		p := paxos.NewProposer(id, 0, 3, quorums, 50*time.Millisecond,
+			transport, nil)
+		transport.connect(paxos.ProposerNode(id), p.Deliver)
+
+		value, err := p.Propose(ctx, paxos.NewValue([]byte{byte('a' + id)}))
+		if err != nil {
+			t.Fatalf("proposer %d got %v", id, err)
+		}
+		values = append(values, value)
+	}
+	if values[0] != values[1] {
+		t.Errorf("proposers decided %s and %s, want the same value", values[0],
+			values[1])
+	}
+
+	cancel()
+	for _, a := range acceptors {
+		a.Wait()
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package raft implements Raft, which runs over the same simulated network as
+// Classic Paxos, so that the two can be compared under the same lossy channels
+// and faults. Each client proposes its own value, and the servers decide one
+// of them:
+//
+//	c := raft.Config{NServers: 5, NClients: 3,
+//	    ElectionTimeout: 150 * time.Millisecond,
+//	    HeartbeatInterval: 50 * time.Millisecond,
+//	    ClientTimeout: 100 * time.Millisecond, Buffer: 2, Drop: 0.1, Seed: 1}
+//	metrics, err := c.Run()
+package raft
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+type (
+	// Partition schedules a partition of the network into groups of nodes.
+	Partition = classicpaxos.Partition
+
+	// LinkCut schedules the loss of every message sent from one node to
+	// another.
+	LinkCut = classicpaxos.LinkCut
+
+	// Node identifies a server or a client.
+	Node = classicpaxos.Node
+)
+
+// ServerNode returns the node for the server numbered id.
+func ServerNode(id int) Node {
+	return classicpaxos.ReplicaNode(id)
+}
+
+// ClientNode returns the node for the client numbered id.
+func ClientNode(id int) Node {
+	return classicpaxos.ProposerNode(id)
+}
+
+// Raft [1] decides a log of commands through a leader, which it elects by
+// majority vote in a numbered term. A server that hears from no leader for
+// an election timeout, chosen pseudo-randomly so that servers seldom time out
+// together, starts a new term and asks the others for their votes. A server
+// votes for one candidate per term, and only for a candidate whose log is at
+// least as up to date as its own, so that every leader holds every committed
+// entry. The leader appends each command to its log and replicates it; an
+// entry of the leader's own term is committed once a majority stores it, and
+// with it every entry before it.
+//
+// To compare Raft with Classic Paxos, a run decides one value: each client
+// proposes its own value, and the value decided is that of the first
+// committed entry. The leader appends a no-op entry at the start of its term,
+// so that it can commit the entries of earlier terms without waiting for a
+// new command.
+//
+// [1] Diego Ongaro and John Ousterhout. 2014. In search of an understandable
+// consensus algorithm. In Proceedings of the 2014 USENIX Annual Technical
+// Conference (USENIX ATC '14), 305-319.
+
+// Config represents configuration for a run of Raft, including the number
+// of servers and clients, the timeouts, the lossyChannel parameters, the seed
+// for pseudo-random choices, which servers crash, and how the network fails.
+// Servers are replica nodes, and clients are proposer nodes.
+type Config struct {
+	// number of servers
+	NServers int
+
+	// number of clients, each of which proposes its own value
+	NClients int
+
+	// least time that a follower waits to hear from a leader before starting
+	// an election; each server waits a pseudo-random time between this and
+	// twice this
+	ElectionTimeout time.Duration
+
+	// time between the leader's heartbeats, which also retransmit the entries
+	// that followers have yet to store
+	HeartbeatInterval time.Duration
+
+	// how long a client waits for a reply before trying another server
+	ClientTimeout time.Duration
+
+	// how long lossyChannel waits for buffer to fill before returning message
+	ChannelTimeout time.Duration
+
+	// how many messages lossyChannel buffers
+	Buffer int
+
+	// probability that lossyChannel drops a message
+	Drop float64
+
+	// seed for pseudo-random choices; if non-zero, the run is simulated against
+	// a virtual clock, and runs with the same configuration and seed are
+	// identical; if zero, the run happens in real time and is not repeatable
+	Seed int64
+
+	// crashes and restarts of servers
+	Faults []ServerFault
+
+	// partitions of the network
+	Partitions []Partition
+
+	// links on which the network loses messages in one direction only
+	LinkCuts []LinkCut
+
+	// where servers and clients print their progress (os.Stdout if nil);
+	// unless Seed is non-zero, it must be safe for concurrent use
+	Output io.Writer
+
+	// if not nil, receives an event for each step of the run
+	Trace classicpaxos.EventSink
+}
+
+// run is a run of Raft: a copy of the configuration that started it, and
+// the state that exists only while it lasts, so that a Config may run
+// any number of times, even at once.
+type run struct {
+	Config
+
+	env     *classicpaxos.Env // scheduler, network and tracer for the run
+	metrics *recorder         // measures the run's clients
+}
+
+// ServerFault schedules a crash of a Raft server, and optionally its restart.
+// A crashed server loses every message sent to it until it restarts, and
+// then resumes as a follower with the term, vote and log that it had when it
+// crashed, which Raft keeps in stable storage.
+type ServerFault struct {
+	// server that crashes
+	Server int
+
+	// time since the start of the run at which the server crashes
+	CrashAt time.Duration
+
+	// time since the start of the run at which the server restarts; if zero,
+	// the server never restarts
+	RestartAt time.Duration
+}
+
+// String returns the string form of a server fault.
+func (f ServerFault) String() string {
+	s := fmt.Sprintf("server %d crashes at %s", f.Server, f.CrashAt)
+	if f.RestartAt > 0 {
+		s += fmt.Sprintf(" and restarts at %s", f.RestartAt)
+	}
+	return s
+}
+
+// Metrics measures how much work the servers in a run of Raft did to
+// decide, and how long the clients took to learn the decision.
+type Metrics struct {
+	// number of elections that the servers started, in total
+	Elections int
+
+	// number of clients that learned the value decided
+	Decided int
+
+	// number of messages that the servers and clients sent over the network,
+	// in total, whether or not they arrived
+	Messages int
+
+	// mean and greatest time from the start of the run until a client learned
+	// the value decided, over the clients that learned it; in a simulated run,
+	// the time is virtual
+	MeanLatency, MaxLatency time.Duration
+}
+
+// String returns the string form of Raft metrics.
+func (m Metrics) String() string {
+	return fmt.Sprintf("%d client/s decided after %d election/s and %d "+
+		"message/s, with mean latency %s and max latency %s", m.Decided,
+		m.Elections, m.Messages, m.MeanLatency, m.MaxLatency)
+}
+
+// recorder accumulates the metrics of a run of Raft from servers and
+// clients that may act concurrently.
+type recorder struct {
+	mu      sync.Mutex
+	metrics Metrics
+	total   time.Duration // sum of the latencies of the clients that decided
+}
+
+// election records that a server started an election.
+func (r *recorder) election() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics.Elections++
+}
+
+// decided records that a client learned the value decided at time now since
+// the start of the run.
+func (r *recorder) decided(now time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics.Decided++
+	r.total += now
+	r.metrics.MeanLatency = r.total / time.Duration(r.metrics.Decided)
+	if now > r.metrics.MaxLatency {
+		r.metrics.MaxLatency = now
+	}
+}
+
+// get returns the metrics recorded so far.
+func (r *recorder) get() Metrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metrics
+}
+
+// Run runs Raft for the scenario given by the configuration c: each client
+// proposes its own value, and learns the value of the first entry committed.
+// Run returns once every client and every server that does not crash for good
+// has learned the value decided, with the metrics of the run. It returns a
+// non-nil error if they learned different values.
+func (c *Config) Run() (Metrics, error) {
+	r, err := c.newRun()
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	runErr := r.env.Run(func() {
+		values := r.env.NewChannel(r.NServers + r.NClients)
+
+		// 1. create servers, and schedule the crashes
+		inputs := make([]classicpaxos.Channel, r.NServers)
+		for i := 0; i < r.NServers; i++ {
+			inputs[i] = r.env.Listen(ServerNode(i))
+			rnd := rand.New(rand.NewSource(r.env.Rand().Int63()))
+			newServer(r.env, i, r.NServers, inputs[i], r.ElectionTimeout,
+				r.HeartbeatInterval, rnd, values, r.metrics, r.output())
+		}
+		for _, f := range r.Faults {
+			r.env.ScheduleCrash(inputs[f.Server], f.CrashAt, f.RestartAt)
+		}
+
+		// 2. create clients, each proposing its own value
+		for i := 0; i < r.NClients; i++ {
+			c := &client{id: i, nServers: r.NServers,
+				input: r.env.Listen(ClientNode(i)), env: r.env,
+				timeout: r.ClientTimeout, out: r.output()}
+			candidateValue := fmt.Sprintf("v%d", i)
+			r.env.Spawn(func() {
+				if value, ok := c.propose(candidateValue); ok {
+					r.metrics.decided(r.env.Now())
+					values.Send(value)
+				}
+			})
+		}
+
+		// 3. check whether the servers and clients learned the same value
+		err = r.checkValues(values)
+	})
+	if runErr != nil {
+		err = runErr
+	}
+	if err == nil {
+		err = r.env.TraceErr()
+	}
+
+	metrics := r.metrics.get()
+	metrics.Messages = r.env.Messages()
+	return metrics, err
+}
+
+// newRun checks c, and returns a run of c with its own environment.
+func (c *Config) newRun() (*run, error) {
+	if c.NServers < 1 || c.NClients < 1 {
+		return nil, fmt.Errorf("Raft needs at least one server and one client")
+	}
+	if c.ElectionTimeout <= 0 || c.HeartbeatInterval <= 0 ||
+		c.ClientTimeout <= 0 {
+
+		return nil, fmt.Errorf("Raft needs positive timeouts")
+	}
+	for _, f := range c.Faults {
+		if f.Server < 0 || f.Server >= c.NServers {
+			return nil, fmt.Errorf("fault for nonexistent server %d", f.Server)
+		}
+		if f.RestartAt > 0 && f.RestartAt <= f.CrashAt {
+			return nil, fmt.Errorf("server %d restarts at %s, before it "+
+				"crashes at %s", f.Server, f.RestartAt, f.CrashAt)
+		}
+	}
+
+	env, err := classicpaxos.NewEnv(classicpaxos.EnvConfig{Seed: c.Seed,
+		ChannelTimeout: c.ChannelTimeout, Buffer: c.Buffer, Drop: c.Drop,
+		Partitions: c.Partitions, LinkCuts: c.LinkCuts, Exists: c.exists,
+		Output: c.output(), Trace: c.Trace})
+	if err != nil {
+		return nil, err
+	}
+	return &run{Config: *c, env: env, metrics: &recorder{}}, nil
+}
+
+// exists returns true if and only if the node n is a server or a client in a
+// run.
+func (c *Config) exists(n Node) bool {
+	switch n.Role {
+	case classicpaxos.RoleReplica:
+		return n.ID >= 0 && n.ID < c.NServers
+	case classicpaxos.RoleProposer:
+		return n.ID >= 0 && n.ID < c.NClients
+	}
+	return false
+}
+
+// output returns the writer to which servers and clients print their
+// progress.
+func (c *Config) output() io.Writer {
+	if c.Output == nil {
+		return os.Stdout
+	}
+	return c.Output
+}
+
+// checkValues waits until one value per client and one value per server that
+// does not crash for good appear on the values channel, and returns a non-nil
+// error if the values differ, or if the values channel is closed first.
+func (c *Config) checkValues(values classicpaxos.Channel) error {
+	crashed := make(map[int]bool)
+	for _, f := range c.Faults {
+		if f.RestartAt <= 0 {
+			crashed[f.Server] = true
+		}
+	}
+	n := c.NClients + c.NServers - len(crashed)
+
+	vals := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		m := values.Receive()
+		if m == nil {
+			return fmt.Errorf(
+				"the run stopped after %d of %d values were agreed", i, n)
+		}
+		vals = append(vals, m.(string))
+		if vals[i] != vals[0] {
+			fmt.Fprintf(c.output(), "uh oh! 2 participants believe "+
+				"different values were agreed (%s versus %s)\n", vals[0],
+				vals[i])
+			return fmt.Errorf(
+				"the following values were agreed, and some of them differ: %v",
+				vals)
+		}
+	}
+
+	fmt.Fprintf(c.output(),
+		"yay! %d values were agreed, and they were all the same (%s)\n", n,
+		vals[0])
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/b9r5/learn-paxos/raft"
+)
+
+func TestThatServersAgreeAfterThePartitionHeals(t *testing.T) {
+	c := raft.Config{NServers: 5, NClients: 3,
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 50 * time.Millisecond,
+		ClientTimeout:     100 * time.Millisecond, Buffer: 2, Drop: 0.1,
+		Partitions: []raft.Partition{{
+			Groups: [][]raft.Node{{raft.ServerNode(0), raft.ServerNode(1),
+				raft.ClientNode(0)}},
+			Heal: time.Second}},
+		Seed: 1, Output: io.Discard}
+
+	metrics, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Decided != 3 {
+		t.Errorf("got %d clients that decided, want 3", metrics.Decided)
+	}
+}
+
+func TestThatRaftServersAgreeDespiteLossAndCrashes(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		c := raft.Config{NServers: 5, NClients: 3,
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 50 * time.Millisecond,
+			ClientTimeout:     100 * time.Millisecond,
+			ChannelTimeout:    10 * time.Millisecond, Buffer: 2, Drop: 0.2,
+			Faults: []raft.ServerFault{
+				{Server: 0, CrashAt: 200 * time.Millisecond},
+				{Server: 1, CrashAt: 100 * time.Millisecond,
+					RestartAt: 900 * time.Millisecond}},
+			Seed: seed, Output: io.Discard}
+
+		metrics, err := c.Run()
+		if err != nil {
+			t.Errorf("seed %d: %v", seed, err)
+		}
+		if metrics.Decided != 3 || metrics.Elections < 1 {
+			t.Errorf("seed %d: got %s, want 3 clients to decide after an "+
+				"election", seed, metrics)
+		}
+	}
+}
+
+func TestThatAConfigMayRunTwiceAtOnce(t *testing.T) {
+	ms := time.Millisecond
+	c := raft.Config{NServers: 3, NClients: 2, ElectionTimeout: 150 * ms,
+		HeartbeatInterval: 50 * ms, ClientTimeout: 100 * ms,
+		ChannelTimeout: 10 * ms, Buffer: 2, Drop: 0.1, Seed: 7,
+		Output: io.Discard}
+
+	want, err := c.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// runs of the same seed take the same steps, even if they overlap
+	var wg sync.WaitGroup
+	got := make([]raft.Metrics, 2)
+	errs := make([]error, 2)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = c.Run()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range got {
+		if errs[i] != nil || got[i].String() != want.String() {
+			t.Errorf("run %d got %s (%v), want %s", i, got[i], errs[i], want)
+		}
+	}
+}
+
+func TestThatRaftRefusesConfigurationsItCannotRun(t *testing.T) {
+	valid := raft.Config{NServers: 3, NClients: 1,
+		ElectionTimeout: time.Second, HeartbeatInterval: time.Second,
+		ClientTimeout: time.Second, Seed: 1, Output: io.Discard}
+
+	noServers := valid
+	noServers.NServers = 0
+	noTimeout := valid
+	noTimeout.HeartbeatInterval = 0
+	badFault := valid
+	badFault.Faults = []raft.ServerFault{{Server: 3}}
+	earlyRestart := valid
+	earlyRestart.Faults = []raft.ServerFault{{Server: 1,
+		CrashAt: 2 * time.Second, RestartAt: time.Second}}
+	badCut := valid
+	badCut.LinkCuts = []raft.LinkCut{{From: raft.ClientNode(0),
+		To: raft.ServerNode(3)}}
+
+	for _, c := range []raft.Config{noServers, noTimeout, badFault,
+		earlyRestart, badCut} {
+		if _, err := c.Run(); err == nil {
+			t.Errorf("run with %d server/s, heartbeat interval %s, faults %v "+
+				"and link cuts %v succeeded", c.NServers, c.HeartbeatInterval,
+				c.Faults, c.LinkCuts)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+// entry is an entry of a Raft log: a value, or a no-op if the value is
+// empty, and the term in which a leader appended it.
+type entry struct {
+	term  int
+	value string
+}
+
+// String returns the string form of a log entry.
+func (e entry) String() string {
+	if e.value == "" {
+		return fmt.Sprintf("(%d, no-op)", e.term)
+	}
+	return fmt.Sprintf("(%d, %s)", e.term, e.value)
+}
+
+// requestVote is the message by which a candidate asks for a server's vote.
+type requestVote struct {
+	term                      int
+	candidateID               int
+	lastLogIndex, lastLogTerm int
+}
+
+// String returns the string form of a requestVote message.
+func (r requestVote) String() string {
+	return fmt.Sprintf("request-vote(%d, %d, %d) from server %d", r.term,
+		r.lastLogIndex, r.lastLogTerm, r.candidateID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (r requestVote) Describe(e *classicpaxos.Event) {
+	e.Type, e.Epoch = "request-vote", fmt.Sprint(r.term)
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (r requestVote) Sender() (Node, bool) {
+	return ServerNode(r.candidateID), true
+}
+
+// vote is the reply to a requestVote message.
+type vote struct {
+	term     int
+	granted  bool
+	serverID int
+}
+
+// String returns the string form of a vote message.
+func (v vote) String() string {
+	return fmt.Sprintf("vote(%d, %t) from server %d", v.term, v.granted,
+		v.serverID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (v vote) Describe(e *classicpaxos.Event) {
+	e.Type, e.Epoch = "vote", fmt.Sprint(v.term)
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (v vote) Sender() (Node, bool) {
+	return ServerNode(v.serverID), true
+}
+
+// appendEntries is the message by which a leader replicates the entries of
+// its log after prevLogIndex, and tells a follower its commit index. With no
+// entries, it is a heartbeat.
+type appendEntries struct {
+	term                      int
+	leaderID                  int
+	prevLogIndex, prevLogTerm int
+	entries                   []entry
+	leaderCommit              int
+}
+
+// String returns the string form of an appendEntries message.
+func (a appendEntries) String() string {
+	return fmt.Sprintf("append-entries(%d, %d, %d, %v, %d) from server %d",
+		a.term, a.prevLogIndex, a.prevLogTerm, a.entries, a.leaderCommit,
+		a.leaderID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (a appendEntries) Describe(e *classicpaxos.Event) {
+	e.Type, e.Epoch = "append-entries", fmt.Sprint(a.term)
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (a appendEntries) Sender() (Node, bool) {
+	return ServerNode(a.leaderID), true
+}
+
+// appendEntriesOK is the reply to an appendEntries message. If success, the
+// follower's log matches the leader's up to matchIndex.
+type appendEntriesOK struct {
+	term       int
+	success    bool
+	matchIndex int
+	serverID   int
+}
+
+// String returns the string form of an appendEntriesOK message.
+func (a appendEntriesOK) String() string {
+	return fmt.Sprintf("append-entries-ok(%d, %t, %d) from server %d", a.term,
+		a.success, a.matchIndex, a.serverID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (a appendEntriesOK) Describe(e *classicpaxos.Event) {
+	e.Type, e.Epoch = "append-entries-ok", fmt.Sprint(a.term)
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (a appendEntriesOK) Sender() (Node, bool) {
+	return ServerNode(a.serverID), true
+}
+
+// clientRequest is the message by which a client proposes its value to a
+// server.
+type clientRequest struct {
+	value    string
+	clientID int
+}
+
+// String returns the string form of a clientRequest message.
+func (c clientRequest) String() string {
+	return fmt.Sprintf("request(%s) from client %d", c.value, c.clientID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (c clientRequest) Describe(e *classicpaxos.Event) {
+	e.Type, e.Value = "request", c.value
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (c clientRequest) Sender() (Node, bool) {
+	return ClientNode(c.clientID), true
+}
+
+// clientReply is the message by which the leader tells a client the value
+// decided.
+type clientReply struct {
+	value    string
+	serverID int
+}
+
+// String returns the string form of a clientReply message.
+func (c clientReply) String() string {
+	return fmt.Sprintf("reply(%s) from server %d", c.value, c.serverID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (c clientReply) Describe(e *classicpaxos.Event) {
+	e.Type, e.Value = "reply", c.value
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (c clientReply) Sender() (Node, bool) {
+	return ServerNode(c.serverID), true
+}
+
+// redirect is the message by which a server that is not the leader tells a
+// client which server it believes is.
+type redirect struct {
+	leaderID int
+	serverID int
+}
+
+// String returns the string form of a redirect message.
+func (r redirect) String() string {
+	return fmt.Sprintf("redirect(%d) from server %d", r.leaderID, r.serverID)
+}
+
+// Describe implements classicpaxos.DescribedMessage.
+func (r redirect) Describe(e *classicpaxos.Event) {
+	e.Type = "redirect"
+}
+
+// Sender implements classicpaxos.DescribedMessage.
+func (r redirect) Sender() (Node, bool) {
+	return ServerNode(r.serverID), true
+}
+
+// Roles of a Raft server.
+const (
+	follower  = iota // server follows a leader
+	candidate        // server asks for votes
+	leader           // server leads its term
+)
+
+// server represents a server in Raft. It keeps its state in memory, and
+// keeps the term, vote and log across crashes as if on stable storage.
+type server struct {
+	id        int                  // server identifier
+	nServers  int                  // number of servers
+	input     classicpaxos.Channel // input channel
+	election  time.Duration        // least election timeout
+	heartbeat time.Duration        // time between heartbeats
+	rand      *rand.Rand           // source of the election timeouts
+	values    classicpaxos.Channel // server places the value it learns here
+	metrics   *recorder            // server counts its elections
+	env       *classicpaxos.Env    // environment whose clock measures timeouts
+	out       io.Writer            // server prints its progress to out
+
+	term     int     // current term
+	votedFor int     // candidate voted for in term, or -1
+	log      []entry // entry at index i is log[i-1]
+
+	role        int           // one of the roles
+	leader      int           // leader of term, or -1 if unknown
+	commitIndex int           // index of the last entry known committed
+	votes       map[int]bool  // servers that voted for the candidate
+	nextIndex   []int         // leader's next entry to send each server
+	matchIndex  []int         // leader's last entry stored by each server
+	pending     map[int]bool  // clients awaiting the leader's reply
+	deadline    time.Duration // time of the next election or heartbeat
+	learned     bool          // whether the server has learned the value
+}
+
+// newServer creates a server with the given parameters and starts its
+// goroutine in the environment env.
+func newServer(env *classicpaxos.Env, id, nServers int,
+	input classicpaxos.Channel,
+	election, heartbeat time.Duration,
+	r *rand.Rand,
+	values classicpaxos.Channel,
+	metrics *recorder,
+	out io.Writer) *server {
+
+	s := &server{
+		id:        id,
+		nServers:  nServers,
+		input:     input,
+		election:  election,
+		heartbeat: heartbeat,
+		rand:      r,
+		values:    values,
+		metrics:   metrics,
+		env:       env,
+		out:       out,
+		votedFor:  -1,
+	}
+	s.follow()
+	env.Spawn(s.run)
+	return s
+}
+
+// run runs the server until its input channel is closed.
+func (s *server) run() {
+	for {
+		if s.env.Now() >= s.deadline {
+			s.timeout()
+			continue
+		}
+
+		m, ok := s.input.ReceiveTimeout(s.deadline - s.env.Now())
+		if !ok {
+			continue
+		}
+		if m == nil {
+			return
+		}
+
+		fmt.Fprintf(s.out, "server %d received message %s\n", s.id, m)
+		s.env.TraceArrival(classicpaxos.EventReceived, ServerNode(s.id), m, "")
+
+		switch msg := m.(type) {
+		case requestVote:
+			s.handleRequestVote(msg)
+		case vote:
+			s.handleVote(msg)
+		case appendEntries:
+			s.handleAppendEntries(msg)
+		case appendEntriesOK:
+			s.handleAppendEntriesOK(msg)
+		case clientRequest:
+			s.handleClientRequest(msg)
+		case classicpaxos.Crash:
+			if !s.awaitRestart() {
+				return
+			}
+			s.follow()
+			s.commitIndex = 0
+		}
+	}
+}
+
+// awaitRestart loses every message sent to the crashed server until it
+// receives a restart message, and returns true; or returns false if its input
+// channel is closed first.
+func (s *server) awaitRestart() bool {
+	for {
+		m := s.input.Receive()
+		if m == nil {
+			return false
+		}
+
+		if msg, ok := m.(classicpaxos.Restart); ok {
+			fmt.Fprintf(s.out, "server %d received message %s\n", s.id, msg)
+			s.env.TraceArrival(classicpaxos.EventReceived, ServerNode(s.id),
+				msg, "")
+			return true
+		}
+
+		fmt.Fprintf(s.out, "server %d is down, lost message %s\n", s.id, m)
+		s.env.TraceArrival(classicpaxos.EventDropped, ServerNode(s.id), m,
+			"server down")
+	}
+}
+
+// follow makes the server a follower of an unknown leader, and sets its
+// election timeout afresh.
+func (s *server) follow() {
+	s.role, s.leader, s.votes = follower, -1, nil
+	s.resetElectionTimeout()
+}
+
+// resetElectionTimeout sets the server's deadline to a pseudo-random time
+// between one and two election timeouts from now.
+func (s *server) resetElectionTimeout() {
+	s.deadline = s.env.Now() + s.election +
+		time.Duration(s.rand.Int63n(int64(s.election)))
+}
+
+// observe moves the server on to term if term is greater than its current
+// term, as a follower that has yet to vote.
+func (s *server) observe(term int) {
+	if term > s.term {
+		s.term, s.votedFor, s.leader = term, -1, -1
+		if s.role != follower {
+			s.follow()
+		}
+	}
+}
+
+// timeout sends heartbeats if the server leads, and otherwise starts an
+// election in the next term.
+func (s *server) timeout() {
+	if s.role == leader {
+		s.toFollowers()
+		s.deadline = s.env.Now() + s.heartbeat
+		return
+	}
+
+	s.term++
+	s.role, s.leader, s.votedFor = candidate, -1, s.id
+	s.votes = map[int]bool{s.id: true}
+	s.resetElectionTimeout()
+	s.metrics.election()
+	fmt.Fprintf(s.out, "server %d starts an election in term %d\n", s.id,
+		s.term)
+	s.env.TraceStep(classicpaxos.EventTimeout, ServerNode(s.id), "")
+
+	last := len(s.log)
+	for id := 0; id < s.nServers; id++ {
+		if id != s.id {
+			s.send(ServerNode(id), requestVote{term: s.term, candidateID: s.id,
+				lastLogIndex: last, lastLogTerm: s.logTerm(last)})
+		}
+	}
+	s.countVotes()
+}
+
+// logTerm returns the term of the entry at index, or 0 if index is 0.
+func (s *server) logTerm(index int) int {
+	if index == 0 {
+		return 0
+	}
+	return s.log[index-1].term
+}
+
+// handleRequestVote grants the server's vote to the candidate of msg, unless
+// the server has voted for another candidate in the term, or its log is more
+// up to date than the candidate's.
+func (s *server) handleRequestVote(msg requestVote) {
+	s.observe(msg.term)
+
+	last := len(s.log)
+	upToDate := msg.lastLogTerm > s.logTerm(last) ||
+		msg.lastLogTerm == s.logTerm(last) && msg.lastLogIndex >= last
+	granted := msg.term == s.term && upToDate &&
+		(s.votedFor == -1 || s.votedFor == msg.candidateID)
+	if granted {
+		s.votedFor = msg.candidateID
+		s.resetElectionTimeout()
+	}
+	s.send(ServerNode(msg.candidateID), vote{term: s.term, granted: granted,
+		serverID: s.id})
+}
+
+// handleVote counts the vote msg, if the server is still a candidate in its
+// term.
+func (s *server) handleVote(msg vote) {
+	s.observe(msg.term)
+	if s.role != candidate || msg.term != s.term || !msg.granted {
+		return
+	}
+	s.votes[msg.serverID] = true
+	s.countVotes()
+}
+
+// countVotes makes the candidate the leader of its term once a majority has
+// voted for it. The leader appends a no-op entry, and starts replicating.
+func (s *server) countVotes() {
+	if 2*len(s.votes) <= s.nServers {
+		return
+	}
+
+	s.role, s.leader, s.votes = leader, s.id, nil
+	s.nextIndex = make([]int, s.nServers)
+	s.matchIndex = make([]int, s.nServers)
+	for id := range s.nextIndex {
+		s.nextIndex[id] = len(s.log) + 1
+	}
+	s.pending = make(map[int]bool)
+	s.log = append(s.log, entry{term: s.term})
+	fmt.Fprintf(s.out, "server %d becomes the leader in term %d\n", s.id,
+		s.term)
+	s.env.TraceStep(classicpaxos.EventEpoch, ServerNode(s.id), "")
+
+	s.toFollowers()
+	s.deadline = s.env.Now() + s.heartbeat
+	s.advanceCommit()
+}
+
+// toFollowers sends every other server the entries of the leader's log that
+// it may not store, or a heartbeat if there are none.
+func (s *server) toFollowers() {
+	for id := 0; id < s.nServers; id++ {
+		if id != s.id {
+			s.replicate(id)
+		}
+	}
+}
+
+// replicate sends the server numbered id the entries of the leader's log from
+// its next index on.
+func (s *server) replicate(id int) {
+	prev := s.nextIndex[id] - 1
+	entries := append([]entry(nil), s.log[prev:]...)
+	s.send(ServerNode(id), appendEntries{term: s.term, leaderID: s.id,
+		prevLogIndex: prev, prevLogTerm: s.logTerm(prev), entries: entries,
+		leaderCommit: s.commitIndex})
+}
+
+// handleAppendEntries stores the entries of msg, if the server's log holds the
+// entry before them, and learns the leader's commit index.
+func (s *server) handleAppendEntries(msg appendEntries) {
+	s.observe(msg.term)
+	if msg.term < s.term {
+		s.send(ServerNode(msg.leaderID), appendEntriesOK{term: s.term,
+			serverID: s.id})
+		return
+	}
+	if s.role != follower {
+		s.follow() // another candidate won the term
+	}
+	s.leader = msg.leaderID
+	s.resetElectionTimeout()
+
+	if msg.prevLogIndex > len(s.log) ||
+		s.logTerm(msg.prevLogIndex) != msg.prevLogTerm {
+
+		s.send(ServerNode(msg.leaderID), appendEntriesOK{term: s.term,
+			serverID: s.id})
+		return
+	}
+
+	for i, e := range msg.entries {
+		index := msg.prevLogIndex + 1 + i
+		if index <= len(s.log) && s.log[index-1].term != e.term {
+			s.log = s.log[:index-1] // conflicts with the leader's log
+		}
+		if index > len(s.log) {
+			s.log = append(s.log, e)
+		}
+	}
+
+	match := msg.prevLogIndex + len(msg.entries)
+	if msg.leaderCommit > s.commitIndex {
+		s.commitIndex = msg.leaderCommit
+		if match < s.commitIndex {
+			s.commitIndex = match
+		}
+	}
+	s.send(ServerNode(msg.leaderID), appendEntriesOK{term: s.term,
+		success: true, matchIndex: match, serverID: s.id})
+	s.learn()
+}
+
+// handleAppendEntriesOK records how much of the leader's log the sender of msg
+// stores, and commits the entries that a majority stores, or, if the sender's
+// log does not match, retries with an earlier entry.
+func (s *server) handleAppendEntriesOK(msg appendEntriesOK) {
+	s.observe(msg.term)
+	if s.role != leader || msg.term != s.term {
+		return
+	}
+
+	id := msg.serverID
+	if !msg.success {
+		if s.nextIndex[id] > 1 {
+			s.nextIndex[id]--
+		}
+		s.replicate(id)
+		return
+	}
+	if msg.matchIndex > s.matchIndex[id] {
+		s.matchIndex[id] = msg.matchIndex
+		s.nextIndex[id] = msg.matchIndex + 1
+	}
+	s.advanceCommit()
+}
+
+// advanceCommit commits the greatest entry of the leader's term that a
+// majority stores, and every entry before it.
+func (s *server) advanceCommit() {
+	for index := len(s.log); index > s.commitIndex; index-- {
+		if s.log[index-1].term != s.term {
+			break // only entries of the leader's term commit by counting
+		}
+		stored := 1 // the leader stores its own log
+		for id, match := range s.matchIndex {
+			if id != s.id && match >= index {
+				stored++
+			}
+		}
+		if 2*stored > s.nServers {
+			s.commitIndex = index
+			s.learn()
+			return
+		}
+	}
+}
+
+// decided returns the value of the first committed entry that is not a
+// no-op, and true, or false if there is none.
+func (s *server) decided() (string, bool) {
+	for _, e := range s.log[:s.commitIndex] {
+		if e.value != "" {
+			return e.value, true
+		}
+	}
+	return "", false
+}
+
+// learn places the value decided on the values channel, once the server knows
+// it, and, if the server leads, replies to the clients that await it.
+func (s *server) learn() {
+	value, ok := s.decided()
+	if !ok {
+		return
+	}
+
+	if !s.learned {
+		s.learned = true
+		fmt.Fprintf(s.out, "server %d learns value %s is decided\n", s.id,
+			value)
+		s.env.TraceStep(classicpaxos.EventDecided, ServerNode(s.id), value)
+		s.values.Send(value)
+	}
+
+	if s.role == leader {
+		for client := range s.pending {
+			s.send(ClientNode(client), clientReply{value: value,
+				serverID: s.id})
+		}
+		s.pending = make(map[int]bool)
+	}
+}
+
+// handleClientRequest appends the value of msg to the leader's log, unless it
+// is already there, and replies once the value decided is committed. A server
+// that is not the leader redirects the client to the leader, if it knows the
+// leader.
+func (s *server) handleClientRequest(msg clientRequest) {
+	if s.role != leader {
+		if s.leader >= 0 {
+			s.send(ClientNode(msg.clientID), redirect{leaderID: s.leader,
+				serverID: s.id})
+		}
+		return
+	}
+
+	s.pending[msg.clientID] = true
+	found := false
+	for _, e := range s.log {
+		found = found || e.value == msg.value
+	}
+	if !found {
+		s.log = append(s.log, entry{term: s.term, value: msg.value})
+		s.toFollowers()
+	}
+	s.advanceCommit()
+	s.learn()
+}
+
+// send sends m to the node to.
+func (s *server) send(to Node, m classicpaxos.Message) {
+	s.env.Send(ServerNode(s.id), to, m)
+}
+
+// client represents a client of Raft, which proposes its value to the
+// leader.
+type client struct {
+	id       int                  // client identifier
+	nServers int                  // number of servers
+	input    classicpaxos.Channel // input channel
+	env      *classicpaxos.Env    // for sending to the servers
+	timeout  time.Duration        // time to wait for a reply before retrying
+	out      io.Writer            // client prints its progress to out
+}
+
+// propose proposes value, first to the server numbered as the client modulo
+// the number of servers, then to the leader that any server names, or after
+// each timeout to the next server. It returns the value decided, or false if
+// its input channel is closed first.
+func (c *client) propose(value string) (string, bool) {
+	server := c.id % c.nServers
+	for {
+		c.env.Send(ClientNode(c.id), ServerNode(server),
+			clientRequest{value: value, clientID: c.id})
+
+		m, ok := c.input.ReceiveTimeout(c.timeout)
+		if !ok {
+			c.env.TraceStep(classicpaxos.EventTimeout, ClientNode(c.id), "")
+			server = (server + 1) % c.nServers
+			continue
+		}
+		if m == nil {
+			return "", false
+		}
+
+		fmt.Fprintf(c.out, "client %d received message %s\n", c.id, m)
+		c.env.TraceArrival(classicpaxos.EventReceived, ClientNode(c.id), m, "")
+
+		switch msg := m.(type) {
+		case clientReply:
+			fmt.Fprintf(c.out, "client %d believes value %s is decided\n", c.id,
+				msg.value)
+			c.env.TraceStep(classicpaxos.EventDecided, ClientNode(c.id),
+				msg.value)
+			return msg.value, true
+		case redirect:
+			server = msg.leaderID
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Benjamin Horowitz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//               http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/b9r5/learn-paxos/internal/classicpaxos"
+)
+
+// newTestServer returns a follower numbered 0 of 3 servers in term, with log,
+// whose replies to the other servers arrive on the returned channel.
+func newTestServer(t *testing.T, term int, log []entry) (*server,
+	classicpaxos.Channel) {
+
+	env, err := classicpaxos.NewEnv(classicpaxos.EnvConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := env.NewChannel(10)
+	env.Connect(ServerNode(1), replies)
+	env.Connect(ServerNode(2), replies)
+	s := &server{id: 0, nServers: 3, env: env, election: time.Second,
+		rand: rand.New(rand.NewSource(1)), values: env.NewChannel(1),
+		out:  io.Discard,
+		term: term, votedFor: -1, log: log, leader: -1}
+	return s, replies
+}
+
+func TestThatFollowersReplaceEntriesThatConflictWithTheLeader(t *testing.T) {
+	s, replies := newTestServer(t, 1, []entry{{1, "a"}, {1, "b"}})
+
+	s.handleAppendEntries(appendEntries{term: 2, leaderID: 1, prevLogIndex: 1,
+		prevLogTerm: 1, entries: []entry{{2, "c"}}, leaderCommit: 1})
+
+	if got, want := fmt.Sprint(s.log), "[(1, a) (2, c)]"; got != want {
+		t.Errorf("got log %s, want %s", got, want)
+	}
+	if s.term != 2 || s.leader != 1 || s.commitIndex != 1 {
+		t.Errorf("got term %d, leader %d and commit index %d, want 2, 1 and 1",
+			s.term, s.leader, s.commitIndex)
+	}
+	want := appendEntriesOK{term: 2, success: true, matchIndex: 2}
+	if got := replies.Receive(); got != want {
+		t.Errorf("got reply %v, want %v", got, want)
+	}
+
+	// the leader's entry before these is not in the log, so the follower
+	// refuses them
+	s.handleAppendEntries(appendEntries{term: 2, leaderID: 1, prevLogIndex: 4,
+		prevLogTerm: 2, entries: []entry{{2, "d"}}})
+	want = appendEntriesOK{term: 2}
+	if got := replies.Receive(); got != want {
+		t.Errorf("got reply %v, want %v", got, want)
+	}
+}
+
+func TestThatServersVoteOnlyForCandidatesWithUpToDateLogs(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     requestVote
+		granted bool
+	}{
+		{"a longer log of an earlier term is not up to date",
+			requestVote{term: 3, candidateID: 1, lastLogIndex: 5, lastLogTerm: 1},
+			false},
+		{"a log of an earlier term is not up to date",
+			requestVote{term: 1, candidateID: 1, lastLogIndex: 2, lastLogTerm: 2},
+			false},
+		{"a log as long, of the same term, is up to date",
+			requestVote{term: 3, candidateID: 1, lastLogIndex: 2, lastLogTerm: 2},
+			true},
+		{"a shorter log of a later term is up to date",
+			requestVote{term: 3, candidateID: 1, lastLogIndex: 1, lastLogTerm: 3},
+			true},
+	}
+
+	for _, test := range tests {
+		s, replies := newTestServer(t, 2, []entry{{1, "a"}, {2, "b"}})
+		s.handleRequestVote(test.msg)
+		got := replies.Receive().(vote)
+		if got.granted != test.granted {
+			t.Errorf("%s: got vote %s, want granted %t", test.name, got,
+				test.granted)
+		}
+	}
+
+	// a server votes for one candidate per term
+	s, replies := newTestServer(t, 2, nil)
+	s.handleRequestVote(requestVote{term: 3, candidateID: 1})
+	s.handleRequestVote(requestVote{term: 3, candidateID: 2})
+	if first := replies.Receive().(vote); !first.granted {
+		t.Errorf("got vote %s for the first candidate, want granted", first)
+	}
+	if second := replies.Receive().(vote); second.granted {
+		t.Errorf("got vote %s for the second candidate, want refused", second)
+	}
+	if s.votedFor != 1 {
+		t.Errorf("voted for %d, want 1", s.votedFor)
+	}
+}